@@ -40,6 +40,11 @@ func New(cnf *config.Config) iface.Backend {
 	return &Backend{Backend: common.NewBackend(cnf), AMQPConnector: common.AMQPConnector{}}
 }
 
+// IsAMQP returns true, task states are consumed when they are read
+func (b *Backend) IsAMQP() bool {
+	return true
+}
+
 // InitGroup creates and saves a group meta data object
 func (b *Backend) InitGroup(groupUUID string, taskUUIDs []string) error {
 	return nil
@@ -186,6 +191,29 @@ func (b *Backend) SetStateFailure(signature *tasks.Signature, err string) error
 	return b.markTaskCompleted(signature, taskState)
 }
 
+// SetStateRevoked updates task state to REVOKED
+func (b *Backend) SetStateRevoked(signature *tasks.Signature) error {
+	taskState := tasks.NewRevokedTaskState(signature)
+
+	if err := b.updateState(taskState); err != nil {
+		return err
+	}
+
+	if signature.GroupUUID == "" {
+		return nil
+	}
+
+	return b.markTaskCompleted(signature, taskState)
+}
+
+// SetStateProgress publishes a STARTED state carrying the progress of the task.
+// Like any other state, it is consumed by the next GetState call.
+func (b *Backend) SetStateProgress(signature *tasks.Signature, progress *tasks.TaskProgress) error {
+	taskState := tasks.NewStartedTaskState(signature)
+	taskState.Progress = progress
+	return b.updateState(taskState)
+}
+
 // GetState returns the latest task state. It will only return the status once
 // as the message will get consumed and removed from the queue.
 func (b *Backend) GetState(taskUUID string) (*tasks.TaskState, error) {
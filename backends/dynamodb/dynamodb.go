@@ -1,6 +1,8 @@
 package dynamodb
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
@@ -9,6 +11,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
@@ -145,7 +148,7 @@ func (b *Backend) TriggerChord(groupUUID string) (bool, error) {
 	return true, err
 }
 
-// SetStatePending ...
+// SetStatePending updates task state to PENDING, a revoked task keeps its state
 func (b *Backend) SetStatePending(signature *tasks.Signature) error {
 	taskState := tasks.NewPendingTaskState(signature)
 	// taskUUID is the primary key of the table, so a new task need to be created first, instead of using dynamodb.UpdateItemInput directly
@@ -184,6 +187,48 @@ func (b *Backend) SetStateFailure(signature *tasks.Signature, err string) error
 	return b.updateToFailureStateWithError(taskState)
 }
 
+// SetStateRevoked updates task state to REVOKED, a completed task keeps its state and results
+func (b *Backend) SetStateRevoked(signature *tasks.Signature) error {
+	taskState := tasks.NewRevokedTaskState(signature)
+	taskState.TTL = b.getExpirationTime()
+	return b.setTaskState(taskState)
+}
+
+// SetStateProgress updates the progress of the task keeping its state
+func (b *Backend) SetStateProgress(signature *tasks.Signature, progress *tasks.TaskProgress) error {
+	av, err := dynamodbattribute.Marshal(progress)
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.UpdateItemInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#S": aws.String("State"),
+			"#P": aws.String("Progress"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":p":       av,
+			":success": {S: aws.String(tasks.StateSuccess)},
+			":failure": {S: aws.String(tasks.StateFailure)},
+			":revoked": {S: aws.String(tasks.StateRevoked)},
+		},
+		Key: map[string]*dynamodb.AttributeValue{
+			"TaskUUID": {
+				S: aws.String(signature.UUID),
+			},
+		},
+		// Do not report progress of a task which has already completed
+		ConditionExpression: aws.String("NOT #S IN (:success, :failure, :revoked)"),
+		TableName:           aws.String(b.cnf.DynamoDB.TaskStatesTable),
+		UpdateExpression:    aws.String("SET #P = :p"),
+	}
+
+	_, err = b.client.UpdateItem(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return nil
+	}
+	return err
+}
+
 // GetState ...
 func (b *Backend) GetState(taskUUID string) (*tasks.TaskState, error) {
 	result, err := b.client.GetItem(&dynamodb.GetItemInput{
@@ -273,6 +318,9 @@ func (b *Backend) batchFetchTaskStates(taskUUIDs []string) ([]*tasks.TaskState,
 	if err := dynamodbattribute.UnmarshalListOfMaps(fetchedKeys, &states); err != nil {
 		return nil, nil, fmt.Errorf("Got error when unmarshal map. Error: %v", err)
 	}
+	for _, state := range states {
+		decodeResultValues(state)
+	}
 
 	// Look for any unprocessed keys
 	var unfetchedKeys []string
@@ -344,6 +392,66 @@ func (b *Backend) getGroupMeta(groupUUID string) (*tasks.GroupMeta, error) {
 	return item, nil
 }
 
+// SaveWorkflow stores the definition of the workflow, it expires like task states
+func (b *Backend) SaveWorkflow(workflow *tasks.Workflow) error {
+	meta, err := tasks.NewWorkflowMeta(workflow)
+	if err != nil {
+		return err
+	}
+	meta.TTL = b.getExpirationTime()
+	av, err := dynamodbattribute.MarshalMap(meta)
+	if err != nil {
+		log.ERROR.Printf("Error when marshaling Dynamodb attributes. Err: %v", err)
+		return err
+	}
+	input := &dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(b.workflowMetasTable()),
+	}
+	_, err = b.client.PutItem(input)
+
+	if err != nil {
+		log.ERROR.Printf("Got error when calling PutItem: %v; Error: %v", input, err)
+		return err
+	}
+	return nil
+}
+
+// GetWorkflow returns the definition of the workflow
+func (b *Backend) GetWorkflow(workflowUUID string) (*tasks.Workflow, error) {
+	result, err := b.client.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(b.workflowMetasTable()),
+		Key: map[string]*dynamodb.AttributeValue{
+			"WorkflowUUID": {
+				S: aws.String(workflowUUID),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		log.ERROR.Printf("Error when getting workflow [%s]. Error: [%s]", workflowUUID, err)
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, fmt.Errorf("Workflow not found: %s", workflowUUID)
+	}
+
+	meta := tasks.WorkflowMeta{}
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &meta); err != nil {
+		log.ERROR.Printf("Got error when unmarshal map. Error: %v", err)
+		return nil, err
+	}
+	return tasks.DecodeWorkflow(meta.Workflow)
+}
+
+// workflowMetasTable returns the table of the workflow definitions
+func (b *Backend) workflowMetasTable() string {
+	if b.cnf.DynamoDB.WorkflowMetasTable == "" {
+		return "workflow_metas"
+	}
+	return b.cnf.DynamoDB.WorkflowMetasTable
+}
+
 func (b *Backend) lockGroupMeta(groupUUID string) error {
 	err := b.updateGroupMetaLock(groupUUID, true)
 	if err != nil {
@@ -454,9 +562,24 @@ func (b *Backend) setTaskState(taskState *tasks.TaskState) error {
 					S: aws.String(r.Type),
 				},
 				"Value": {
-					S: aws.String(fmt.Sprintf("%v", r.Value)),
+					S: aws.String(resultValue(r)),
 				},
 			}
+			if r.Encoding != "" {
+				avMap["Encoding"] = &dynamodb.AttributeValue{
+					S: aws.String(r.Encoding),
+				}
+			}
+			if r.KeyID != "" {
+				avMap["KeyID"] = &dynamodb.AttributeValue{
+					S: aws.String(r.KeyID),
+				}
+			}
+			if r.Ref != "" {
+				avMap["Ref"] = &dynamodb.AttributeValue{
+					S: aws.String(r.Ref),
+				}
+			}
 			rs := &dynamodb.AttributeValue{
 				M: avMap,
 			}
@@ -475,8 +598,16 @@ func (b *Backend) setTaskState(taskState *tasks.TaskState) error {
 		TableName:                 aws.String(b.cnf.DynamoDB.TaskStatesTable),
 		UpdateExpression:          aws.String(exp),
 	}
+	if taskState.IsRevoked() {
+		keepCompleted(input)
+	} else {
+		keepRevoked(input)
+	}
 
 	_, err := b.client.UpdateItem(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return nil
+	}
 
 	if err != nil {
 		return err
@@ -484,16 +615,47 @@ func (b *Backend) setTaskState(taskState *tasks.TaskState) error {
 	return nil
 }
 
+// keepRevoked makes the update leave the state of a revoked task as it is
+func keepRevoked(input *dynamodb.UpdateItemInput) {
+	input.ExpressionAttributeNames["#S"] = aws.String("State")
+	input.ExpressionAttributeValues[":revoked"] = &dynamodb.AttributeValue{
+		S: aws.String(tasks.StateRevoked),
+	}
+	input.ConditionExpression = aws.String("attribute_not_exists(#S) OR #S <> :revoked")
+}
+
+// keepCompleted makes the update leave the state and results of a completed task as they are
+func keepCompleted(input *dynamodb.UpdateItemInput) {
+	input.ExpressionAttributeNames["#S"] = aws.String("State")
+	input.ExpressionAttributeValues[":success"] = &dynamodb.AttributeValue{S: aws.String(tasks.StateSuccess)}
+	input.ExpressionAttributeValues[":failure"] = &dynamodb.AttributeValue{S: aws.String(tasks.StateFailure)}
+	input.ExpressionAttributeValues[":revoked"] = &dynamodb.AttributeValue{S: aws.String(tasks.StateRevoked)}
+	input.ConditionExpression = aws.String("attribute_not_exists(#S) OR NOT #S IN (:success, :failure, :revoked)")
+}
+
+// initTaskState stores the initial state of the task, a revoked task keeps its state
 func (b *Backend) initTaskState(taskState *tasks.TaskState) error {
 	av, err := dynamodbattribute.MarshalMap(taskState)
 	input := &dynamodb.PutItemInput{
 		Item:      av,
 		TableName: aws.String(b.cnf.DynamoDB.TaskStatesTable),
+		ExpressionAttributeNames: map[string]*string{
+			"#S": aws.String("State"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":revoked": {
+				S: aws.String(tasks.StateRevoked),
+			},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(#S) OR #S <> :revoked"),
 	}
 	if err != nil {
 		return err
 	}
 	_, err = b.client.PutItem(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return nil
+	}
 
 	if err != nil {
 		return err
@@ -532,8 +694,12 @@ func (b *Backend) updateToFailureStateWithError(taskState *tasks.TaskState) erro
 		}
 		input.UpdateExpression = aws.String(aws.StringValue(input.UpdateExpression) + ", #T = :t")
 	}
+	keepRevoked(input)
 
 	_, err := b.client.UpdateItem(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return nil
+	}
 
 	if err != nil {
 		return err
@@ -568,9 +734,37 @@ func (b *Backend) unmarshalTaskStateGetItemResult(result *dynamodb.GetItemOutput
 		log.ERROR.Printf("Got error when unmarshal map. Error: %v", err)
 		return nil, err
 	}
+	decodeResultValues(&state)
 	return &state, nil
 }
 
+// resultValue returns the string stored for the value of the result,
+// JSON for registered types and base64 for compressed or encrypted values
+func resultValue(result *tasks.TaskResult) string {
+	if encoded, ok := result.Value.([]byte); ok && result.IsEncoded() {
+		return base64.StdEncoding.EncodeToString(encoded)
+	}
+	if _, ok := tasks.RegisteredType(result.Type); ok {
+		if encoded, err := json.Marshal(result.Value); err == nil {
+			return string(encoded)
+		}
+	}
+	return fmt.Sprintf("%v", result.Value)
+}
+
+// decodeResultValues marks the JSON stored for results of registered types as such,
+// so that they are decoded into their own types
+func decodeResultValues(state *tasks.TaskState) {
+	for _, result := range state.Results {
+		if _, ok := tasks.RegisteredType(result.Type); !ok || result.IsEncoded() || result.Ref != "" {
+			continue
+		}
+		if encoded, ok := result.Value.(string); ok {
+			result.Value = json.RawMessage(encoded)
+		}
+	}
+}
+
 func (b *Backend) checkRequiredTablesIfExist() error {
 	var (
 		taskTableName  = b.cnf.DynamoDB.TaskStatesTable
@@ -42,20 +42,44 @@ func (e ErrTasknotFound) Error() string {
 	return fmt.Sprintf("Task not found: %v", e.taskUUID)
 }
 
+// ErrWorkflowNotFound ...
+type ErrWorkflowNotFound struct {
+	workflowUUID string
+}
+
+// NewErrWorkflowNotFound returns new instance of ErrWorkflowNotFound
+func NewErrWorkflowNotFound(workflowUUID string) ErrWorkflowNotFound {
+	return ErrWorkflowNotFound{workflowUUID: workflowUUID}
+}
+
+// Error implements error interface
+func (e ErrWorkflowNotFound) Error() string {
+	return fmt.Sprintf("Workflow not found: %v", e.workflowUUID)
+}
+
 // Backend represents an "eager" in-memory result backend
 type Backend struct {
 	common.Backend
 	groups     map[string][]string
 	tasks      map[string][]byte
+	workflows  map[string][]byte
 	stateMutex sync.Mutex
+	watchers   common.StateWatchers
 }
 
 // New creates EagerBackend instance
 func New() iface.Backend {
+	return NewWithConfig(new(config.Config))
+}
+
+// NewWithConfig creates EagerBackend instance with the config of the server, so that
+// results encrypted or kept in the blob store by the worker can be decoded
+func NewWithConfig(cnf *config.Config) iface.Backend {
 	return &Backend{
-		Backend: common.NewBackend(new(config.Config)),
-		groups:  make(map[string][]string),
-		tasks:   make(map[string][]byte),
+		Backend:   common.NewBackend(cnf),
+		groups:    make(map[string][]string),
+		tasks:     make(map[string][]byte),
+		workflows: make(map[string][]byte),
 	}
 }
 
@@ -119,44 +143,87 @@ func (b *Backend) TriggerChord(groupUUID string) (bool, error) {
 	return true, nil
 }
 
-// SetStatePending updates task state to PENDING
+// SetStatePending updates task state to PENDING, a revoked task keeps its state
 func (b *Backend) SetStatePending(signature *tasks.Signature) error {
-	state := tasks.NewPendingTaskState(signature)
-	return b.updateState(state)
+	b.stateMutex.Lock()
+	defer b.stateMutex.Unlock()
+	if state, err := b.loadState(signature.UUID); err == nil && state.IsRevoked() {
+		return nil
+	}
+
+	return b.saveState(tasks.NewPendingTaskState(signature))
 }
 
 // SetStateReceived updates task state to RECEIVED
 func (b *Backend) SetStateReceived(signature *tasks.Signature) error {
 	state := tasks.NewReceivedTaskState(signature)
-	return b.updateState(state)
+	return b.transitionState(state)
 }
 
 // SetStateStarted updates task state to STARTED
 func (b *Backend) SetStateStarted(signature *tasks.Signature) error {
 	state := tasks.NewStartedTaskState(signature)
-	return b.updateState(state)
+	return b.transitionState(state)
 }
 
 // SetStateRetry updates task state to RETRY
 func (b *Backend) SetStateRetry(signature *tasks.Signature) error {
 	state := tasks.NewRetryTaskState(signature)
-	return b.updateState(state)
+	return b.transitionState(state)
 }
 
 // SetStateSuccess updates task state to SUCCESS
 func (b *Backend) SetStateSuccess(signature *tasks.Signature, results []*tasks.TaskResult) error {
 	state := tasks.NewSuccessTaskState(signature, results)
-	return b.updateState(state)
+	return b.transitionState(state)
 }
 
 // SetStateFailure updates task state to FAILURE
 func (b *Backend) SetStateFailure(signature *tasks.Signature, err string) error {
 	state := tasks.NewFailureTaskState(signature, err)
-	return b.updateState(state)
+	return b.transitionState(state)
+}
+
+// SetStateRevoked updates task state to REVOKED, a completed task keeps its state and results
+func (b *Backend) SetStateRevoked(signature *tasks.Signature) error {
+	b.stateMutex.Lock()
+	defer b.stateMutex.Unlock()
+	state, err := b.loadState(signature.UUID)
+	if err == nil && state.IsCompleted() {
+		return nil
+	}
+
+	taskState := tasks.NewRevokedTaskState(signature)
+	mergeNewTaskState(state, taskState)
+	return b.saveState(taskState)
+}
+
+// SetStateProgress updates the progress of the task keeping its state
+func (b *Backend) SetStateProgress(signature *tasks.Signature, progress *tasks.TaskProgress) error {
+	b.stateMutex.Lock()
+	defer b.stateMutex.Unlock()
+
+	taskState, err := b.loadState(signature.UUID)
+	if err != nil {
+		taskState = tasks.NewStartedTaskState(signature)
+	}
+	if taskState.IsCompleted() {
+		return nil
+	}
+
+	taskState.Progress = progress
+	return b.saveState(taskState)
 }
 
 // GetState returns the latest task state
 func (b *Backend) GetState(taskUUID string) (*tasks.TaskState, error) {
+	b.stateMutex.Lock()
+	defer b.stateMutex.Unlock()
+	return b.loadState(taskUUID)
+}
+
+// loadState returns the stored task state, the caller must hold stateMutex
+func (b *Backend) loadState(taskUUID string) (*tasks.TaskState, error) {
 	tasktStateBytes, ok := b.tasks[taskUUID]
 	if !ok {
 		return nil, NewErrTasknotFound(taskUUID)
@@ -172,8 +239,15 @@ func (b *Backend) GetState(taskUUID string) (*tasks.TaskState, error) {
 	return state, nil
 }
 
+// WatchState returns a channel signalled whenever the state of the task changes
+func (b *Backend) WatchState(taskUUID string) (<-chan struct{}, func(), error) {
+	return b.watchers.Watch(taskUUID, nil, nil)
+}
+
 // PurgeState deletes stored task state
 func (b *Backend) PurgeState(taskUUID string) error {
+	b.stateMutex.Lock()
+	defer b.stateMutex.Unlock()
 	_, ok := b.tasks[taskUUID]
 	if !ok {
 		return NewErrTasknotFound(taskUUID)
@@ -194,15 +268,61 @@ func (b *Backend) PurgeGroupMeta(groupUUID string) error {
 	return nil
 }
 
-func (b *Backend) updateState(s *tasks.TaskState) error {
-	// simulate the behavior of json marshal/unmarshal
+// SaveWorkflow stores the definition of the workflow
+func (b *Backend) SaveWorkflow(workflow *tasks.Workflow) error {
+	encoded, err := json.Marshal(workflow)
+	if err != nil {
+		return fmt.Errorf("Marshal workflow error: %v", err)
+	}
+
+	b.stateMutex.Lock()
+	defer b.stateMutex.Unlock()
+	b.workflows[workflow.WorkflowUUID] = encoded
+	return nil
+}
+
+// GetWorkflow returns the definition of the workflow
+func (b *Backend) GetWorkflow(workflowUUID string) (*tasks.Workflow, error) {
+	b.stateMutex.Lock()
+	encoded, ok := b.workflows[workflowUUID]
+	b.stateMutex.Unlock()
+	if !ok {
+		return nil, NewErrWorkflowNotFound(workflowUUID)
+	}
+	return tasks.DecodeWorkflow(encoded)
+}
+
+// mergeNewTaskState keeps the creation time, name and progress of the stored task state
+func mergeNewTaskState(state, newState *tasks.TaskState) {
+	if state != nil {
+		newState.CreatedAt = state.CreatedAt
+		newState.TaskName = state.TaskName
+		newState.Progress = state.Progress
+	}
+}
+
+// transitionState saves the new task state merged with the stored one, a revoked
+// task keeps its state
+func (b *Backend) transitionState(s *tasks.TaskState) error {
 	b.stateMutex.Lock()
 	defer b.stateMutex.Unlock()
+	state, err := b.loadState(s.TaskUUID)
+	if err == nil && state.IsRevoked() {
+		return nil
+	}
+
+	mergeNewTaskState(state, s)
+	return b.saveState(s)
+}
+
+// saveState stores the task state, the caller must hold stateMutex
+func (b *Backend) saveState(s *tasks.TaskState) error {
 	msg, err := json.Marshal(s)
 	if err != nil {
 		return fmt.Errorf("Marshal task state error: %v", err)
 	}
 
 	b.tasks[s.TaskUUID] = msg
+	b.watchers.Notify(s.TaskUUID)
 	return nil
 }
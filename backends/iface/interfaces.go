@@ -19,6 +19,8 @@ type Backend interface {
 	SetStateRetry(signature *tasks.Signature) error
 	SetStateSuccess(signature *tasks.Signature, results []*tasks.TaskResult) error
 	SetStateFailure(signature *tasks.Signature, err string) error
+	SetStateRevoked(signature *tasks.Signature) error
+	SetStateProgress(signature *tasks.Signature, progress *tasks.TaskProgress) error
 	GetState(taskUUID string) (*tasks.TaskState, error)
 
 	// Purging stored stored tasks states and group meta data
@@ -26,3 +28,20 @@ type Backend interface {
 	PurgeState(taskUUID string) error
 	PurgeGroupMeta(groupUUID string) error
 }
+
+// StateNotifier is implemented by backends which push notifications about task state
+// changes, so that waiting for a result does not have to poll the backend
+type StateNotifier interface {
+	// WatchState returns a channel signalled whenever the state of the task may have changed
+	// and a function to call when the caller stops watching
+	WatchState(taskUUID string) (<-chan struct{}, func(), error)
+}
+
+// WorkflowBackend is implemented by backends which keep the definitions of workflows, the
+// signatures of the nodes of a workflow only carry its UUID
+type WorkflowBackend interface {
+	// SaveWorkflow stores the definition of the workflow
+	SaveWorkflow(workflow *tasks.Workflow) error
+	// GetWorkflow returns the definition of the workflow
+	GetWorkflow(workflowUUID string) (*tasks.Workflow, error)
+}
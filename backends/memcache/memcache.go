@@ -126,40 +126,72 @@ func (b *Backend) TriggerChord(groupUUID string) (bool, error) {
 	return true, nil
 }
 
-// SetStatePending updates task state to PENDING
+// SetStatePending updates task state to PENDING, a revoked task keeps its state
 func (b *Backend) SetStatePending(signature *tasks.Signature) error {
 	taskState := tasks.NewPendingTaskState(signature)
-	return b.updateState(taskState)
+	return b.modifyState(taskState.TaskUUID, func(state *tasks.TaskState) *tasks.TaskState {
+		if state != nil && state.IsRevoked() {
+			return nil
+		}
+		return taskState
+	})
 }
 
 // SetStateReceived updates task state to RECEIVED
 func (b *Backend) SetStateReceived(signature *tasks.Signature) error {
 	taskState := tasks.NewReceivedTaskState(signature)
-	return b.updateState(taskState)
+	return b.transitionState(taskState)
 }
 
 // SetStateStarted updates task state to STARTED
 func (b *Backend) SetStateStarted(signature *tasks.Signature) error {
 	taskState := tasks.NewStartedTaskState(signature)
-	return b.updateState(taskState)
+	return b.transitionState(taskState)
 }
 
 // SetStateRetry updates task state to RETRY
 func (b *Backend) SetStateRetry(signature *tasks.Signature) error {
 	state := tasks.NewRetryTaskState(signature)
-	return b.updateState(state)
+	return b.transitionState(state)
 }
 
 // SetStateSuccess updates task state to SUCCESS
 func (b *Backend) SetStateSuccess(signature *tasks.Signature, results []*tasks.TaskResult) error {
 	taskState := tasks.NewSuccessTaskState(signature, results)
-	return b.updateState(taskState)
+	return b.transitionState(taskState)
 }
 
 // SetStateFailure updates task state to FAILURE
 func (b *Backend) SetStateFailure(signature *tasks.Signature, err string) error {
 	taskState := tasks.NewFailureTaskState(signature, err)
-	return b.updateState(taskState)
+	return b.transitionState(taskState)
+}
+
+// SetStateRevoked updates task state to REVOKED, a completed task keeps its state and results
+func (b *Backend) SetStateRevoked(signature *tasks.Signature) error {
+	taskState := tasks.NewRevokedTaskState(signature)
+	return b.modifyState(taskState.TaskUUID, func(state *tasks.TaskState) *tasks.TaskState {
+		if state != nil && state.IsCompleted() {
+			return nil
+		}
+		mergeNewTaskState(state, taskState)
+		return taskState
+	})
+}
+
+// SetStateProgress updates the progress of the task keeping its state
+func (b *Backend) SetStateProgress(signature *tasks.Signature, progress *tasks.TaskProgress) error {
+	return b.modifyState(signature.UUID, func(taskState *tasks.TaskState) *tasks.TaskState {
+		if taskState == nil {
+			taskState = tasks.NewStartedTaskState(signature)
+		}
+		if taskState.IsCompleted() {
+			return nil
+		}
+
+		taskState.Progress = progress
+		return taskState
+	})
 }
 
 // GetState returns the latest task state
@@ -189,20 +221,77 @@ func (b *Backend) PurgeGroupMeta(groupUUID string) error {
 	return b.getClient().Delete(groupUUID)
 }
 
-// updateState saves current task state
-func (b *Backend) updateState(taskState *tasks.TaskState) error {
-	encoded, err := json.Marshal(taskState)
-	if err != nil {
-		return err
+// mergeNewTaskState keeps the creation time, name and progress of the stored task state
+func mergeNewTaskState(state, newState *tasks.TaskState) {
+	if state != nil {
+		newState.CreatedAt = state.CreatedAt
+		newState.TaskName = state.TaskName
+		newState.Progress = state.Progress
 	}
+}
 
-	return b.getClient().Set(&gomemcache.Item{
-		Key:        taskState.TaskUUID,
-		Value:      encoded,
-		Expiration: b.getExpirationTimestamp(),
+// transitionState saves the new task state merged with the stored one, a revoked
+// task keeps its state
+func (b *Backend) transitionState(newState *tasks.TaskState) error {
+	return b.modifyState(newState.TaskUUID, func(state *tasks.TaskState) *tasks.TaskState {
+		if state != nil && state.IsRevoked() {
+			return nil
+		}
+		mergeNewTaskState(state, newState)
+		return newState
 	})
 }
 
+// modifyState atomically replaces the stored task state with the one returned by
+// modify, which gets nil if no state is stored. Returning nil keeps the stored state
+func (b *Backend) modifyState(taskUUID string, modify func(*tasks.TaskState) *tasks.TaskState) error {
+	for {
+		var state *tasks.TaskState
+		item, err := b.getClient().Get(taskUUID)
+		if err == gomemcache.ErrCacheMiss {
+			item, err = nil, nil
+		}
+		if err != nil {
+			return err
+		}
+		if item != nil {
+			state = new(tasks.TaskState)
+			decoder := json.NewDecoder(bytes.NewReader(item.Value))
+			decoder.UseNumber()
+			if err := decoder.Decode(state); err != nil {
+				return err
+			}
+		}
+
+		newState := modify(state)
+		if newState == nil {
+			return nil
+		}
+
+		encoded, err := json.Marshal(newState)
+		if err != nil {
+			return err
+		}
+
+		if item == nil {
+			err = b.getClient().Add(&gomemcache.Item{
+				Key:        taskUUID,
+				Value:      encoded,
+				Expiration: b.getExpirationTimestamp(),
+			})
+		} else {
+			item.Value = encoded
+			item.Expiration = b.getExpirationTimestamp()
+			err = b.getClient().CompareAndSwap(item)
+		}
+		// The state changed meanwhile, try again
+		if err == gomemcache.ErrNotStored || err == gomemcache.ErrCASConflict {
+			continue
+		}
+		return err
+	}
+}
+
 // lockGroupMeta acquires lock on group meta data
 func (b *Backend) lockGroupMeta(groupMeta *tasks.GroupMeta) error {
 	groupMeta.Lock = true
@@ -233,6 +322,29 @@ func (b *Backend) unlockGroupMeta(groupMeta *tasks.GroupMeta) error {
 	})
 }
 
+// SaveWorkflow stores the definition of the workflow, it expires like task states
+func (b *Backend) SaveWorkflow(workflow *tasks.Workflow) error {
+	encoded, err := json.Marshal(workflow)
+	if err != nil {
+		return err
+	}
+
+	return b.getClient().Set(&gomemcache.Item{
+		Key:        workflow.WorkflowUUID,
+		Value:      encoded,
+		Expiration: b.getExpirationTimestamp(),
+	})
+}
+
+// GetWorkflow returns the definition of the workflow
+func (b *Backend) GetWorkflow(workflowUUID string) (*tasks.Workflow, error) {
+	item, err := b.getClient().Get(workflowUUID)
+	if err != nil {
+		return nil, err
+	}
+	return tasks.DecodeWorkflow(item.Value)
+}
+
 // getGroupMeta retrieves group meta data, convenience function to avoid repetition
 func (b *Backend) getGroupMeta(groupUUID string) (*tasks.GroupMeta, error) {
 	item, err := b.getClient().Get(groupUUID)
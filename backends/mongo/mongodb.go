@@ -5,11 +5,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
@@ -26,7 +28,12 @@ type Backend struct {
 	client *mongo.Client
 	tc     *mongo.Collection
 	gmc    *mongo.Collection
+	wmc    *mongo.Collection
 	once   sync.Once
+	// Change stream notifying watchers of task state changes, open while any task is watched
+	watchers     common.StateWatchers
+	watchedTasks int
+	stopStream   context.CancelFunc
 }
 
 // New creates Backend instance
@@ -109,32 +116,32 @@ func (b *Backend) TriggerChord(groupUUID string) (bool, error) {
 	return true, nil
 }
 
-// SetStatePending updates task state to PENDING
+// SetStatePending updates task state to PENDING, a revoked task keeps its state
 func (b *Backend) SetStatePending(signature *tasks.Signature) error {
 	update := bson.M{
 		"state":      tasks.StatePending,
 		"task_name":  signature.Name,
 		"created_at": time.Now().UTC(),
 	}
-	return b.updateState(signature, update)
+	return b.transitionState(signature, update)
 }
 
 // SetStateReceived updates task state to RECEIVED
 func (b *Backend) SetStateReceived(signature *tasks.Signature) error {
 	update := bson.M{"state": tasks.StateReceived}
-	return b.updateState(signature, update)
+	return b.transitionState(signature, update)
 }
 
 // SetStateStarted updates task state to STARTED
 func (b *Backend) SetStateStarted(signature *tasks.Signature) error {
 	update := bson.M{"state": tasks.StateStarted}
-	return b.updateState(signature, update)
+	return b.transitionState(signature, update)
 }
 
 // SetStateRetry updates task state to RETRY
 func (b *Backend) SetStateRetry(signature *tasks.Signature) error {
 	update := bson.M{"state": tasks.StateRetry}
-	return b.updateState(signature, update)
+	return b.transitionState(signature, update)
 }
 
 // SetStateSuccess updates task state to SUCCESS
@@ -144,14 +151,20 @@ func (b *Backend) SetStateSuccess(signature *tasks.Signature, results []*tasks.T
 		"state":   tasks.StateSuccess,
 		"results": decodedResults,
 	}
-	return b.updateState(signature, update)
+	return b.transitionState(signature, update)
 }
 
-// decodeResults detects & decodes json strings in TaskResult.Value and returns a new slice
+// decodeResults detects & decodes json strings in TaskResult.Value and returns a new slice.
+// Values of registered types are kept as they are, to be stored as BSON.
 func (b *Backend) decodeResults(results []*tasks.TaskResult) []*tasks.TaskResult {
 	l := len(results)
 	jsonResults := make([]*tasks.TaskResult, l)
 	for i, result := range results {
+		if _, ok := tasks.RegisteredType(result.Type); ok {
+			jsonResults[i] = result
+			continue
+		}
+
 		jsonResult := new(bson.M)
 		resultType := reflect.TypeOf(result.Value).Kind()
 		if resultType == reflect.String {
@@ -172,20 +185,148 @@ func (b *Backend) decodeResults(results []*tasks.TaskResult) []*tasks.TaskResult
 // SetStateFailure updates task state to FAILURE
 func (b *Backend) SetStateFailure(signature *tasks.Signature, err string) error {
 	update := bson.M{"state": tasks.StateFailure, "error": err}
-	return b.updateState(signature, update)
+	return b.transitionState(signature, update)
+}
+
+// SetStateRevoked updates task state to REVOKED, a completed task keeps its state and results
+func (b *Backend) SetStateRevoked(signature *tasks.Signature) error {
+	update := bson.M{"state": tasks.StateRevoked}
+	return b.updateStateUnless(signature, update, tasks.StateSuccess, tasks.StateFailure, tasks.StateRevoked)
+}
+
+// SetStateProgress updates the progress of the task keeping its state
+func (b *Backend) SetStateProgress(signature *tasks.Signature, progress *tasks.TaskProgress) error {
+	update := bson.M{"$set": bson.M{"progress": progress}}
+	filter := bson.M{"_id": signature.UUID, "state": bson.M{"$nin": []string{tasks.StateSuccess, tasks.StateFailure, tasks.StateRevoked}}}
+	_, err := b.tasksCollection().UpdateOne(context.Background(), filter, update)
+	return err
 }
 
 // GetState returns the latest task state
 func (b *Backend) GetState(taskUUID string) (*tasks.TaskState, error) {
-	state := &tasks.TaskState{}
-	err := b.tasksCollection().FindOne(context.Background(), bson.M{"_id": taskUUID}).Decode(state)
+	raw, err := b.tasksCollection().FindOne(context.Background(), bson.M{"_id": taskUUID}).Raw()
 
 	if err != nil {
 		return nil, err
 	}
+	return b.decodeState(raw)
+}
+
+// decodeState decodes a stored task state, decoding results of registered types
+// into their own types rather than generic BSON documents
+func (b *Backend) decodeState(raw bson.Raw) (*tasks.TaskState, error) {
+	state := &tasks.TaskState{}
+	if err := bson.Unmarshal(raw, state); err != nil {
+		return nil, err
+	}
+
+	for i, result := range state.Results {
+		// Compressed and encrypted values are stored as BSON binary data
+		if result.IsEncoded() {
+			if binary, ok := result.Value.(primitive.Binary); ok {
+				result.Value = binary.Data
+			}
+			continue
+		}
+
+		theType, ok := tasks.RegisteredType(result.Type)
+		if !ok {
+			continue
+		}
+		rawValue, err := raw.LookupErr("results", strconv.Itoa(i), "value")
+		if err != nil {
+			return nil, err
+		}
+		value := reflect.New(theType)
+		if err := rawValue.Unmarshal(value.Interface()); err != nil {
+			return nil, fmt.Errorf("BSON unmarshal error: %s", err)
+		}
+		result.Value = value.Elem().Interface()
+	}
+
 	return state, nil
 }
 
+// WatchState returns a channel signalled whenever the state of the task changes. It relies
+// on a change stream of the tasks collection, which requires a replica set or a sharded cluster.
+func (b *Backend) WatchState(taskUUID string) (<-chan struct{}, func(), error) {
+	return b.watchers.Watch(taskUUID, b.startChangeStream, b.stopChangeStream)
+}
+
+// startChangeStream opens the change stream when the first task gets watched
+func (b *Backend) startChangeStream() error {
+	b.watchedTasks++
+	if b.watchedTasks > 1 {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := b.openChangeStream(ctx)
+	if err != nil {
+		cancel()
+		b.watchedTasks--
+		return err
+	}
+	b.stopStream = cancel
+
+	go b.receiveStates(ctx, stream)
+	return nil
+}
+
+// stopChangeStream closes the change stream when the last task stops being watched
+func (b *Backend) stopChangeStream() {
+	b.watchedTasks--
+	if b.watchedTasks == 0 {
+		b.stopStream()
+		b.stopStream = nil
+	}
+}
+
+// openChangeStream watches for inserted, updated and replaced task states
+func (b *Backend) openChangeStream(ctx context.Context) (*mongo.ChangeStream, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"operationType": bson.M{"$in": []string{"insert", "update", "replace"}}}}},
+		{{Key: "$project", Value: bson.M{"documentKey": 1}}},
+	}
+	return b.tasksCollection().Watch(ctx, pipeline)
+}
+
+// receiveStates wakes up the watchers of the changed tasks until the context is cancelled,
+// reopening the change stream when it breaks
+func (b *Backend) receiveStates(ctx context.Context, stream *mongo.ChangeStream) {
+	for {
+		for stream.Next(ctx) {
+			var event struct {
+				DocumentKey struct {
+					ID string `bson:"_id"`
+				} `bson:"documentKey"`
+			}
+			if err := stream.Decode(&event); err == nil {
+				b.watchers.Notify(event.DocumentKey.ID)
+			}
+		}
+		err := stream.Err()
+		stream.Close(context.Background())
+
+		for {
+			if ctx.Err() != nil {
+				return
+			}
+			log.WARNING.Printf("Task state change stream interrupted, waiting for results falls back to polling: %s", err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+
+			if stream, err = b.openChangeStream(ctx); err == nil {
+				break
+			}
+		}
+	}
+}
+
 // PurgeState deletes stored task state
 func (b *Backend) PurgeState(taskUUID string) error {
 	_, err := b.tasksCollection().DeleteOne(context.Background(), bson.M{"_id": taskUUID})
@@ -222,6 +363,28 @@ func (b *Backend) unlockGroupMeta(groupUUID string) error {
 	return err
 }
 
+// SaveWorkflow stores the definition of the workflow, it expires like task states
+func (b *Backend) SaveWorkflow(workflow *tasks.Workflow) error {
+	workflowMeta, err := tasks.NewWorkflowMeta(workflow)
+	if err != nil {
+		return err
+	}
+	_, err = b.workflowMetasCollection().InsertOne(context.Background(), workflowMeta)
+	return err
+}
+
+// GetWorkflow returns the definition of the workflow
+func (b *Backend) GetWorkflow(workflowUUID string) (*tasks.Workflow, error) {
+	workflowMeta := &tasks.WorkflowMeta{}
+	query := bson.M{"_id": workflowUUID}
+
+	err := b.workflowMetasCollection().FindOne(context.Background(), query).Decode(workflowMeta)
+	if err != nil {
+		return nil, err
+	}
+	return tasks.DecodeWorkflow(workflowMeta.Workflow)
+}
+
 // getGroupMeta retrieves group meta data, convenience function to avoid repetition
 func (b *Backend) getGroupMeta(groupUUID string) (*tasks.GroupMeta, error) {
 	groupMeta := &tasks.GroupMeta{}
@@ -244,8 +407,8 @@ func (b *Backend) getStates(taskUUIDs ...string) ([]*tasks.TaskState, error) {
 	defer cur.Close(context.Background())
 
 	for cur.Next(context.Background()) {
-		state := &tasks.TaskState{}
-		if err := cur.Decode(state); err != nil {
+		state, err := b.decodeState(cur.Current)
+		if err != nil {
 			return nil, err
 		}
 		states = append(states, state)
@@ -256,10 +419,20 @@ func (b *Backend) getStates(taskUUIDs ...string) ([]*tasks.TaskState, error) {
 	return states, nil
 }
 
-// updateState saves current task state
-func (b *Backend) updateState(signature *tasks.Signature, update bson.M) error {
+// transitionState saves current task state, a revoked task keeps its state
+func (b *Backend) transitionState(signature *tasks.Signature, update bson.M) error {
+	return b.updateStateUnless(signature, update, tasks.StateRevoked)
+}
+
+// updateStateUnless saves current task state unless the stored state is one of the states
+func (b *Backend) updateStateUnless(signature *tasks.Signature, update bson.M, states ...string) error {
 	update = bson.M{"$set": update}
-	_, err := b.tasksCollection().UpdateOne(context.Background(), bson.M{"_id": signature.UUID}, update, options.Update().SetUpsert(true))
+	filter := bson.M{"_id": signature.UUID, "state": bson.M{"$nin": states}}
+	_, err := b.tasksCollection().UpdateOne(context.Background(), filter, update, options.Update().SetUpsert(true))
+	// The upsert conflicts with the stored state the task keeps
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
 	return err
 }
 
@@ -279,6 +452,14 @@ func (b *Backend) groupMetasCollection() *mongo.Collection {
 	return b.gmc
 }
 
+func (b *Backend) workflowMetasCollection() *mongo.Collection {
+	b.once.Do(func() {
+		b.connect()
+	})
+
+	return b.wmc
+}
+
 // connect creates the underlying mgo connection if it doesn't exist
 // creates required indexes for our collections
 func (b *Backend) connect() error {
@@ -296,6 +477,7 @@ func (b *Backend) connect() error {
 
 	b.tc = b.client.Database(database).Collection("tasks")
 	b.gmc = b.client.Database(database).Collection("group_metas")
+	b.wmc = b.client.Database(database).Collection("workflow_metas")
 
 	err = b.createMongoIndexes(database)
 	if err != nil {
@@ -354,5 +536,15 @@ func (b *Backend) createMongoIndexes(database string) error {
 		return err
 	}
 
+	// Expire workflow definitions after 1 hour by default, like the other backends do
+	workflowExpireIn := expireIn
+	if workflowExpireIn == 0 {
+		workflowExpireIn = config.DefaultResultsExpireIn
+	}
+	workflowMetasCollection := b.client.Database(database).Collection("workflow_metas")
+	_, err = workflowMetasCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.M{"created_at": 1},
+		Options: options.Index().SetBackground(true).SetExpireAfterSeconds(workflowExpireIn),
+	})
 	return err
 }
@@ -121,6 +121,19 @@ func (b *Backend) SetStateFailure(signature *tasks.Signature, err string) error
 	return b.updateState(state)
 }
 
+// SetStateRevoked updates task state to REVOKED
+func (b *Backend) SetStateRevoked(signature *tasks.Signature) error {
+	state := tasks.NewRevokedTaskState(signature)
+	return b.updateState(state)
+}
+
+// SetStateProgress updates the progress of the task keeping its state
+func (b *Backend) SetStateProgress(signature *tasks.Signature, progress *tasks.TaskProgress) error {
+	state := tasks.NewStartedTaskState(signature)
+	state.Progress = progress
+	return b.updateState(state)
+}
+
 // GetState returns the latest task state
 func (b *Backend) GetState(taskUUID string) (*tasks.TaskState, error) {
 	return nil, NewErrTasknotFound(taskUUID)
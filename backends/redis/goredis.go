@@ -30,6 +30,10 @@ type BackendGR struct {
 	socketPath string
 	redsync    *redsync.Redsync
 	redisOnce  sync.Once
+	// Pub/sub connection notifying watchers of task state changes
+	watchers   common.StateWatchers
+	pubsub     *redis.PubSub
+	pubsubOnce sync.Once
 }
 
 // NewGR creates Backend instance
@@ -45,9 +49,10 @@ func NewGR(cnf *config.Config, addrs []string, db int) iface.Backend {
 	}
 
 	ropt := &redis.UniversalOptions{
-		Addrs:    addrs,
-		DB:       db,
-		Password: b.password,
+		Addrs:     addrs,
+		DB:        db,
+		Password:  b.password,
+		TLSConfig: cnf.TLSConfig,
 	}
 	if cnf.Redis != nil {
 		ropt.MasterName = cnf.Redis.MasterName
@@ -151,53 +156,76 @@ func (b *BackendGR) TriggerChord(groupUUID string) (bool, error) {
 	return true, nil
 }
 
-func (b *BackendGR) mergeNewTaskState(newState *tasks.TaskState) {
-	state, err := b.GetState(newState.TaskUUID)
-	if err == nil {
-		newState.CreatedAt = state.CreatedAt
-		newState.TaskName = state.TaskName
-	}
+// transitionState saves the new task state merged with the stored one, a revoked
+// task keeps its state
+func (b *BackendGR) transitionState(newState *tasks.TaskState) error {
+	return b.modifyState(newState.TaskUUID, func(state *tasks.TaskState) *tasks.TaskState {
+		if state != nil && state.IsRevoked() {
+			return nil
+		}
+		mergeNewTaskState(state, newState)
+		return newState
+	})
 }
 
-// SetStatePending updates task state to PENDING
+// SetStatePending updates task state to PENDING, a revoked task keeps its state
 func (b *BackendGR) SetStatePending(signature *tasks.Signature) error {
 	taskState := tasks.NewPendingTaskState(signature)
-	return b.updateState(taskState)
+	return b.modifyState(taskState.TaskUUID, func(state *tasks.TaskState) *tasks.TaskState {
+		if state != nil && state.IsRevoked() {
+			return nil
+		}
+		return taskState
+	})
 }
 
 // SetStateReceived updates task state to RECEIVED
 func (b *BackendGR) SetStateReceived(signature *tasks.Signature) error {
 	taskState := tasks.NewReceivedTaskState(signature)
-	b.mergeNewTaskState(taskState)
-	return b.updateState(taskState)
+	return b.transitionState(taskState)
 }
 
 // SetStateStarted updates task state to STARTED
 func (b *BackendGR) SetStateStarted(signature *tasks.Signature) error {
 	taskState := tasks.NewStartedTaskState(signature)
-	b.mergeNewTaskState(taskState)
-	return b.updateState(taskState)
+	return b.transitionState(taskState)
 }
 
 // SetStateRetry updates task state to RETRY
 func (b *BackendGR) SetStateRetry(signature *tasks.Signature) error {
 	taskState := tasks.NewRetryTaskState(signature)
-	b.mergeNewTaskState(taskState)
-	return b.updateState(taskState)
+	return b.transitionState(taskState)
 }
 
 // SetStateSuccess updates task state to SUCCESS
 func (b *BackendGR) SetStateSuccess(signature *tasks.Signature, results []*tasks.TaskResult) error {
 	taskState := tasks.NewSuccessTaskState(signature, results)
-	b.mergeNewTaskState(taskState)
-	return b.updateState(taskState)
+	return b.transitionState(taskState)
 }
 
 // SetStateFailure updates task state to FAILURE
 func (b *BackendGR) SetStateFailure(signature *tasks.Signature, err string) error {
 	taskState := tasks.NewFailureTaskState(signature, err)
-	b.mergeNewTaskState(taskState)
-	return b.updateState(taskState)
+	return b.transitionState(taskState)
+}
+
+// SetStateRevoked updates task state to REVOKED, a completed task keeps its state and results
+func (b *BackendGR) SetStateRevoked(signature *tasks.Signature) error {
+	taskState := tasks.NewRevokedTaskState(signature)
+	return b.modifyState(taskState.TaskUUID, func(state *tasks.TaskState) *tasks.TaskState {
+		if state != nil && state.IsCompleted() {
+			return nil
+		}
+		mergeNewTaskState(state, taskState)
+		return taskState
+	})
+}
+
+// SetStateProgress updates the progress of the task keeping its state
+func (b *BackendGR) SetStateProgress(signature *tasks.Signature, progress *tasks.TaskProgress) error {
+	return b.modifyState(signature.UUID, func(taskState *tasks.TaskState) *tasks.TaskState {
+		return withProgress(taskState, signature, progress)
+	})
 }
 
 // GetState returns the latest task state
@@ -237,6 +265,25 @@ func (b *BackendGR) PurgeGroupMeta(groupUUID string) error {
 	return nil
 }
 
+// SaveWorkflow stores the definition of the workflow, it expires like task states
+func (b *BackendGR) SaveWorkflow(workflow *tasks.Workflow) error {
+	encoded, err := json.Marshal(workflow)
+	if err != nil {
+		return err
+	}
+
+	return b.rclient.Set(context.Background(), workflow.WorkflowUUID, encoded, b.getExpiration()).Err()
+}
+
+// GetWorkflow returns the definition of the workflow
+func (b *BackendGR) GetWorkflow(workflowUUID string) (*tasks.Workflow, error) {
+	item, err := b.rclient.Get(context.Background(), workflowUUID).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return tasks.DecodeWorkflow(item)
+}
+
 // getGroupMeta retrieves group meta data, convenience function to avoid repetition
 func (b *BackendGR) getGroupMeta(groupUUID string) (*tasks.GroupMeta, error) {
 	item, err := b.rclient.Get(context.Background(), groupUUID).Bytes()
@@ -285,20 +332,54 @@ func (b *BackendGR) getStates(taskUUIDs ...string) ([]*tasks.TaskState, error) {
 	return taskStates, nil
 }
 
-// updateState saves current task state
-func (b *BackendGR) updateState(taskState *tasks.TaskState) error {
-	encoded, err := json.Marshal(taskState)
-	if err != nil {
-		return err
-	}
+// modifyState atomically replaces the stored task state with the one returned by
+// modify, which gets nil if no state is stored. Returning nil keeps the stored state
+func (b *BackendGR) modifyState(taskUUID string, modify func(*tasks.TaskState) *tasks.TaskState) error {
+	ctx := context.Background()
+	var newState *tasks.TaskState
+	txf := func(tx *redis.Tx) error {
+		var state *tasks.TaskState
+		item, err := tx.Get(ctx, taskUUID).Bytes()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if err == nil {
+			state = new(tasks.TaskState)
+			decoder := json.NewDecoder(bytes.NewReader(item))
+			decoder.UseNumber()
+			if err := decoder.Decode(state); err != nil {
+				return err
+			}
+		}
 
-	expiration := b.getExpiration()
-	_, err = b.rclient.Set(context.Background(), taskState.TaskUUID, encoded, expiration).Result()
-	if err != nil {
+		newState = modify(state)
+		if newState == nil {
+			return nil
+		}
+
+		encoded, err := json.Marshal(newState)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, taskUUID, encoded, b.getExpiration())
+			return nil
+		})
 		return err
 	}
 
-	return nil
+	for {
+		err := b.rclient.Watch(ctx, txf, taskUUID)
+		// The state changed after WATCH, try again
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil || newState == nil {
+			return err
+		}
+		return b.rclient.Publish(ctx, stateChannel(taskUUID), newState.State).Err()
+	}
 }
 
 // getExpiration returns expiration for a stored task state
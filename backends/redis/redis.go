@@ -30,6 +30,11 @@ type Backend struct {
 	redsync    *redsync.Redsync
 	redisOnce  sync.Once
 	common.RedisConnector
+	// Pub/sub connection notifying watchers of task state changes
+	watchers    common.StateWatchers
+	pubsub      *redis.PubSubConn
+	pubsubMutex sync.Mutex
+	pubsubOnce  sync.Once
 }
 
 // New creates Backend instance
@@ -148,21 +153,38 @@ func (b *Backend) TriggerChord(groupUUID string) (bool, error) {
 	return true, nil
 }
 
-func (b *Backend) mergeNewTaskState(conn redis.Conn, newState *tasks.TaskState) {
-	state, err := b.getState(conn, newState.TaskUUID)
-	if err == nil {
+func mergeNewTaskState(state, newState *tasks.TaskState) {
+	if state != nil {
 		newState.CreatedAt = state.CreatedAt
 		newState.TaskName = state.TaskName
+		newState.Progress = state.Progress
 	}
 }
 
-// SetStatePending updates task state to PENDING
+// transitionState saves the new task state merged with the stored one, a revoked
+// task keeps its state
+func (b *Backend) transitionState(conn redis.Conn, newState *tasks.TaskState) error {
+	return b.modifyState(conn, newState.TaskUUID, func(state *tasks.TaskState) *tasks.TaskState {
+		if state != nil && state.IsRevoked() {
+			return nil
+		}
+		mergeNewTaskState(state, newState)
+		return newState
+	})
+}
+
+// SetStatePending updates task state to PENDING, a revoked task keeps its state
 func (b *Backend) SetStatePending(signature *tasks.Signature) error {
 	conn := b.open()
 	defer conn.Close()
 
 	taskState := tasks.NewPendingTaskState(signature)
-	return b.updateState(conn, taskState)
+	return b.modifyState(conn, taskState.TaskUUID, func(state *tasks.TaskState) *tasks.TaskState {
+		if state != nil && state.IsRevoked() {
+			return nil
+		}
+		return taskState
+	})
 }
 
 // SetStateReceived updates task state to RECEIVED
@@ -171,8 +193,7 @@ func (b *Backend) SetStateReceived(signature *tasks.Signature) error {
 	defer conn.Close()
 
 	taskState := tasks.NewReceivedTaskState(signature)
-	b.mergeNewTaskState(conn, taskState)
-	return b.updateState(conn, taskState)
+	return b.transitionState(conn, taskState)
 }
 
 // SetStateStarted updates task state to STARTED
@@ -181,8 +202,7 @@ func (b *Backend) SetStateStarted(signature *tasks.Signature) error {
 	defer conn.Close()
 
 	taskState := tasks.NewStartedTaskState(signature)
-	b.mergeNewTaskState(conn, taskState)
-	return b.updateState(conn, taskState)
+	return b.transitionState(conn, taskState)
 }
 
 // SetStateRetry updates task state to RETRY
@@ -191,8 +211,7 @@ func (b *Backend) SetStateRetry(signature *tasks.Signature) error {
 	defer conn.Close()
 
 	taskState := tasks.NewRetryTaskState(signature)
-	b.mergeNewTaskState(conn, taskState)
-	return b.updateState(conn, taskState)
+	return b.transitionState(conn, taskState)
 }
 
 // SetStateSuccess updates task state to SUCCESS
@@ -201,8 +220,7 @@ func (b *Backend) SetStateSuccess(signature *tasks.Signature, results []*tasks.T
 	defer conn.Close()
 
 	taskState := tasks.NewSuccessTaskState(signature, results)
-	b.mergeNewTaskState(conn, taskState)
-	return b.updateState(conn, taskState)
+	return b.transitionState(conn, taskState)
 }
 
 // SetStateFailure updates task state to FAILURE
@@ -211,8 +229,45 @@ func (b *Backend) SetStateFailure(signature *tasks.Signature, err string) error
 	defer conn.Close()
 
 	taskState := tasks.NewFailureTaskState(signature, err)
-	b.mergeNewTaskState(conn, taskState)
-	return b.updateState(conn, taskState)
+	return b.transitionState(conn, taskState)
+}
+
+// SetStateRevoked updates task state to REVOKED, a completed task keeps its state and results
+func (b *Backend) SetStateRevoked(signature *tasks.Signature) error {
+	conn := b.open()
+	defer conn.Close()
+
+	taskState := tasks.NewRevokedTaskState(signature)
+	return b.modifyState(conn, taskState.TaskUUID, func(state *tasks.TaskState) *tasks.TaskState {
+		if state != nil && state.IsCompleted() {
+			return nil
+		}
+		mergeNewTaskState(state, taskState)
+		return taskState
+	})
+}
+
+// SetStateProgress updates the progress of the task keeping its state
+func (b *Backend) SetStateProgress(signature *tasks.Signature, progress *tasks.TaskProgress) error {
+	conn := b.open()
+	defer conn.Close()
+
+	return b.modifyState(conn, signature.UUID, func(taskState *tasks.TaskState) *tasks.TaskState {
+		return withProgress(taskState, signature, progress)
+	})
+}
+
+// withProgress returns the task state with the progress, or nil if the task has completed
+func withProgress(taskState *tasks.TaskState, signature *tasks.Signature, progress *tasks.TaskProgress) *tasks.TaskState {
+	if taskState == nil {
+		taskState = tasks.NewStartedTaskState(signature)
+	}
+	if taskState.IsCompleted() {
+		return nil
+	}
+
+	taskState.Progress = progress
+	return taskState
 }
 
 // GetState returns the latest task state
@@ -264,6 +319,33 @@ func (b *Backend) PurgeGroupMeta(groupUUID string) error {
 	return nil
 }
 
+// SaveWorkflow stores the definition of the workflow, it expires like task states
+func (b *Backend) SaveWorkflow(workflow *tasks.Workflow) error {
+	encoded, err := json.Marshal(workflow)
+	if err != nil {
+		return err
+	}
+
+	conn := b.open()
+	defer conn.Close()
+
+	expiration := int64(b.getExpiration().Seconds())
+	_, err = conn.Do("SET", workflow.WorkflowUUID, encoded, "EX", expiration)
+	return err
+}
+
+// GetWorkflow returns the definition of the workflow
+func (b *Backend) GetWorkflow(workflowUUID string) (*tasks.Workflow, error) {
+	conn := b.open()
+	defer conn.Close()
+
+	item, err := redis.Bytes(conn.Do("GET", workflowUUID))
+	if err != nil {
+		return nil, err
+	}
+	return tasks.DecodeWorkflow(item)
+}
+
 // getGroupMeta retrieves group meta data, convenience function to avoid repetition
 func (b *Backend) getGroupMeta(conn redis.Conn, groupUUID string) (*tasks.GroupMeta, error) {
 
@@ -317,20 +399,48 @@ func (b *Backend) getStates(conn redis.Conn, taskUUIDs ...string) ([]*tasks.Task
 	return taskStates, nil
 }
 
-// updateState saves current task state
-func (b *Backend) updateState(conn redis.Conn, taskState *tasks.TaskState) error {
-	encoded, err := json.Marshal(taskState)
-	if err != nil {
-		return err
-	}
+// modifyState atomically replaces the stored task state with the one returned by
+// modify, which gets nil if no state is stored. Returning nil keeps the stored state
+func (b *Backend) modifyState(conn redis.Conn, taskUUID string, modify func(*tasks.TaskState) *tasks.TaskState) error {
+	for {
+		if _, err := conn.Do("WATCH", taskUUID); err != nil {
+			return err
+		}
 
-	expiration := int64(b.getExpiration().Seconds())
-	_, err = conn.Do("SET", taskState.TaskUUID, encoded, "EX", expiration)
-	if err != nil {
-		return err
-	}
+		state, err := b.getState(conn, taskUUID)
+		if err == redis.ErrNil {
+			state, err = nil, nil
+		}
+		if err != nil {
+			conn.Do("UNWATCH")
+			return err
+		}
 
-	return nil
+		newState := modify(state)
+		if newState == nil {
+			_, err = conn.Do("UNWATCH")
+			return err
+		}
+
+		encoded, err := json.Marshal(newState)
+		if err != nil {
+			conn.Do("UNWATCH")
+			return err
+		}
+
+		expiration := int64(b.getExpiration().Seconds())
+		conn.Send("MULTI")
+		conn.Send("SET", taskUUID, encoded, "EX", expiration)
+		conn.Send("PUBLISH", stateChannel(taskUUID), newState.State)
+		reply, err := conn.Do("EXEC")
+		if err != nil {
+			return err
+		}
+		// A nil reply means the state changed after WATCH, try again
+		if reply != nil {
+			return nil
+		}
+	}
 }
 
 // getExpiration returns expiration for a stored task state
@@ -0,0 +1,128 @@
+package redis
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/oarkflow/machinery/log"
+)
+
+// stateChannelPrefix prefixes the pub/sub channels announcing task state changes.
+// The prefix itself is subscribed to so that the connection can always be pinged.
+const stateChannelPrefix = "machinery_state:"
+
+// statePingPeriod is the period the pub/sub connection is checked at
+const statePingPeriod = 30 * time.Second
+
+// stateChannel returns the pub/sub channel announcing state changes of the task
+func stateChannel(taskUUID string) string {
+	return stateChannelPrefix + taskUUID
+}
+
+// WatchState returns a channel signalled whenever the state of the task changes.
+// All the watched tasks share a single pub/sub connection.
+func (b *Backend) WatchState(taskUUID string) (<-chan struct{}, func(), error) {
+	b.pubsubOnce.Do(func() {
+		go b.receiveStates()
+	})
+
+	channel := stateChannel(taskUUID)
+	return b.watchers.Watch(taskUUID, func() error {
+		b.pubsubMutex.Lock()
+		defer b.pubsubMutex.Unlock()
+		// Not connected, the channel gets subscribed once the connection is back
+		if b.pubsub == nil {
+			return nil
+		}
+		return b.pubsub.Subscribe(channel)
+	}, func() {
+		b.pubsubMutex.Lock()
+		defer b.pubsubMutex.Unlock()
+		if b.pubsub != nil {
+			b.pubsub.Unsubscribe(channel)
+		}
+	})
+}
+
+// receiveStates keeps a pub/sub connection subscribed to the channels of the watched tasks
+// and wakes up their watchers, reconnecting when the connection breaks
+func (b *Backend) receiveStates() {
+	for {
+		pubsub := &redis.PubSubConn{Conn: b.open()}
+		err := pubsub.Subscribe(stateChannelPrefix)
+		if err == nil {
+			b.pubsubMutex.Lock()
+			b.pubsub = pubsub
+			b.pubsubMutex.Unlock()
+
+			for _, taskUUID := range b.watchers.Tasks() {
+				b.pubsubMutex.Lock()
+				pubsub.Subscribe(stateChannel(taskUUID))
+				b.pubsubMutex.Unlock()
+			}
+
+			err = b.receiveStateMessages(pubsub)
+
+			b.pubsubMutex.Lock()
+			b.pubsub = nil
+			b.pubsubMutex.Unlock()
+		}
+		pubsub.Close()
+
+		log.WARNING.Printf("Task state notifications interrupted, waiting for results falls back to polling: %s", err)
+		time.Sleep(time.Second)
+	}
+}
+
+// receiveStateMessages notifies watchers of the messages received until the connection breaks
+func (b *Backend) receiveStateMessages(pubsub *redis.PubSubConn) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		ticker := time.NewTicker(statePingPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				b.pubsubMutex.Lock()
+				pubsub.Ping("")
+				b.pubsubMutex.Unlock()
+			}
+		}
+	}()
+
+	for {
+		switch v := pubsub.ReceiveWithTimeout(2 * statePingPeriod).(type) {
+		case redis.Message:
+			b.watchers.Notify(strings.TrimPrefix(v.Channel, stateChannelPrefix))
+		case error:
+			return v
+		}
+	}
+}
+
+// WatchState returns a channel signalled whenever the state of the task changes.
+// All the watched tasks share a single pub/sub connection.
+func (b *BackendGR) WatchState(taskUUID string) (<-chan struct{}, func(), error) {
+	b.pubsubOnce.Do(func() {
+		b.pubsub = b.rclient.Subscribe(context.Background(), stateChannelPrefix)
+		go func() {
+			for msg := range b.pubsub.Channel() {
+				b.watchers.Notify(strings.TrimPrefix(msg.Channel, stateChannelPrefix))
+			}
+		}()
+	})
+
+	channel := stateChannel(taskUUID)
+	return b.watchers.Watch(taskUUID, func() error {
+		return b.pubsub.Subscribe(context.Background(), channel)
+	}, func() {
+		b.pubsub.Unsubscribe(context.Background(), channel)
+	})
+}
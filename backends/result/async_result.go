@@ -1,11 +1,17 @@
 package result
 
 import (
+	"context"
 	"errors"
 	"reflect"
+	"sync"
 	"time"
 
 	"github.com/oarkflow/machinery/backends/iface"
+	"github.com/oarkflow/machinery/claimcheck"
+	"github.com/oarkflow/machinery/common"
+	"github.com/oarkflow/machinery/config"
+	"github.com/oarkflow/machinery/security"
 	"github.com/oarkflow/machinery/tasks"
 )
 
@@ -14,6 +20,15 @@ var (
 	ErrBackendNotConfigured = errors.New("Result backend not configured")
 	// ErrTimeoutReached ...
 	ErrTimeoutReached = errors.New("Timeout reached")
+	// ErrTaskRevoked ...
+	ErrTaskRevoked = errors.New("Task has been revoked")
+
+	// PollPeriod is the period the backend gets polled at by the methods taking a context
+	PollPeriod = 100 * time.Millisecond
+
+	// NotificationPollPeriod is the minimum period the backend gets polled at while waiting
+	// for a result when the backend notifies about state changes, in case a notification is lost
+	NotificationPollPeriod = 5 * time.Second
 )
 
 // AsyncResult represents a task result
@@ -21,6 +36,9 @@ type AsyncResult struct {
 	Signature *tasks.Signature
 	taskState *tasks.TaskState
 	backend   iface.Backend
+	stateMu   sync.Mutex
+	done      <-chan struct{}
+	doneOnce  sync.Once
 }
 
 // ChordAsyncResult represents a result of a chord
@@ -28,12 +46,24 @@ type ChordAsyncResult struct {
 	groupAsyncResults []*AsyncResult
 	chordAsyncResult  *AsyncResult
 	backend           iface.Backend
+	done              <-chan struct{}
+	doneOnce          sync.Once
 }
 
 // ChainAsyncResult represents a result of a chain of tasks
 type ChainAsyncResult struct {
 	asyncResults []*AsyncResult
 	backend      iface.Backend
+	done         <-chan struct{}
+	doneOnce     sync.Once
+}
+
+// WorkflowAsyncResult represents a result of a workflow
+type WorkflowAsyncResult struct {
+	Workflow         *tasks.Workflow
+	asyncResults     []*AsyncResult
+	nodeAsyncResults map[string]*AsyncResult
+	backend          iface.Backend
 }
 
 // NewAsyncResult creates AsyncResult instance
@@ -76,71 +106,229 @@ func (asyncResult *AsyncResult) Touch() ([]reflect.Value, error) {
 		return nil, ErrBackendNotConfigured
 	}
 
-	asyncResult.GetState()
+	taskState := asyncResult.GetState()
 
 	// Purge state if we are using AMQP backend
-	if asyncResult.backend.IsAMQP() && asyncResult.taskState.IsCompleted() {
-		asyncResult.backend.PurgeState(asyncResult.taskState.TaskUUID)
+	if asyncResult.backend.IsAMQP() && taskState.IsCompleted() {
+		asyncResult.backend.PurgeState(taskState.TaskUUID)
+	}
+
+	if taskState.IsFailure() {
+		return nil, errors.New(taskState.Error)
 	}
 
-	if asyncResult.taskState.IsFailure() {
-		return nil, errors.New(asyncResult.taskState.Error)
+	if taskState.IsRevoked() {
+		return nil, ErrTaskRevoked
 	}
 
-	if asyncResult.taskState.IsSuccess() {
-		return tasks.ReflectTaskResults(asyncResult.taskState.Results)
+	if taskState.IsSuccess() {
+		return tasks.ReflectTaskResults(taskState.Results)
 	}
 
 	return nil, nil
 }
 
+// NewWorkflowAsyncResult creates WorkflowAsyncResult instance
+func NewWorkflowAsyncResult(workflow *tasks.Workflow, backend iface.Backend) *WorkflowAsyncResult {
+	asyncResults := make([]*AsyncResult, len(workflow.NodeNames))
+	nodeAsyncResults := make(map[string]*AsyncResult, len(workflow.NodeNames))
+	for i, name := range workflow.NodeNames {
+		asyncResults[i] = NewAsyncResult(workflow.Nodes[name], backend)
+		nodeAsyncResults[name] = asyncResults[i]
+	}
+	return &WorkflowAsyncResult{
+		Workflow:         workflow,
+		asyncResults:     asyncResults,
+		nodeAsyncResults: nodeAsyncResults,
+		backend:          backend,
+	}
+}
+
 // Get returns task results (synchronous blocking call)
 func (asyncResult *AsyncResult) Get(sleepDuration time.Duration) ([]reflect.Value, error) {
+	return asyncResult.get(context.Background(), sleepDuration)
+}
+
+// GetWithTimeout returns task results with a timeout (synchronous blocking call)
+func (asyncResult *AsyncResult) GetWithTimeout(timeoutDuration, sleepDuration time.Duration) ([]reflect.Value, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutDuration)
+	defer cancel()
+
+	results, err := asyncResult.get(ctx, sleepDuration)
+	if err == context.DeadlineExceeded {
+		return nil, ErrTimeoutReached
+	}
+	return results, err
+}
+
+// GetWithContext returns task results, waiting until the task completes or the context is done
+// (synchronous blocking call)
+func (asyncResult *AsyncResult) GetWithContext(ctx context.Context) ([]reflect.Value, error) {
+	return asyncResult.get(ctx, PollPeriod)
+}
+
+// get waits for the task results until the context is done
+func (asyncResult *AsyncResult) get(ctx context.Context, sleepDuration time.Duration) ([]reflect.Value, error) {
+	notifications, stop := asyncResult.watchState()
+	defer stop()
+
 	for {
 		results, err := asyncResult.Touch()
 
-		if results == nil && err == nil {
-			time.Sleep(sleepDuration)
-		} else {
+		if results != nil || err != nil {
 			return results, err
 		}
+		if !waitState(notifications, ctx.Done(), sleepDuration) {
+			return nil, ctx.Err()
+		}
 	}
 }
 
-// GetWithTimeout returns task results with a timeout (synchronous blocking call)
-func (asyncResult *AsyncResult) GetWithTimeout(timeoutDuration, sleepDuration time.Duration) ([]reflect.Value, error) {
-	timeout := time.NewTimer(timeoutDuration)
+// Done returns a channel closed once the task has completed. The task is waited for in the
+// background from the first call until it completes, see DoneWithContext to stop waiting earlier.
+func (asyncResult *AsyncResult) Done() <-chan struct{} {
+	asyncResult.doneOnce.Do(func() {
+		asyncResult.done = doneChannel(context.Background(), asyncResult)
+	})
+	return asyncResult.done
+}
 
-	for {
-		select {
-		case <-timeout.C:
-			return nil, ErrTimeoutReached
-		default:
-			results, err := asyncResult.Touch()
+// DoneWithContext returns a channel closed once the task has completed. The task is waited
+// for in the background until it completes or the context is done, the channel is never
+// closed in the latter case so callers should select on the context as well.
+func (asyncResult *AsyncResult) DoneWithContext(ctx context.Context) <-chan struct{} {
+	return doneChannel(ctx, asyncResult)
+}
 
-			if results == nil && err == nil {
-				time.Sleep(sleepDuration)
-			} else {
-				return results, err
-			}
+// watchState subscribes to the state changes of the task if the backend notifies about them.
+// Otherwise the returned channel is nil and waiting for the result polls the backend.
+func (asyncResult *AsyncResult) watchState() (<-chan struct{}, func()) {
+	notifier, ok := asyncResult.backend.(iface.StateNotifier)
+	if !ok {
+		return nil, func() {}
+	}
+
+	notifications, stop, err := notifier.WatchState(asyncResult.Signature.UUID)
+	if err != nil {
+		return nil, func() {}
+	}
+
+	return notifications, stop
+}
+
+// watchStates subscribes to the state changes of all the tasks, merging the notifications
+func watchStates(asyncResults []*AsyncResult) (<-chan struct{}, func()) {
+	merged := make(chan struct{}, 1)
+	done := make(chan struct{})
+	stops := make([]func(), 0, len(asyncResults))
+	stopAll := func() {
+		close(done)
+		for _, stop := range stops {
+			stop()
 		}
 	}
+
+	for _, asyncResult := range asyncResults {
+		notifications, stop := asyncResult.watchState()
+		stops = append(stops, stop)
+		// Poll if any of the tasks cannot be watched
+		if notifications == nil {
+			stopAll()
+			return nil, func() {}
+		}
+
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				case <-notifications:
+					select {
+					case merged <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}()
+	}
+
+	return merged, stopAll
+}
+
+// waitState blocks until the state of a task may have changed, that is until a notification
+// arrives or the poll period elapses. It returns false if done gets closed first.
+func waitState(notifications <-chan struct{}, done <-chan struct{}, sleepDuration time.Duration) bool {
+	if notifications != nil && sleepDuration < NotificationPollPeriod {
+		sleepDuration = NotificationPollPeriod
+	}
+
+	poll := time.NewTimer(sleepDuration)
+	defer poll.Stop()
+
+	select {
+	case <-notifications:
+		return true
+	case <-poll.C:
+		return true
+	case <-done:
+		return false
+	}
 }
 
 // GetState returns latest task state
 func (asyncResult *AsyncResult) GetState() *tasks.TaskState {
+	asyncResult.stateMu.Lock()
+	defer asyncResult.stateMu.Unlock()
+
 	if asyncResult.taskState.IsCompleted() {
 		return asyncResult.taskState
 	}
 
 	taskState, err := asyncResult.backend.GetState(asyncResult.Signature.UUID)
 	if err == nil {
+		asyncResult.decodeTaskResults(taskState)
 		asyncResult.taskState = taskState
 	}
 
 	return asyncResult.taskState
 }
 
+// decodeTaskResults loads, decrypts and decompresses the results of the task state. Results
+// which cannot be decoded are kept as they are, so that reflecting them reports the error.
+func (asyncResult *AsyncResult) decodeTaskResults(taskState *tasks.TaskState) {
+	if !taskState.IsSuccess() {
+		return
+	}
+
+	maxSize := config.DefaultMaxDecompressedSize
+	if backend, ok := asyncResult.backend.(interface{ GetConfig() *config.Config }); ok {
+		maxSize = common.MaxDecompressedSize(backend.GetConfig())
+		claimCheck, err := claimcheck.ForConfig(backend.GetConfig())
+		if err != nil || claimCheck.ResolveTaskResults(context.Background(), taskState.Results) != nil {
+			return
+		}
+		sec, err := security.ForConfig(backend.GetConfig())
+		if err != nil || sec.OpenTaskResults(taskState.TaskUUID, taskState.Results) != nil {
+			return
+		}
+	}
+
+	for _, taskResult := range taskState.Results {
+		if taskResult.Decompress(maxSize) != nil {
+			return
+		}
+	}
+}
+
+// Progress returns the latest progress reported by the task, nil if it has not reported any
+func (asyncResult *AsyncResult) Progress() (*tasks.TaskProgress, error) {
+	if asyncResult.backend == nil {
+		return nil, ErrBackendNotConfigured
+	}
+
+	return asyncResult.GetState().Progress, nil
+}
+
 // Get returns results of a chain of tasks (synchronous blocking call)
 func (chainAsyncResult *ChainAsyncResult) Get(sleepDuration time.Duration) ([]reflect.Value, error) {
 	if chainAsyncResult.backend == nil {
@@ -162,6 +350,42 @@ func (chainAsyncResult *ChainAsyncResult) Get(sleepDuration time.Duration) ([]re
 	return results, err
 }
 
+// GetWithContext returns results of a chain of tasks, waiting until the last task completes
+// or the context is done (synchronous blocking call)
+func (chainAsyncResult *ChainAsyncResult) GetWithContext(ctx context.Context) ([]reflect.Value, error) {
+	if chainAsyncResult.backend == nil {
+		return nil, ErrBackendNotConfigured
+	}
+
+	var (
+		results []reflect.Value
+		err     error
+	)
+
+	for _, asyncResult := range chainAsyncResult.asyncResults {
+		results, err = asyncResult.GetWithContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return results, err
+}
+
+// Done returns a channel closed once the last task of the chain has completed or a task
+// failed, see AsyncResult.Done
+func (chainAsyncResult *ChainAsyncResult) Done() <-chan struct{} {
+	chainAsyncResult.doneOnce.Do(func() {
+		chainAsyncResult.done = doneChannel(context.Background(), chainAsyncResult)
+	})
+	return chainAsyncResult.done
+}
+
+// DoneWithContext is like Done but stops waiting once the context is done, see AsyncResult.DoneWithContext
+func (chainAsyncResult *ChainAsyncResult) DoneWithContext(ctx context.Context) <-chan struct{} {
+	return doneChannel(ctx, chainAsyncResult)
+}
+
 // Get returns result of a chord (synchronous blocking call)
 func (chordAsyncResult *ChordAsyncResult) Get(sleepDuration time.Duration) ([]reflect.Value, error) {
 	if chordAsyncResult.backend == nil {
@@ -179,6 +403,38 @@ func (chordAsyncResult *ChordAsyncResult) Get(sleepDuration time.Duration) ([]re
 	return chordAsyncResult.chordAsyncResult.Get(sleepDuration)
 }
 
+// GetWithContext returns result of a chord, waiting until the callback completes
+// or the context is done (synchronous blocking call)
+func (chordAsyncResult *ChordAsyncResult) GetWithContext(ctx context.Context) ([]reflect.Value, error) {
+	if chordAsyncResult.backend == nil {
+		return nil, ErrBackendNotConfigured
+	}
+
+	var err error
+	for _, asyncResult := range chordAsyncResult.groupAsyncResults {
+		_, err = asyncResult.GetWithContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return chordAsyncResult.chordAsyncResult.GetWithContext(ctx)
+}
+
+// Done returns a channel closed once the chord callback has completed or a task of the
+// group failed, see AsyncResult.Done
+func (chordAsyncResult *ChordAsyncResult) Done() <-chan struct{} {
+	chordAsyncResult.doneOnce.Do(func() {
+		chordAsyncResult.done = doneChannel(context.Background(), chordAsyncResult)
+	})
+	return chordAsyncResult.done
+}
+
+// DoneWithContext is like Done but stops waiting once the context is done, see AsyncResult.DoneWithContext
+func (chordAsyncResult *ChordAsyncResult) DoneWithContext(ctx context.Context) <-chan struct{} {
+	return doneChannel(ctx, chordAsyncResult)
+}
+
 // GetWithTimeout returns results of a chain of tasks with timeout (synchronous blocking call)
 func (chainAsyncResult *ChainAsyncResult) GetWithTimeout(timeoutDuration, sleepDuration time.Duration) ([]reflect.Value, error) {
 	if chainAsyncResult.backend == nil {
@@ -190,31 +446,31 @@ func (chainAsyncResult *ChainAsyncResult) GetWithTimeout(timeoutDuration, sleepD
 		err     error
 	)
 
-	timeout := time.NewTimer(timeoutDuration)
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutDuration)
+	defer cancel()
 	ln := len(chainAsyncResult.asyncResults)
 	lastResult := chainAsyncResult.asyncResults[ln-1]
 
-	for {
-		select {
-		case <-timeout.C:
-			return nil, ErrTimeoutReached
-		default:
-
-			for _, asyncResult := range chainAsyncResult.asyncResults {
-				_, err = asyncResult.Touch()
-				if err != nil {
-					return nil, err
-				}
-			}
+	notifications, stop := watchStates(chainAsyncResult.asyncResults)
+	defer stop()
 
-			results, err = lastResult.Touch()
+	for {
+		for _, asyncResult := range chainAsyncResult.asyncResults {
+			_, err = asyncResult.Touch()
 			if err != nil {
 				return nil, err
 			}
-			if results != nil {
-				return results, err
-			}
-			time.Sleep(sleepDuration)
+		}
+
+		results, err = lastResult.Touch()
+		if err != nil {
+			return nil, err
+		}
+		if results != nil {
+			return results, err
+		}
+		if !waitState(notifications, ctx.Done(), sleepDuration) {
+			return nil, ErrTimeoutReached
 		}
 	}
 }
@@ -230,27 +486,103 @@ func (chordAsyncResult *ChordAsyncResult) GetWithTimeout(timeoutDuration, sleepD
 		err     error
 	)
 
-	timeout := time.NewTimer(timeoutDuration)
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutDuration)
+	defer cancel()
+
+	notifications, stop := watchStates(append([]*AsyncResult{chordAsyncResult.chordAsyncResult}, chordAsyncResult.groupAsyncResults...))
+	defer stop()
+
 	for {
-		select {
-		case <-timeout.C:
-			return nil, ErrTimeoutReached
-		default:
-			for _, asyncResult := range chordAsyncResult.groupAsyncResults {
-				_, errcur := asyncResult.Touch()
-				if errcur != nil {
-					return nil, err
-				}
+		for _, asyncResult := range chordAsyncResult.groupAsyncResults {
+			_, errcur := asyncResult.Touch()
+			if errcur != nil {
+				return nil, err
 			}
+		}
+
+		results, err = chordAsyncResult.chordAsyncResult.Touch()
+		if err != nil {
+			return nil, nil
+		}
+		if results != nil {
+			return results, err
+		}
+		if !waitState(notifications, ctx.Done(), sleepDuration) {
+			return nil, ErrTimeoutReached
+		}
+	}
+}
+
+// Node returns the result of the node of the workflow, nil if there is no such node
+func (workflowAsyncResult *WorkflowAsyncResult) Node(name string) *AsyncResult {
+	return workflowAsyncResult.nodeAsyncResults[name]
+}
+
+// GetState returns the latest state of the workflow and of its nodes
+func (workflowAsyncResult *WorkflowAsyncResult) GetState() *tasks.WorkflowState {
+	taskStates := make([]*tasks.TaskState, len(workflowAsyncResult.asyncResults))
+	for i, asyncResult := range workflowAsyncResult.asyncResults {
+		taskStates[i] = asyncResult.GetState()
+	}
+	return tasks.NewWorkflowState(workflowAsyncResult.Workflow, taskStates)
+}
 
-			results, err = chordAsyncResult.chordAsyncResult.Touch()
+// Get returns the results of the leaves of a workflow, in the order the nodes were added
+// (synchronous blocking call)
+func (workflowAsyncResult *WorkflowAsyncResult) Get(sleepDuration time.Duration) ([]reflect.Value, error) {
+	return workflowAsyncResult.get(context.Background(), sleepDuration)
+}
+
+// GetWithTimeout returns the results of the leaves of a workflow with a timeout (synchronous blocking call)
+func (workflowAsyncResult *WorkflowAsyncResult) GetWithTimeout(timeoutDuration, sleepDuration time.Duration) ([]reflect.Value, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutDuration)
+	defer cancel()
+
+	results, err := workflowAsyncResult.get(ctx, sleepDuration)
+	if err == context.DeadlineExceeded {
+		return nil, ErrTimeoutReached
+	}
+	return results, err
+}
+
+// GetWithContext returns the results of the leaves of a workflow, waiting until they complete or
+// the context is done (synchronous blocking call)
+func (workflowAsyncResult *WorkflowAsyncResult) GetWithContext(ctx context.Context) ([]reflect.Value, error) {
+	return workflowAsyncResult.get(ctx, PollPeriod)
+}
+
+// get waits for the results of the leaves until the context is done. It fails as soon as any
+// node fails, as the nodes depending on it never run.
+func (workflowAsyncResult *WorkflowAsyncResult) get(ctx context.Context, sleepDuration time.Duration) ([]reflect.Value, error) {
+	if workflowAsyncResult.backend == nil {
+		return nil, ErrBackendNotConfigured
+	}
+
+	notifications, stop := watchStates(workflowAsyncResult.asyncResults)
+	defer stop()
+
+	leaves := workflowAsyncResult.Workflow.Leaves()
+	for {
+		nodeResults := make(map[string][]reflect.Value, len(workflowAsyncResult.asyncResults))
+		for name, asyncResult := range workflowAsyncResult.nodeAsyncResults {
+			results, err := asyncResult.Touch()
 			if err != nil {
-				return nil, nil
+				return nil, err
 			}
 			if results != nil {
-				return results, err
+				nodeResults[name] = results
 			}
-			time.Sleep(sleepDuration)
+		}
+
+		if len(nodeResults) == len(workflowAsyncResult.asyncResults) {
+			results := make([]reflect.Value, 0, len(leaves))
+			for _, name := range leaves {
+				results = append(results, nodeResults[name]...)
+			}
+			return results, nil
+		}
+		if !waitState(notifications, ctx.Done(), sleepDuration) {
+			return nil, ctx.Err()
 		}
 	}
 }
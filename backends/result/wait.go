@@ -0,0 +1,81 @@
+package result
+
+import (
+	"context"
+	"reflect"
+)
+
+// Waitable is a result which can be waited for, i.e. AsyncResult,
+// ChainAsyncResult or ChordAsyncResult
+type Waitable interface {
+	GetWithContext(ctx context.Context) ([]reflect.Value, error)
+}
+
+// doneChannel returns a channel closed once the result has completed. It is waited for in the
+// background until it completes or the context is done, the channel is not closed in the latter case.
+func doneChannel(ctx context.Context, result Waitable) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		if _, err := result.GetWithContext(ctx); err != nil && ctx.Err() != nil {
+			return
+		}
+		close(done)
+	}()
+	return done
+}
+
+// waitOutcome is the outcome of waiting for one of several results
+type waitOutcome struct {
+	index  int
+	values []reflect.Value
+	err    error
+}
+
+// WaitAll waits for all the results concurrently and returns their values in the same order.
+// It stops waiting as soon as one of them fails or the context is done and returns the error.
+func WaitAll(ctx context.Context, results ...Waitable) ([][]reflect.Value, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	outcomes := waitConcurrently(ctx, results)
+	values := make([][]reflect.Value, len(results))
+	for range results {
+		outcome := <-outcomes
+		if outcome.err != nil {
+			return nil, outcome.err
+		}
+		values[outcome.index] = outcome.values
+	}
+
+	return values, nil
+}
+
+// WaitAny waits for the results concurrently until the first of them completes and returns
+// its index along with its values or error. It returns -1 if the context is done first.
+func WaitAny(ctx context.Context, results ...Waitable) (int, []reflect.Value, error) {
+	if len(results) == 0 {
+		<-ctx.Done()
+		return -1, nil, ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	outcome := <-waitConcurrently(ctx, results)
+	if err := ctx.Err(); err != nil && outcome.err == err {
+		return -1, nil, err
+	}
+	return outcome.index, outcome.values, outcome.err
+}
+
+// waitConcurrently waits for each of the results in its own goroutine until the context is done
+func waitConcurrently(ctx context.Context, results []Waitable) <-chan waitOutcome {
+	outcomes := make(chan waitOutcome, len(results))
+	for i, result := range results {
+		go func(i int, result Waitable) {
+			values, err := result.GetWithContext(ctx)
+			outcomes <- waitOutcome{index: i, values: values, err: err}
+		}(i, result)
+	}
+	return outcomes
+}
@@ -0,0 +1,85 @@
+package eager
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/oarkflow/machinery/blobs/iface"
+)
+
+// sweepInterval is the minimum time between two removals of expired blobs
+const sweepInterval = time.Minute
+
+// Store keeps blobs in memory, it is meant for tests and the eager mode
+type Store struct {
+	mu        sync.RWMutex
+	blobs     map[string][]byte
+	expireAt  map[string]time.Time
+	nextSweep time.Time
+}
+
+// New creates Store instance
+func New() *Store {
+	return &Store{
+		blobs:    make(map[string][]byte),
+		expireAt: make(map[string]time.Time),
+	}
+}
+
+// Put stores a copy of the data under the key
+func (s *Store) Put(ctx context.Context, key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.blobs[key] = append([]byte(nil), data...)
+	delete(s.expireAt, key)
+	return nil
+}
+
+// PutExpiring stores a copy of the data under the key until it expires. Expired blobs are
+// removed when a blob is stored with an expiry, at most once per sweep interval.
+func (s *Store) PutExpiring(ctx context.Context, key string, data []byte, expiresIn time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if !now.Before(s.nextSweep) {
+		for expiredKey, expireAt := range s.expireAt {
+			if !now.Before(expireAt) {
+				delete(s.blobs, expiredKey)
+				delete(s.expireAt, expiredKey)
+			}
+		}
+		s.nextSweep = now.Add(sweepInterval)
+	}
+
+	s.blobs[key] = append([]byte(nil), data...)
+	s.expireAt[key] = now.Add(expiresIn)
+	return nil
+}
+
+// Get returns the data stored under the key
+func (s *Store) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.blobs[key]
+	if !ok {
+		return nil, iface.ErrBlobNotFound
+	}
+	if expireAt, ok := s.expireAt[key]; ok && !time.Now().Before(expireAt) {
+		return nil, iface.ErrBlobNotFound
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// Delete removes the blob stored under the key
+func (s *Store) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.blobs, key)
+	delete(s.expireAt, key)
+	return nil
+}
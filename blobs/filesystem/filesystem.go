@@ -0,0 +1,205 @@
+package filesystem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oarkflow/machinery/blobs/iface"
+	"github.com/oarkflow/machinery/log"
+)
+
+const (
+	// expiringDir is the directory within the store directory keeping the blobs which expire,
+	// their files are modified at the time they expire
+	expiringDir = ".expiring"
+	// sweepInterval is the minimum time between two removals of expired blobs
+	sweepInterval = time.Minute
+	// tempPrefix starts the names of files being written
+	tempPrefix = ".blob-"
+)
+
+// Store keeps blobs as files in a directory, which can be shared by workers on a network file system
+type Store struct {
+	dir       string
+	mu        sync.Mutex
+	nextSweep time.Time
+}
+
+// New creates Store instance keeping blobs in the directory
+func New(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// Put writes the data to the file of the key. The file is written under a temporary name
+// and renamed, so that readers never see a partially written blob.
+func (s *Store) Put(ctx context.Context, key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, data)
+}
+
+// PutExpiring writes the data to the file of the key in the directory of expiring blobs and sets
+// its modification time to the time it expires. Expired blobs are removed in the background when
+// a blob is stored with an expiry, at most once per sweep interval.
+func (s *Store) PutExpiring(ctx context.Context, key string, data []byte, expiresIn time.Duration) error {
+	path, err := s.expiringPath(key)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(path, data); err != nil {
+		return err
+	}
+	expireAt := time.Now().Add(expiresIn)
+	if err := os.Chtimes(path, expireAt, expireAt); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if now := time.Now(); !now.Before(s.nextSweep) {
+		s.nextSweep = now.Add(sweepInterval)
+		go s.sweep()
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// writeFile writes the data to the file under a temporary name and renames it
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), tempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// Get reads the data from the file of the key, or from the file of the key in the
+// directory of expiring blobs if it has not expired
+func (s *Store) Get(ctx context.Context, key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return data, err
+	}
+
+	path, err = s.expiringPath(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, iface.ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(info.ModTime()) {
+		return nil, iface.ErrBlobNotFound
+	}
+
+	data, err = os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, iface.ErrBlobNotFound
+	}
+	return data, err
+}
+
+// Delete removes the file of the key
+func (s *Store) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	expiringPath, err := s.expiringPath(key)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range []string{path, expiringPath} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// sweep removes the files of expired blobs and the directories left empty by them
+func (s *Store) sweep() {
+	now := time.Now()
+	root := filepath.Join(s.dir, expiringDir)
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		// Skip files which are still being written
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), tempPrefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if now.Before(info.ModTime()) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		// Fails unless the directory is empty
+		if dir := filepath.Dir(path); dir != root {
+			os.Remove(dir)
+		}
+		return nil
+	})
+	if err != nil {
+		log.WARNING.Printf("Failed to remove expired blobs from %s: %s", root, err)
+	}
+}
+
+// path returns the path of the file of the key, which must not point outside of the directory
+func (s *Store) path(key string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", fmt.Errorf("Invalid blob key: %s", key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// expiringPath returns the path of the file of the key in the directory of expiring blobs
+func (s *Store) expiringPath(key string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", fmt.Errorf("Invalid blob key: %s", key)
+	}
+	return filepath.Join(s.dir, expiringDir, filepath.FromSlash(key)), nil
+}
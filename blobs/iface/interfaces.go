@@ -0,0 +1,29 @@
+package iface
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrBlobNotFound is returned when no blob is stored under a key
+	ErrBlobNotFound = errors.New("Blob not found")
+)
+
+// Store - keeps task arguments and results which are too large to be passed in messages
+type Store interface {
+	// Put stores the data under the key, replacing the blob stored under it before
+	Put(ctx context.Context, key string, data []byte) error
+	// Get returns the data stored under the key or ErrBlobNotFound
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Delete removes the blob stored under the key, it is not an error if there is none
+	Delete(ctx context.Context, key string) error
+}
+
+// ExpiringStore - a store which removes blobs once they expire, task results are kept with an expiry
+// in stores implementing it
+type ExpiringStore interface {
+	// PutExpiring stores the data under the key like Put, the blob expires after expiresIn
+	PutExpiring(ctx context.Context, key string, data []byte, expiresIn time.Duration) error
+}
@@ -0,0 +1,136 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/oarkflow/machinery/blobs/iface"
+	"github.com/oarkflow/machinery/config"
+)
+
+// defaultRegion is used with S3 compatible endpoints when no region is configured
+const defaultRegion = "us-east-1"
+
+// Store keeps blobs as objects in an S3 bucket or in a bucket of an S3 compatible store such as MinIO
+type Store struct {
+	client *awss3.S3
+	bucket string
+	prefix string
+}
+
+// New creates Store instance keeping blobs in the bucket under the key prefix. It uses the
+// S3 client of the config or creates one from the environment for the configured endpoint.
+func New(cnf *config.Config, bucket, prefix string) *Store {
+	s := &Store{bucket: bucket, prefix: prefix}
+
+	if cnf.ClaimCheck != nil && cnf.ClaimCheck.S3Client != nil {
+		s.client = cnf.ClaimCheck.S3Client
+		return s
+	}
+
+	// Initialize a session that the SDK will use to load credentials from the shared credentials file, ~/.aws/credentials,
+	// or from the environment
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+
+	awsConfig := aws.NewConfig()
+	if cnf.ClaimCheck != nil && cnf.ClaimCheck.S3Endpoint != "" {
+		// S3 compatible stores are usually not set up for virtual-hosted-style buckets
+		awsConfig = awsConfig.WithEndpoint(cnf.ClaimCheck.S3Endpoint).WithS3ForcePathStyle(true)
+		if aws.StringValue(sess.Config.Region) == "" {
+			awsConfig = awsConfig.WithRegion(defaultRegion)
+		}
+	}
+	s.client = awss3.New(sess, awsConfig)
+	return s
+}
+
+// Put uploads the data as the object of the key
+func (s *Store) Put(ctx context.Context, key string, data []byte) error {
+	_, err := s.client.PutObjectWithContext(ctx, &awss3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(key)),
+		Body:   bytes.NewReader(data),
+	})
+	return err
+}
+
+// PutExpiring uploads the data as the object of the key with the time it expires. S3 does not remove
+// expired objects by itself, Get removes them once they are read after they expired. Configure
+// a lifecycle rule on the bucket to remove those which are never read.
+func (s *Store) PutExpiring(ctx context.Context, key string, data []byte, expiresIn time.Duration) error {
+	_, err := s.client.PutObjectWithContext(ctx, &awss3.PutObjectInput{
+		Bucket:  aws.String(s.bucket),
+		Key:     aws.String(s.key(key)),
+		Body:    bytes.NewReader(data),
+		Expires: aws.Time(time.Now().Add(expiresIn)),
+	})
+	return err
+}
+
+// Get downloads the object of the key, an object which has expired is removed instead
+func (s *Store) Get(ctx context.Context, key string) ([]byte, error) {
+	output, err := s.client.GetObjectWithContext(ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(key)),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, iface.ErrBlobNotFound
+		}
+		return nil, err
+	}
+	defer output.Body.Close()
+
+	if output.Expires != nil {
+		expireAt, err := http.ParseTime(aws.StringValue(output.Expires))
+		if err == nil && !time.Now().Before(expireAt) {
+			if err := s.Delete(ctx, key); err != nil {
+				return nil, err
+			}
+			return nil, iface.ErrBlobNotFound
+		}
+	}
+	return io.ReadAll(output.Body)
+}
+
+// Delete removes the object of the key
+func (s *Store) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObjectWithContext(ctx, &awss3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(key)),
+	})
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// key returns the object key of the blob key
+func (s *Store) key(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return path.Join(s.prefix, key)
+}
+
+// isNotFound returns true if the error tells that the object does not exist
+func isNotFound(err error) bool {
+	var requestErr awserr.RequestFailure
+	if errors.As(err, &requestErr) && requestErr.StatusCode() == http.StatusNotFound {
+		return true
+	}
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == awss3.ErrCodeNoSuchKey
+}
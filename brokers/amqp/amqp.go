@@ -1,9 +1,7 @@
 package amqp
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"sync"
 	"time"
@@ -16,6 +14,7 @@ import (
 	"github.com/oarkflow/machinery/common"
 	"github.com/oarkflow/machinery/config"
 	"github.com/oarkflow/machinery/log"
+	"github.com/oarkflow/machinery/security"
 	"github.com/oarkflow/machinery/tasks"
 )
 
@@ -187,9 +186,9 @@ func (b *Broker) Publish(ctx context.Context, signature *tasks.Signature) error
 	// Adjust routing key (this decides which queue the message will be published to)
 	b.AdjustRoutingKey(signature)
 
-	msg, err := json.Marshal(signature)
+	msg, contentType, contentEncoding, err := b.EncodeSignature(signature)
 	if err != nil {
-		return fmt.Errorf("JSON marshal error: %s", err)
+		return fmt.Errorf("Marshal error: %s", err)
 	}
 
 	// Check the ETA signature field, if it is set and it is in the future,
@@ -231,11 +230,12 @@ func (b *Broker) Publish(ctx context.Context, signature *tasks.Signature) error
 		false,                       // mandatory
 		false,                       // immediate
 		amqp.Publishing{
-			Headers:      amqp.Table(signature.Headers),
-			ContentType:  "application/json",
-			Body:         msg,
-			Priority:     signature.Priority,
-			DeliveryMode: amqp.Persistent,
+			Headers:         amqp.Table(signature.Headers),
+			ContentType:     contentType,
+			ContentEncoding: contentEncoding,
+			Body:            msg,
+			Priority:        signature.Priority,
+			DeliveryMode:    amqp.Persistent,
 		},
 	); err != nil {
 		return errors.Wrap(err, "Failed to publish task")
@@ -312,11 +312,16 @@ func (b *Broker) consumeOne(delivery amqp.Delivery, taskProcessor iface.TaskProc
 
 	// Unmarshal message body into signature struct
 	signature := new(tasks.Signature)
-	decoder := json.NewDecoder(bytes.NewReader(delivery.Body))
-	decoder.UseNumber()
-	if err := decoder.Decode(signature); err != nil {
+	if err := b.UnmarshalSignatureContentType(delivery.ContentType, delivery.Body, signature); err != nil {
+		unmarshalErr := errs.NewErrCouldNotUnmarshalTaskSignature(delivery.Body, err)
+		b.deadLetterUnparseable(delivery, unmarshalErr)
 		delivery.Nack(multiple, requeue)
-		return errs.NewErrCouldNotUnmarshalTaskSignature(delivery.Body, err)
+		// Keep consuming, rejected messages must not be able to interrupt the worker
+		if security.IsRejected(err) {
+			log.ERROR.Print(unmarshalErr)
+			return nil
+		}
+		return unmarshalErr
 	}
 
 	// If the task is not registered, we nack it and requeue,
@@ -350,9 +355,9 @@ func (b *Broker) delay(signature *tasks.Signature, delayMs int64) error {
 		return errors.New("Cannot delay task by 0ms")
 	}
 
-	message, err := json.Marshal(signature)
+	message, contentType, contentEncoding, err := b.EncodeSignature(signature)
 	if err != nil {
-		return fmt.Errorf("JSON marshal error: %s", err)
+		return fmt.Errorf("Marshal error: %s", err)
 	}
 
 	queueName := b.GetConfig().AMQP.DelayedQueue
@@ -363,11 +368,12 @@ func (b *Broker) delay(signature *tasks.Signature, delayMs int64) error {
 		"x-dead-letter-routing-key": signature.RoutingKey,
 	}
 	messageProperties := amqp.Publishing{
-		Headers:      amqp.Table(signature.Headers),
-		ContentType:  "application/json",
-		Body:         message,
-		DeliveryMode: amqp.Persistent,
-		Expiration:   fmt.Sprint(delayMs),
+		Headers:         amqp.Table(signature.Headers),
+		ContentType:     contentType,
+		ContentEncoding: contentEncoding,
+		Body:            message,
+		DeliveryMode:    amqp.Persistent,
+		Expiration:      fmt.Sprint(delayMs),
 	}
 
 	if queueName == "" {
@@ -390,10 +396,11 @@ func (b *Broker) delay(signature *tasks.Signature, delayMs int64) error {
 			"x-expires": delayMs * 2,
 		}
 		messageProperties = amqp.Publishing{
-			Headers:      amqp.Table(signature.Headers),
-			ContentType:  "application/json",
-			Body:         message,
-			DeliveryMode: amqp.Persistent,
+			Headers:         amqp.Table(signature.Headers),
+			ContentType:     contentType,
+			ContentEncoding: contentEncoding,
+			Body:            message,
+			DeliveryMode:    amqp.Persistent,
 		}
 	}
 
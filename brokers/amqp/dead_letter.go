@@ -0,0 +1,186 @@
+package amqp
+
+import (
+	"context"
+	"fmt"
+
+	amqp "github.com/oarkflow/amqp/amqp091"
+	"github.com/pkg/errors"
+
+	"github.com/oarkflow/machinery/brokers/errs"
+	"github.com/oarkflow/machinery/log"
+	"github.com/oarkflow/machinery/tasks"
+)
+
+// openDeadLetterQueue connects to the broker and declares the dead-letter queue. Dead letters
+// are routed through the default exchange so that they never reach queues bound to the
+// machinery exchange.
+func (b *Broker) openDeadLetterQueue() (*amqp.Connection, *amqp.Channel, amqp.Queue, <-chan amqp.Confirmation, error) {
+	queueName := b.GetDeadLetterQueue()
+	if queueName == "" {
+		return nil, nil, amqp.Queue{}, nil, errs.ErrDeadLetterQueueNotConfigured
+	}
+
+	conn, channel, _, confirmsChan, _, err := b.Connect(
+		b.GetConfig().Broker,
+		b.GetConfig().MultipleBrokerSeparator,
+		b.GetConfig().TLSConfig,
+		"",    // default exchange
+		"",    // exchange type
+		"",    // the queue is declared below, it can not be bound to the default exchange
+		true,  // queue durable
+		false, // queue delete when unused
+		"",    // queue binding key
+		nil,   // exchange declare args
+		nil,   // queue declare args
+		nil,   // queue binding args
+	)
+	if err != nil {
+		return nil, nil, amqp.Queue{}, nil, err
+	}
+
+	queue, err := channel.QueueDeclare(
+		queueName, // name
+		true,      // durable
+		false,     // delete when unused
+		false,     // exclusive
+		false,     // no-wait
+		nil,       // arguments
+	)
+	if err != nil {
+		b.Close(channel, conn)
+		return nil, nil, amqp.Queue{}, nil, fmt.Errorf("Queue declare error: %s", err)
+	}
+
+	return conn, channel, queue, confirmsChan, nil
+}
+
+// PublishDeadLetter places a failed or unparseable message on the dead-letter queue
+func (b *Broker) PublishDeadLetter(ctx context.Context, signature *tasks.Signature) error {
+	msg, contentType, contentEncoding, err := b.EncodeSignature(signature)
+	if err != nil {
+		return fmt.Errorf("Marshal error: %s", err)
+	}
+
+	conn, channel, queue, confirmsChan, err := b.openDeadLetterQueue()
+	if err != nil {
+		return err
+	}
+	defer b.Close(channel, conn)
+
+	if err := channel.PublishWithContext(
+		ctx,
+		"",         // default exchange
+		queue.Name, // routing key
+		false,      // mandatory
+		false,      // immediate
+		amqp.Publishing{
+			Headers:         amqp.Table(signature.Headers),
+			ContentType:     contentType,
+			ContentEncoding: contentEncoding,
+			Body:            msg,
+			DeliveryMode:    amqp.Persistent,
+		},
+	); err != nil {
+		return errors.Wrap(err, "Failed to publish dead letter")
+	}
+
+	confirmed := <-confirmsChan
+
+	if confirmed.Ack {
+		return nil
+	}
+
+	return fmt.Errorf("Failed delivery of delivery tag: %v", confirmed.DeliveryTag)
+}
+
+// GetDeadLetters returns up to limit messages from the dead-letter queue, all of them when limit is not positive.
+// Messages are fetched without acknowledgement and returned to the queue once the channel is closed.
+func (b *Broker) GetDeadLetters(limit int) ([]*tasks.Signature, error) {
+	conn, channel, queue, _, err := b.openDeadLetterQueue()
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close(channel, conn)
+
+	deadLetters := make([]*tasks.Signature, 0)
+	for i := 0; i < queue.Messages && (limit <= 0 || len(deadLetters) < limit); i++ {
+		d, ok, err := channel.Get(queue.Name, false)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to get from queue")
+		}
+		if !ok {
+			break
+		}
+		if signature := b.decodeDeadLetter(d); signature != nil {
+			deadLetters = append(deadLetters, signature)
+		}
+	}
+
+	return deadLetters, nil
+}
+
+// RemoveDeadLetter removes a message from the dead-letter queue by task UUID and returns it
+func (b *Broker) RemoveDeadLetter(taskUUID string) (*tasks.Signature, error) {
+	conn, channel, queue, _, err := b.openDeadLetterQueue()
+	if err != nil {
+		return nil, err
+	}
+	// Closing the channel returns all other fetched messages to the queue
+	defer b.Close(channel, conn)
+
+	for i := 0; i < queue.Messages; i++ {
+		d, ok, err := channel.Get(queue.Name, false)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to get from queue")
+		}
+		if !ok {
+			break
+		}
+		signature := b.decodeDeadLetter(d)
+		if signature == nil || signature.UUID != taskUUID {
+			continue
+		}
+		if err := d.Ack(false); err != nil {
+			return nil, errors.Wrap(err, "Failed to acknowledge dead letter")
+		}
+		return signature, nil
+	}
+
+	return nil, errs.ErrDeadLetterNotFound
+}
+
+// PurgeDeadLetters deletes all messages from the dead-letter queue
+func (b *Broker) PurgeDeadLetters() error {
+	conn, channel, queue, _, err := b.openDeadLetterQueue()
+	if err != nil {
+		return err
+	}
+	defer b.Close(channel, conn)
+
+	if _, err := channel.QueuePurge(queue.Name, false); err != nil {
+		return errors.Wrapf(err, "Failed to purge queue %s", queue.Name)
+	}
+	return nil
+}
+
+// deadLetterUnparseable keeps a message which could not be unmarshaled in the dead-letter queue
+func (b *Broker) deadLetterUnparseable(delivery amqp.Delivery, err error) {
+	if b.GetDeadLetterQueue() == "" {
+		return
+	}
+	deadLetter := tasks.NewUnparseableDeadLetter(delivery.RoutingKey, delivery.Body, delivery.ContentType, delivery.ContentEncoding, err)
+	if err := b.PublishDeadLetter(context.Background(), deadLetter); err != nil {
+		log.ERROR.Printf("Failed to publish unparseable message to the dead-letter queue: %s", err)
+	}
+}
+
+// decodeDeadLetter unmarshals a message from the dead-letter queue, it returns nil on failure
+func (b *Broker) decodeDeadLetter(d amqp.Delivery) *tasks.Signature {
+	signature := new(tasks.Signature)
+	if err := b.UnmarshalSignatureContentType(d.ContentType, d.Body, signature); err != nil {
+		log.ERROR.Print(errs.NewErrCouldNotUnmarshalTaskSignature(d.Body, err))
+		return nil
+	}
+	return signature
+}
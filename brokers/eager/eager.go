@@ -1,13 +1,12 @@
 package eager
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 
 	"github.com/oarkflow/machinery/brokers/iface"
+	"github.com/oarkflow/machinery/codecs"
 	"github.com/oarkflow/machinery/common"
 	"github.com/oarkflow/machinery/tasks"
 )
@@ -44,18 +43,16 @@ func (eagerBroker *Broker) Publish(ctx context.Context, task *tasks.Signature) e
 		return errors.New("worker is not assigned in eager-mode")
 	}
 
-	// faking the behavior to marshal input into json
+	// faking the behavior to marshal input with the codec
 	// and unmarshal it back
-	message, err := json.Marshal(task)
+	message, err := codecs.Marshal(eagerBroker.GetCodec(), task)
 	if err != nil {
-		return fmt.Errorf("JSON marshal error: %s", err)
+		return fmt.Errorf("Marshal error: %s", err)
 	}
 
 	signature := new(tasks.Signature)
-	decoder := json.NewDecoder(bytes.NewReader(message))
-	decoder.UseNumber()
-	if err := decoder.Decode(signature); err != nil {
-		return fmt.Errorf("JSON unmarshal error: %s", err)
+	if err := codecs.Unmarshal(message, signature); err != nil {
+		return fmt.Errorf("Unmarshal error: %s", err)
 	}
 
 	// blocking call to the task directly
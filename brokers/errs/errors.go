@@ -26,3 +26,12 @@ var ErrConsumerStopped = errors.New("the server has been stopped")
 
 // ErrStopTaskDeletion indicates that the task should not be deleted from source after task failure
 var ErrStopTaskDeletion = errors.New("task should not be deleted")
+
+// ErrMultiQueueNotSupported indicates that the broker cannot consume several queues in a single worker
+var ErrMultiQueueNotSupported = errors.New("broker does not support consuming multiple queues")
+
+// ErrDeadLetterQueueNotConfigured indicates that no dead-letter queue has been configured
+var ErrDeadLetterQueueNotConfigured = errors.New("dead-letter queue is not configured")
+
+// ErrDeadLetterNotFound indicates that the dead-letter queue holds no message for the task
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
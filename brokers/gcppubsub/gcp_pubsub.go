@@ -1,9 +1,7 @@
 package gcppubsub
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"time"
 
@@ -13,9 +11,17 @@ import (
 	"github.com/oarkflow/machinery/common"
 	"github.com/oarkflow/machinery/config"
 	"github.com/oarkflow/machinery/log"
+	"github.com/oarkflow/machinery/security"
 	"github.com/oarkflow/machinery/tasks"
 )
 
+const (
+	// contentTypeAttribute is the message attribute holding the content type of the message
+	contentTypeAttribute = "content-type"
+	// contentEncodingAttribute is the message attribute holding the compression of the message
+	contentEncodingAttribute = "content-encoding"
+)
+
 // Broker represents an Google Cloud Pub/Sub broker
 type Broker struct {
 	common.Broker
@@ -132,9 +138,13 @@ func (b *Broker) Publish(ctx context.Context, signature *tasks.Signature) error
 	// Adjust routing key (this decides which queue the message will be published to)
 	b.AdjustRoutingKey(signature)
 
-	msg, err := json.Marshal(signature)
+	msg, contentType, contentEncoding, err := b.EncodeSignature(signature)
 	if err != nil {
-		return fmt.Errorf("JSON marshal error: %s", err)
+		return fmt.Errorf("Marshal error: %s", err)
+	}
+	attributes := map[string]string{contentTypeAttribute: contentType}
+	if contentEncoding != "" {
+		attributes[contentEncodingAttribute] = contentEncoding
 	}
 
 	topic := b.service.Topic(signature.RoutingKey)
@@ -151,7 +161,8 @@ func (b *Broker) Publish(ctx context.Context, signature *tasks.Signature) error
 	}
 
 	result := topic.Publish(ctx, &pubsub.Message{
-		Data: msg,
+		Data:       msg,
+		Attributes: attributes,
 	})
 
 	id, err := result.Get(ctx)
@@ -172,11 +183,14 @@ func (b *Broker) consumeOne(delivery *pubsub.Message, taskProcessor iface.TaskPr
 	}
 
 	sig := new(tasks.Signature)
-	decoder := json.NewDecoder(bytes.NewBuffer(delivery.Data))
-	decoder.UseNumber()
-	if err := decoder.Decode(sig); err != nil {
+	if err := b.UnmarshalSignatureContentType(delivery.Attributes[contentTypeAttribute], delivery.Data, sig); err != nil {
 		delivery.Nack()
 		log.ERROR.Printf("unmarshal error. the delivery is %v", delivery)
+		// Rejected messages must not reach the task processor
+		if security.IsRejected(err) {
+			log.ERROR.Print(err)
+			return
+		}
 	}
 
 	// If the task is not registered return an error
@@ -20,6 +20,19 @@ type Broker interface {
 	AdjustRoutingKey(s *tasks.Signature)
 }
 
+// DeadLetterBroker - a broker able to keep failed and unparseable messages in a dead-letter queue
+type DeadLetterBroker interface {
+	PublishDeadLetter(ctx context.Context, signature *tasks.Signature) error
+	GetDeadLetters(limit int) ([]*tasks.Signature, error)
+	RemoveDeadLetter(taskUUID string) (*tasks.Signature, error)
+	PurgeDeadLetters() error
+}
+
+// MultiQueueBroker - a broker able to consume several queues for a MultiQueueTaskProcessor
+type MultiQueueBroker interface {
+	SupportsMultiQueue()
+}
+
 // TaskProcessor - can process a delivered task
 // This will probably always be a worker instance
 type TaskProcessor interface {
@@ -27,3 +40,10 @@ type TaskProcessor interface {
 	CustomQueue() string
 	PreConsumeHandler() bool
 }
+
+// MultiQueueTaskProcessor - a task processor consuming several queues
+type MultiQueueTaskProcessor interface {
+	TaskProcessor
+	// NextQueues returns the queues in the order they should be checked for the next message
+	NextQueues() []string
+}
@@ -0,0 +1,217 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/oarkflow/machinery/brokers/errs"
+	"github.com/oarkflow/machinery/common"
+	"github.com/oarkflow/machinery/log"
+	"github.com/oarkflow/machinery/tasks"
+)
+
+// PublishDeadLetter places a failed or unparseable message on the dead-letter queue
+func (b *Broker) PublishDeadLetter(ctx context.Context, signature *tasks.Signature) error {
+	queue := b.GetDeadLetterQueue()
+	if queue == "" {
+		return errs.ErrDeadLetterQueueNotConfigured
+	}
+
+	msg, err := b.MarshalSignature(signature)
+	if err != nil {
+		return fmt.Errorf("Marshal error: %s", err)
+	}
+
+	conn := b.open()
+	defer conn.Close()
+
+	_, err = conn.Do("RPUSH", queue, msg)
+	return err
+}
+
+// GetDeadLetters returns up to limit messages from the dead-letter queue, all of them when limit is not positive
+func (b *Broker) GetDeadLetters(limit int) ([]*tasks.Signature, error) {
+	queue := b.GetDeadLetterQueue()
+	if queue == "" {
+		return nil, errs.ErrDeadLetterQueueNotConfigured
+	}
+
+	conn := b.open()
+	defer conn.Close()
+
+	results, err := redis.ByteSlices(conn.Do("LRANGE", queue, 0, deadLettersStop(limit)))
+	if err != nil {
+		return nil, err
+	}
+
+	deadLetters := make([]*tasks.Signature, 0, len(results))
+	for _, result := range results {
+		if signature := decodeDeadLetter(&b.Broker, result); signature != nil {
+			deadLetters = append(deadLetters, signature)
+		}
+	}
+	return deadLetters, nil
+}
+
+// RemoveDeadLetter removes a message from the dead-letter queue by task UUID and returns it
+func (b *Broker) RemoveDeadLetter(taskUUID string) (*tasks.Signature, error) {
+	queue := b.GetDeadLetterQueue()
+	if queue == "" {
+		return nil, errs.ErrDeadLetterQueueNotConfigured
+	}
+
+	conn := b.open()
+	defer conn.Close()
+
+	results, err := redis.ByteSlices(conn.Do("LRANGE", queue, 0, -1))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, result := range results {
+		signature := decodeDeadLetter(&b.Broker, result)
+		if signature == nil || signature.UUID != taskUUID {
+			continue
+		}
+		// Another client might have removed the message in the meantime
+		removed, err := redis.Int(conn.Do("LREM", queue, 1, result))
+		if err != nil {
+			return nil, err
+		}
+		if removed == 0 {
+			break
+		}
+		return signature, nil
+	}
+	return nil, errs.ErrDeadLetterNotFound
+}
+
+// PurgeDeadLetters deletes all messages from the dead-letter queue
+func (b *Broker) PurgeDeadLetters() error {
+	queue := b.GetDeadLetterQueue()
+	if queue == "" {
+		return errs.ErrDeadLetterQueueNotConfigured
+	}
+
+	conn := b.open()
+	defer conn.Close()
+
+	_, err := conn.Do("DEL", queue)
+	return err
+}
+
+// deadLetterUnparseable keeps a message which could not be unmarshaled in the dead-letter queue
+func (b *Broker) deadLetterUnparseable(queue string, delivery []byte, err error) {
+	if b.GetDeadLetterQueue() == "" {
+		return
+	}
+	if err := b.PublishDeadLetter(context.Background(), tasks.NewUnparseableDeadLetter(queue, delivery, "", "", err)); err != nil {
+		log.ERROR.Printf("Failed to publish unparseable message to the dead-letter queue: %s", err)
+	}
+}
+
+// PublishDeadLetter places a failed or unparseable message on the dead-letter queue
+func (b *BrokerGR) PublishDeadLetter(ctx context.Context, signature *tasks.Signature) error {
+	queue := b.GetDeadLetterQueue()
+	if queue == "" {
+		return errs.ErrDeadLetterQueueNotConfigured
+	}
+
+	msg, err := b.MarshalSignature(signature)
+	if err != nil {
+		return fmt.Errorf("Marshal error: %s", err)
+	}
+
+	return b.rclient.RPush(ctx, queue, msg).Err()
+}
+
+// GetDeadLetters returns up to limit messages from the dead-letter queue, all of them when limit is not positive
+func (b *BrokerGR) GetDeadLetters(limit int) ([]*tasks.Signature, error) {
+	queue := b.GetDeadLetterQueue()
+	if queue == "" {
+		return nil, errs.ErrDeadLetterQueueNotConfigured
+	}
+
+	results, err := b.rclient.LRange(context.Background(), queue, 0, deadLettersStop(limit)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	deadLetters := make([]*tasks.Signature, 0, len(results))
+	for _, result := range results {
+		if signature := decodeDeadLetter(&b.Broker, []byte(result)); signature != nil {
+			deadLetters = append(deadLetters, signature)
+		}
+	}
+	return deadLetters, nil
+}
+
+// RemoveDeadLetter removes a message from the dead-letter queue by task UUID and returns it
+func (b *BrokerGR) RemoveDeadLetter(taskUUID string) (*tasks.Signature, error) {
+	queue := b.GetDeadLetterQueue()
+	if queue == "" {
+		return nil, errs.ErrDeadLetterQueueNotConfigured
+	}
+
+	results, err := b.rclient.LRange(context.Background(), queue, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, result := range results {
+		signature := decodeDeadLetter(&b.Broker, []byte(result))
+		if signature == nil || signature.UUID != taskUUID {
+			continue
+		}
+		// Another client might have removed the message in the meantime
+		removed, err := b.rclient.LRem(context.Background(), queue, 1, result).Result()
+		if err != nil {
+			return nil, err
+		}
+		if removed == 0 {
+			break
+		}
+		return signature, nil
+	}
+	return nil, errs.ErrDeadLetterNotFound
+}
+
+// PurgeDeadLetters deletes all messages from the dead-letter queue
+func (b *BrokerGR) PurgeDeadLetters() error {
+	queue := b.GetDeadLetterQueue()
+	if queue == "" {
+		return errs.ErrDeadLetterQueueNotConfigured
+	}
+
+	return b.rclient.Del(context.Background(), queue).Err()
+}
+
+// deadLetterUnparseable keeps a message which could not be unmarshaled in the dead-letter queue
+func (b *BrokerGR) deadLetterUnparseable(queue string, delivery []byte, err error) {
+	if b.GetDeadLetterQueue() == "" {
+		return
+	}
+	if err := b.PublishDeadLetter(context.Background(), tasks.NewUnparseableDeadLetter(queue, delivery, "", "", err)); err != nil {
+		log.ERROR.Printf("Failed to publish unparseable message to the dead-letter queue: %s", err)
+	}
+}
+
+// deadLettersStop returns the LRANGE stop index to read up to limit messages
+func deadLettersStop(limit int) int64 {
+	if limit <= 0 {
+		return -1
+	}
+	return int64(limit - 1)
+}
+
+// decodeDeadLetter unmarshals a message from the dead-letter queue, it returns nil on failure
+func decodeDeadLetter(b *common.Broker, data []byte) *tasks.Signature {
+	signature := new(tasks.Signature)
+	if err := b.UnmarshalSignature(data, signature); err != nil {
+		log.ERROR.Print(errs.NewErrCouldNotUnmarshalTaskSignature(data, err))
+		return nil
+	}
+	return signature
+}
@@ -1,9 +1,7 @@
 package redis
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"runtime"
 	"strconv"
@@ -19,6 +17,7 @@ import (
 	"github.com/oarkflow/machinery/common"
 	"github.com/oarkflow/machinery/config"
 	"github.com/oarkflow/machinery/log"
+	"github.com/oarkflow/machinery/security"
 	"github.com/oarkflow/machinery/tasks"
 )
 
@@ -29,11 +28,19 @@ type BrokerGR struct {
 	consumingWG  sync.WaitGroup // wait group to make sure whole consumption completes
 	processingWG sync.WaitGroup // use wait group to make sure task processing completes
 	delayedWG    sync.WaitGroup
+	receivingWG  sync.WaitGroup
+	reliableWG   sync.WaitGroup
 	// If set, path to a socket file overrides hostname
 	socketPath           string
 	redsync              *redsync.Redsync
 	redisOnce            sync.Once
 	redisDelayedTasksKey string
+	// queue and consumerID are set when the reliable queue is enabled,
+	// see isReliableQueue
+	queue      string
+	consumerID string
+	// priority orders the lists of a prioritized queue, see getPriorityLevels
+	priority priorityScheduler
 }
 
 // NewGR creates new Broker instance
@@ -49,16 +56,17 @@ func NewGR(cnf *config.Config, addrs []string, db int) iface.Broker {
 	}
 
 	ropt := &redis.UniversalOptions{
-		Addrs:    addrs,
-		DB:       db,
-		Password: password,
+		Addrs:     addrs,
+		DB:        db,
+		Password:  password,
+		TLSConfig: cnf.TLSConfig,
 	}
 	if cnf.Redis != nil {
 		ropt.MasterName = cnf.Redis.MasterName
 	}
 
 	b.rclient = redis.NewUniversalClient(ropt)
-	if cnf.Redis.DelayedTasksKey != "" {
+	if cnf.Redis != nil && cnf.Redis.DelayedTasksKey != "" {
 		b.redisDelayedTasksKey = cnf.Redis.DelayedTasksKey
 	} else {
 		b.redisDelayedTasksKey = defaultRedisDelayedTasksKey
@@ -92,8 +100,26 @@ func (b *BrokerGR) StartConsuming(consumerTag string, concurrency int, taskProce
 		return b.GetRetry(), errs.ErrConsumerStopped
 	}
 
+	queue := getQueueGR(b.GetConfig(), taskProcessor)
+
+	// Register this consumer so that its in-flight messages can be recovered
+	// by other consumers in case it dies
+	if isReliableQueue(b.GetConfig()) && b.consumerID == "" {
+		if err := b.registerConsumer(consumerTag, queue); err != nil {
+			return b.GetRetry(), err
+		}
+
+		b.reliableWG.Add(1)
+		go func() {
+			defer b.reliableWG.Done()
+			b.monitorConsumers()
+		}()
+	}
+
 	// Channel to which we will push tasks ready for processing by worker
 	deliveries := make(chan []byte, concurrency)
+	// Closed once consume has returned, nobody takes deliveries after that
+	consumeDone := make(chan struct{})
 	pool := make(chan struct{}, concurrency)
 
 	// initialize worker pool with maxWorkers workers
@@ -102,9 +128,13 @@ func (b *BrokerGR) StartConsuming(consumerTag string, concurrency int, taskProce
 	}
 
 	// A receiving goroutine keeps popping messages from the queue by BLPOP
+	// (or BLMOVE when the reliable queue is enabled)
 	// If the message is valid and can be unmarshaled into a proper structure
 	// we send it to the deliveries channel
+	b.receivingWG.Add(1)
 	go func() {
+		defer b.receivingWG.Done()
+		defer close(deliveries)
 
 		log.INFO.Print("[*] Waiting for messages. To exit press CTRL+C")
 
@@ -112,13 +142,14 @@ func (b *BrokerGR) StartConsuming(consumerTag string, concurrency int, taskProce
 			select {
 			// A way to stop this goroutine from b.StopConsuming
 			case <-b.GetStopChan():
-				close(deliveries)
+				return
+			case <-consumeDone:
 				return
 			case <-pool:
-				task, _ := b.nextTask(getQueueGR(b.GetConfig(), taskProcessor))
+				task, _ := b.nextTask(getQueuesGR(b.GetConfig(), taskProcessor))
 				// TODO: should this error be ignored?
-				if len(task) > 0 {
-					deliveries <- task
+				if len(task) > 0 && !b.deliver(deliveries, consumeDone, task, taskProcessor) {
+					return
 				}
 
 				pool <- struct{}{}
@@ -144,10 +175,9 @@ func (b *BrokerGR) StartConsuming(consumerTag string, concurrency int, taskProce
 				}
 
 				signature := new(tasks.Signature)
-				decoder := json.NewDecoder(bytes.NewReader(task))
-				decoder.UseNumber()
-				if err := decoder.Decode(signature); err != nil {
+				if err := b.UnmarshalSignature(task, signature); err != nil {
 					log.ERROR.Print(errs.NewErrCouldNotUnmarshalTaskSignature(task, err))
+					continue
 				}
 
 				if err := b.Publish(context.Background(), signature); err != nil {
@@ -157,7 +187,17 @@ func (b *BrokerGR) StartConsuming(consumerTag string, concurrency int, taskProce
 		}
 	}()
 
-	if err := b.consume(deliveries, concurrency, taskProcessor); err != nil {
+	err = b.consume(deliveries, concurrency, taskProcessor)
+	close(consumeDone)
+
+	// Give messages delivered after consume returned back to the queue
+	b.receivingWG.Wait()
+	for d := range deliveries {
+		b.requeueMessage(d, taskProcessor)
+		b.ackMessage(d)
+	}
+
+	if err != nil {
 		return b.GetRetry(), err
 	}
 
@@ -167,6 +207,9 @@ func (b *BrokerGR) StartConsuming(consumerTag string, concurrency int, taskProce
 	return b.GetRetry(), nil
 }
 
+// SupportsMultiQueue marks the broker as able to consume several queues in a single worker
+func (b *BrokerGR) SupportsMultiQueue() {}
+
 // StopConsuming quits the loop
 func (b *BrokerGR) StopConsuming() {
 	b.Broker.StopConsuming()
@@ -174,6 +217,16 @@ func (b *BrokerGR) StopConsuming() {
 	b.delayedWG.Wait()
 	// Waiting for consumption to finish
 	b.consumingWG.Wait()
+	// Waiting for the receiving goroutine so no message is taken after this point
+	b.receivingWG.Wait()
+	// Waiting for the heartbeat goroutine to have stopped
+	b.reliableWG.Wait()
+
+	if b.consumerID != "" {
+		if err := b.unregisterConsumer(); err != nil {
+			log.ERROR.Printf("Failed to unregister consumer %s: %s", b.consumerID, err)
+		}
+	}
 
 	b.rclient.Close()
 }
@@ -183,9 +236,9 @@ func (b *BrokerGR) Publish(ctx context.Context, signature *tasks.Signature) erro
 	// Adjust routing key (this decides which queue the message will be published to)
 	b.Broker.AdjustRoutingKey(signature)
 
-	msg, err := json.Marshal(signature)
+	msg, err := b.MarshalSignature(signature)
 	if err != nil {
-		return fmt.Errorf("JSON marshal error: %s", err)
+		return fmt.Errorf("Marshal error: %s", err)
 	}
 
 	// Check the ETA signature field, if it is set and it is in the future,
@@ -200,7 +253,7 @@ func (b *BrokerGR) Publish(ctx context.Context, signature *tasks.Signature) erro
 		}
 	}
 
-	err = b.rclient.RPush(context.Background(), signature.RoutingKey, msg).Err()
+	err = b.rclient.RPush(context.Background(), priorityQueue(b.GetConfig(), signature.RoutingKey, signature.Priority), msg).Err()
 	return err
 }
 
@@ -210,17 +263,21 @@ func (b *BrokerGR) GetPendingTasks(queue string) ([]*tasks.Signature, error) {
 	if queue == "" {
 		queue = b.GetConfig().DefaultQueue
 	}
-	results, err := b.rclient.LRange(context.Background(), queue, 0, -1).Result()
-	if err != nil {
-		return nil, err
+
+	// Messages of all priority levels, highest priority first
+	var results []string
+	for _, key := range priorityQueues(b.GetConfig(), queue) {
+		items, err := b.rclient.LRange(context.Background(), key, 0, -1).Result()
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, items...)
 	}
 
 	taskSignatures := make([]*tasks.Signature, len(results))
 	for i, result := range results {
 		signature := new(tasks.Signature)
-		decoder := json.NewDecoder(strings.NewReader(result))
-		decoder.UseNumber()
-		if err := decoder.Decode(signature); err != nil {
+		if err := b.UnmarshalSignature([]byte(result), signature); err != nil {
 			return nil, err
 		}
 		taskSignatures[i] = signature
@@ -238,9 +295,7 @@ func (b *BrokerGR) GetDelayedTasks() ([]*tasks.Signature, error) {
 	taskSignatures := make([]*tasks.Signature, len(results))
 	for i, result := range results {
 		signature := new(tasks.Signature)
-		decoder := json.NewDecoder(strings.NewReader(result))
-		decoder.UseNumber()
-		if err := decoder.Decode(signature); err != nil {
+		if err := b.UnmarshalSignature([]byte(result), signature); err != nil {
 			return nil, err
 		}
 		taskSignatures[i] = signature
@@ -248,6 +303,19 @@ func (b *BrokerGR) GetDelayedTasks() ([]*tasks.Signature, error) {
 	return taskSignatures, nil
 }
 
+// deliver sends the message to the deliveries channel. If consume has returned meanwhile, the
+// message is given back to the queue and false is returned.
+func (b *BrokerGR) deliver(deliveries chan<- []byte, consumeDone <-chan struct{}, delivery []byte, taskProcessor iface.TaskProcessor) bool {
+	select {
+	case deliveries <- delivery:
+		return true
+	case <-consumeDone:
+		b.requeueMessage(delivery, taskProcessor)
+		b.ackMessage(delivery)
+		return false
+	}
+}
+
 // consume takes delivered messages from the channel and manages a worker pool
 // to process tasks concurrently
 func (b *BrokerGR) consume(deliveries <-chan []byte, concurrency int, taskProcessor iface.TaskProcessor) error {
@@ -271,7 +339,13 @@ func (b *BrokerGR) consume(deliveries <-chan []byte, concurrency int, taskProces
 			}
 			if concurrency > 0 {
 				// get execution slot from pool (blocks until one is available)
-				<-pool
+				select {
+				case <-b.GetStopChan():
+					b.requeueMessage(d, taskProcessor)
+					b.ackMessage(d)
+					continue
+				case <-pool:
+				}
 			}
 
 			b.processingWG.Add(1)
@@ -283,6 +357,8 @@ func (b *BrokerGR) consume(deliveries <-chan []byte, concurrency int, taskProces
 					errorsChan <- err
 				}
 
+				b.ackMessage(d)
+
 				b.processingWG.Done()
 
 				if concurrency > 0 {
@@ -297,10 +373,15 @@ func (b *BrokerGR) consume(deliveries <-chan []byte, concurrency int, taskProces
 // consumeOne processes a single message using TaskProcessor
 func (b *BrokerGR) consumeOne(delivery []byte, taskProcessor iface.TaskProcessor) error {
 	signature := new(tasks.Signature)
-	decoder := json.NewDecoder(bytes.NewReader(delivery))
-	decoder.UseNumber()
-	if err := decoder.Decode(signature); err != nil {
-		return errs.NewErrCouldNotUnmarshalTaskSignature(delivery, err)
+	if err := b.UnmarshalSignature(delivery, signature); err != nil {
+		unmarshalErr := errs.NewErrCouldNotUnmarshalTaskSignature(delivery, err)
+		b.deadLetterUnparseable(getQueueGR(b.GetConfig(), taskProcessor), delivery, unmarshalErr)
+		// Keep consuming, rejected messages must not be able to interrupt the worker
+		if security.IsRejected(err) {
+			log.ERROR.Print(unmarshalErr)
+			return nil
+		}
+		return unmarshalErr
 	}
 
 	// If the task is not registered, we requeue it,
@@ -311,7 +392,7 @@ func (b *BrokerGR) consumeOne(delivery []byte, taskProcessor iface.TaskProcessor
 		}
 		log.INFO.Printf("Task not registered with this worker. Requeuing message: %s", delivery)
 
-		b.rclient.RPush(context.Background(), getQueueGR(b.GetConfig(), taskProcessor), delivery)
+		b.rclient.RPush(context.Background(), deliveryQueue(&b.Broker, delivery, getQueueGR(b.GetConfig(), taskProcessor)), delivery)
 		return nil
 	}
 
@@ -320,8 +401,8 @@ func (b *BrokerGR) consumeOne(delivery []byte, taskProcessor iface.TaskProcessor
 	return taskProcessor.Process(signature)
 }
 
-// nextTask pops next available task from the default queue
-func (b *BrokerGR) nextTask(queue string) (result []byte, err error) {
+// nextTask pops next available task from the queues, checked in the order given
+func (b *BrokerGR) nextTask(queues []string) (result []byte, err error) {
 
 	pollPeriodMilliseconds := 1000 // default poll period for normal tasks
 	if b.GetConfig().Redis != nil {
@@ -332,7 +413,49 @@ func (b *BrokerGR) nextTask(queue string) (result []byte, err error) {
 	}
 	pollPeriod := time.Duration(pollPeriodMilliseconds) * time.Millisecond
 
-	items, err := b.rclient.BLPop(context.Background(), pollPeriod, queue).Result()
+	// With priority levels, each level is a list and they are checked in order
+	keys := b.priority.order(b.GetConfig(), queues)
+
+	if b.consumerID != "" {
+		processing := processingKey(b.queue, b.consumerID)
+
+		// BLMOVE blocks on a single list only, so all lists are checked without blocking first and
+		// the first one is blocked on briefly
+		if len(keys) > 1 {
+			for _, key := range keys {
+				item, err := b.rclient.LMove(context.Background(), key, processing, "LEFT", "RIGHT").Result()
+				if err == nil {
+					b.priority.taken(queues, key)
+					return []byte(item), nil
+				}
+				if err != redis.Nil {
+					return []byte{}, err
+				}
+			}
+
+			// BLMove rounds the timeout up to whole seconds, so the command is sent as is
+			timeout := getMoveTimeout(pollPeriod).Seconds()
+			item, err := b.rclient.Do(context.Background(), "BLMOVE", keys[0], processing, "LEFT", "RIGHT", timeout).Text()
+			if err != nil {
+				return []byte{}, err
+			}
+
+			b.priority.taken(queues, keys[0])
+			return []byte(item), nil
+		}
+
+		// Move the message to the processing list so it survives a crash of this consumer
+		item, err := b.rclient.BLMove(context.Background(), keys[0], processing, "LEFT", "RIGHT", pollPeriod).Result()
+		if err != nil {
+			return []byte{}, err
+		}
+
+		b.priority.taken(queues, keys[0])
+		return []byte(item), nil
+	}
+
+	// BLPOP checks the keys in the given order
+	items, err := b.rclient.BLPop(context.Background(), pollPeriod, keys...).Result()
 	if err != nil {
 		return []byte{}, err
 	}
@@ -343,6 +466,7 @@ func (b *BrokerGR) nextTask(queue string) (result []byte, err error) {
 		return []byte{}, redis.Nil
 	}
 
+	b.priority.taken(queues, items[0])
 	result = []byte(items[1])
 
 	return result, nil
@@ -416,6 +540,14 @@ func (b *BrokerGR) nextDelayedTask(key string) (result []byte, err error) {
 	return
 }
 
+// getQueuesGR returns the queues to check for the next message, in that order
+func getQueuesGR(config *config.Config, taskProcessor iface.TaskProcessor) []string {
+	if multiQueueProcessor, ok := taskProcessor.(iface.MultiQueueTaskProcessor); ok {
+		return multiQueueProcessor.NextQueues()
+	}
+	return []string{getQueueGR(config, taskProcessor)}
+}
+
 func getQueueGR(config *config.Config, taskProcessor iface.TaskProcessor) string {
 	customQueue := taskProcessor.CustomQueue()
 	if customQueue == "" {
@@ -423,3 +555,132 @@ func getQueueGR(config *config.Config, taskProcessor iface.TaskProcessor) string
 	}
 	return customQueue
 }
+
+// registerConsumer sends the first heartbeat of this consumer and recovers
+// messages of consumers which died in the meantime
+func (b *BrokerGR) registerConsumer(consumerTag, queue string) error {
+	b.queue = queue
+	b.consumerID = newConsumerID(consumerTag)
+
+	if err := b.heartbeat(); err != nil {
+		b.consumerID = ""
+		return err
+	}
+
+	return b.requeueDeadConsumers()
+}
+
+// unregisterConsumer requeues messages this consumer did not process
+// and removes it from the consumers set
+func (b *BrokerGR) unregisterConsumer() error {
+	return b.requeueInFlight(b.consumerID)
+}
+
+// monitorConsumers keeps the heartbeat of this consumer alive and requeues
+// messages held by consumers which stopped sending heartbeats
+func (b *BrokerGR) monitorConsumers() {
+	ticker := time.NewTicker(getHeartbeatPeriod(b.GetConfig()))
+	defer ticker.Stop()
+
+	for {
+		select {
+		// A way to stop this goroutine from b.StopConsuming
+		case <-b.GetStopChan():
+			return
+		case <-ticker.C:
+			if err := b.heartbeat(); err != nil {
+				log.ERROR.Printf("Consumer heartbeat error: %s", err)
+			}
+			if err := b.requeueDeadConsumers(); err != nil {
+				log.ERROR.Printf("Requeue of dead consumers error: %s", err)
+			}
+		}
+	}
+}
+
+// heartbeat records the current time as the last sign of life of this consumer
+func (b *BrokerGR) heartbeat() error {
+	return b.rclient.ZAdd(context.Background(), consumersKey(b.queue), redis.Z{
+		Score:  float64(time.Now().UTC().Unix()),
+		Member: b.consumerID,
+	}).Err()
+}
+
+// requeueDeadConsumers puts messages of consumers which have not sent a heartbeat
+// within the visibility timeout back to the queue
+func (b *BrokerGR) requeueDeadConsumers() error {
+	deadline := time.Now().UTC().Add(-getVisibilityTimeout(b.GetConfig())).Unix()
+
+	consumerIDs, err := b.rclient.ZRangeByScore(context.Background(), consumersKey(b.queue), &redis.ZRangeBy{
+		Min: "-inf", Max: strconv.FormatInt(deadline, 10),
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, consumerID := range consumerIDs {
+		if consumerID == b.consumerID {
+			continue
+		}
+
+		log.WARNING.Printf("Consumer %s stopped sending heartbeats. Requeuing its in-flight messages", consumerID)
+		if err := b.requeueInFlight(consumerID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// requeueInFlight moves all messages from the processing list of a consumer
+// back to the head of the queue and forgets the consumer
+func (b *BrokerGR) requeueInFlight(consumerID string) error {
+	ctx := context.Background()
+	key := processingKey(b.queue, consumerID)
+
+	// Peek at the message to put it back to the list of its priority. The message is only
+	// moved if the list did not change in between, e.g. by an acknowledgement or another
+	// consumer requeuing the same messages.
+	var empty bool
+	requeue := func(tx *redis.Tx) error {
+		delivery, err := tx.LIndex(ctx, key, -1).Bytes()
+		if err == redis.Nil {
+			empty = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.LMove(ctx, key, deliveryQueue(&b.Broker, delivery, b.queue), "RIGHT", "LEFT")
+			return nil
+		})
+		return err
+	}
+
+	for !empty {
+		// The list changed after WATCH, try again
+		if err := b.rclient.Watch(ctx, requeue, key); err != nil && err != redis.TxFailedErr {
+			return err
+		}
+	}
+
+	return b.rclient.ZRem(ctx, consumersKey(b.queue), consumerID).Err()
+}
+
+// requeueMessage puts the message back to the queue it was taken from
+func (b *BrokerGR) requeueMessage(delivery []byte, taskProcessor iface.TaskProcessor) {
+	b.rclient.RPush(context.Background(), deliveryQueue(&b.Broker, delivery, getQueueGR(b.GetConfig(), taskProcessor)), delivery)
+}
+
+// ackMessage removes a processed message from the processing list of this consumer
+func (b *BrokerGR) ackMessage(delivery []byte) {
+	if b.consumerID == "" {
+		return
+	}
+
+	if err := b.rclient.LRem(context.Background(), processingKey(b.queue, b.consumerID), 1, delivery).Err(); err != nil {
+		log.ERROR.Printf("Failed to acknowledge message %s: %s", delivery, err)
+	}
+}
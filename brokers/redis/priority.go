@@ -0,0 +1,95 @@
+package redis
+
+import (
+	"fmt"
+
+	"github.com/oarkflow/machinery/common"
+	"github.com/oarkflow/machinery/config"
+	"github.com/oarkflow/machinery/tasks"
+)
+
+// getPriorityLevels returns the number of priority levels of a queue, 1 when priorities are disabled
+func getPriorityLevels(cnf *config.Config) int {
+	if cnf.Redis != nil && cnf.Redis.PriorityLevels > 1 {
+		return cnf.Redis.PriorityLevels
+	}
+	return 1
+}
+
+// priorityQueue returns the list holding messages of the queue with the given priority.
+// The lowest level is the queue itself, so disabling priorities keeps using the same list.
+// Priorities above the highest level are treated as the highest level.
+func priorityQueue(cnf *config.Config, queue string, priority uint8) string {
+	level := int(priority)
+	if levels := getPriorityLevels(cnf); level >= levels {
+		level = levels - 1
+	}
+	if level == 0 {
+		return queue
+	}
+	return fmt.Sprintf("%s:priority:%d", queue, level)
+}
+
+// priorityQueues returns the lists of all priority levels of the queue, highest priority first
+func priorityQueues(cnf *config.Config, queue string) []string {
+	levels := getPriorityLevels(cnf)
+	queues := make([]string, levels)
+	for level := 0; level < levels; level++ {
+		queues[levels-1-level] = priorityQueue(cnf, queue, uint8(level))
+	}
+	return queues
+}
+
+// deliveryQueue returns the list to put a raw message back to. It is the list of the priority
+// of the message in the queue it was published to, or in queue if it cannot be decoded.
+func deliveryQueue(b *common.Broker, delivery []byte, queue string) string {
+	signature := new(tasks.Signature)
+	_ = b.UnmarshalSignature(delivery, signature)
+	if signature.RoutingKey != "" {
+		queue = signature.RoutingKey
+	}
+	return priorityQueue(b.GetConfig(), queue, signature.Priority)
+}
+
+// priorityScheduler decides in which order the lists of prioritized queues are checked.
+// Higher priorities are checked first, except that after PriorityStarvationLimit consecutive
+// messages taken from above the lowest level, lower priorities are checked first once so
+// that a steady stream of urgent tasks cannot starve the others.
+type priorityScheduler struct {
+	consecutive int
+}
+
+// order returns the lists of the queues to check for the next message
+func (s *priorityScheduler) order(cnf *config.Config, queues []string) []string {
+	limit := 0
+	if cnf.Redis != nil {
+		limit = cnf.Redis.PriorityStarvationLimit
+	}
+	reverse := limit > 0 && getPriorityLevels(cnf) > 1 && s.consecutive >= limit
+	if reverse {
+		s.consecutive = 0
+	}
+
+	keys := make([]string, 0, len(queues)*getPriorityLevels(cnf))
+	for _, queue := range queues {
+		levels := priorityQueues(cnf, queue)
+		if reverse {
+			for i, j := 0, len(levels)-1; i < j; i, j = i+1, j-1 {
+				levels[i], levels[j] = levels[j], levels[i]
+			}
+		}
+		keys = append(keys, levels...)
+	}
+	return keys
+}
+
+// taken records that the last message was taken from key, queues being the lowest levels
+func (s *priorityScheduler) taken(queues []string, key string) {
+	for _, queue := range queues {
+		if key == queue {
+			s.consecutive = 0
+			return
+		}
+	}
+	s.consecutive++
+}
@@ -1,9 +1,7 @@
 package redis
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"math"
 	"runtime"
@@ -19,6 +17,7 @@ import (
 	"github.com/oarkflow/machinery/common"
 	"github.com/oarkflow/machinery/config"
 	"github.com/oarkflow/machinery/log"
+	"github.com/oarkflow/machinery/security"
 	"github.com/oarkflow/machinery/tasks"
 )
 
@@ -35,11 +34,19 @@ type Broker struct {
 	consumingWG  sync.WaitGroup // wait group to make sure whole consumption completes
 	processingWG sync.WaitGroup // use wait group to make sure task processing completes
 	delayedWG    sync.WaitGroup
+	receivingWG  sync.WaitGroup
+	reliableWG   sync.WaitGroup
 	// If set, path to a socket file overrides hostname
 	socketPath           string
 	redsync              *redsync.Redsync
 	redisOnce            sync.Once
 	redisDelayedTasksKey string
+	// queue and consumerID are set when the reliable queue is enabled,
+	// see isReliableQueue
+	queue      string
+	consumerID string
+	// priority orders the lists of a prioritized queue, see getPriorityLevels
+	priority priorityScheduler
 }
 
 // New creates new Broker instance
@@ -88,8 +95,26 @@ func (b *Broker) StartConsuming(consumerTag string, concurrency int, taskProcess
 		return b.GetRetry(), errs.ErrConsumerStopped
 	}
 
+	queue := getQueue(b.GetConfig(), taskProcessor)
+
+	// Register this consumer so that its in-flight messages can be recovered
+	// by other consumers in case it dies
+	if isReliableQueue(b.GetConfig()) && b.consumerID == "" {
+		if err := b.registerConsumer(consumerTag, queue); err != nil {
+			return b.GetRetry(), err
+		}
+
+		b.reliableWG.Add(1)
+		go func() {
+			defer b.reliableWG.Done()
+			b.monitorConsumers()
+		}()
+	}
+
 	// Channel to which we will push tasks ready for processing by worker
 	deliveries := make(chan []byte, concurrency)
+	// Closed once consume has returned, nobody takes deliveries after that
+	consumeDone := make(chan struct{})
 	pool := make(chan struct{}, concurrency)
 
 	// initialize worker pool with maxWorkers workers
@@ -98,9 +123,13 @@ func (b *Broker) StartConsuming(consumerTag string, concurrency int, taskProcess
 	}
 
 	// A receiving goroutine keeps popping messages from the queue by BLPOP
+	// (or BLMOVE when the reliable queue is enabled)
 	// If the message is valid and can be unmarshaled into a proper structure
 	// we send it to the deliveries channel
+	b.receivingWG.Add(1)
 	go func() {
+		defer b.receivingWG.Done()
+		defer close(deliveries)
 
 		log.INFO.Print("[*] Waiting for messages. To exit press CTRL+C")
 
@@ -108,21 +137,21 @@ func (b *Broker) StartConsuming(consumerTag string, concurrency int, taskProcess
 			select {
 			// A way to stop this goroutine from b.StopConsuming
 			case <-b.GetStopChan():
-				close(deliveries)
+				return
+			case <-consumeDone:
 				return
 			case <-pool:
 				select {
 				case <-b.GetStopChan():
-					close(deliveries)
 					return
 				default:
 				}
 
 				if taskProcessor.PreConsumeHandler() {
-					task, _ := b.nextTask(getQueue(b.GetConfig(), taskProcessor))
+					task, _ := b.nextTask(getQueues(b.GetConfig(), taskProcessor))
 					// TODO: should this error be ignored?
-					if len(task) > 0 {
-						deliveries <- task
+					if len(task) > 0 && !b.deliver(deliveries, consumeDone, task, taskProcessor) {
+						return
 					}
 				}
 
@@ -149,10 +178,9 @@ func (b *Broker) StartConsuming(consumerTag string, concurrency int, taskProcess
 				}
 
 				signature := new(tasks.Signature)
-				decoder := json.NewDecoder(bytes.NewReader(task))
-				decoder.UseNumber()
-				if err := decoder.Decode(signature); err != nil {
+				if err := b.UnmarshalSignature(task, signature); err != nil {
 					log.ERROR.Print(errs.NewErrCouldNotUnmarshalTaskSignature(task, err))
+					continue
 				}
 
 				if err := b.Publish(context.Background(), signature); err != nil {
@@ -162,7 +190,17 @@ func (b *Broker) StartConsuming(consumerTag string, concurrency int, taskProcess
 		}
 	}()
 
-	if err := b.consume(deliveries, concurrency, taskProcessor); err != nil {
+	err = b.consume(deliveries, concurrency, taskProcessor)
+	close(consumeDone)
+
+	// Give messages delivered after consume returned back to the queue
+	b.receivingWG.Wait()
+	for d := range deliveries {
+		b.requeueMessage(d, taskProcessor)
+		b.ackMessage(d)
+	}
+
+	if err != nil {
 		return b.GetRetry(), err
 	}
 
@@ -172,6 +210,9 @@ func (b *Broker) StartConsuming(consumerTag string, concurrency int, taskProcess
 	return b.GetRetry(), nil
 }
 
+// SupportsMultiQueue marks the broker as able to consume several queues in a single worker
+func (b *Broker) SupportsMultiQueue() {}
+
 // StopConsuming quits the loop
 func (b *Broker) StopConsuming() {
 	b.Broker.StopConsuming()
@@ -181,6 +222,16 @@ func (b *Broker) StopConsuming() {
 	b.consumingWG.Wait()
 	// Wait for currently processing tasks to finish as well.
 	b.processingWG.Wait()
+	// Waiting for the receiving goroutine so no message is taken after this point
+	b.receivingWG.Wait()
+	// Waiting for the heartbeat goroutine to have stopped
+	b.reliableWG.Wait()
+
+	if b.consumerID != "" {
+		if err := b.unregisterConsumer(); err != nil {
+			log.ERROR.Printf("Failed to unregister consumer %s: %s", b.consumerID, err)
+		}
+	}
 
 	if b.pool != nil {
 		b.pool.Close()
@@ -192,9 +243,9 @@ func (b *Broker) Publish(ctx context.Context, signature *tasks.Signature) error
 	// Adjust routing key (this decides which queue the message will be published to)
 	b.Broker.AdjustRoutingKey(signature)
 
-	msg, err := json.Marshal(signature)
+	msg, err := b.MarshalSignature(signature)
 	if err != nil {
-		return fmt.Errorf("JSON marshal error: %s", err)
+		return fmt.Errorf("Marshal error: %s", err)
 	}
 
 	conn := b.open()
@@ -212,7 +263,7 @@ func (b *Broker) Publish(ctx context.Context, signature *tasks.Signature) error
 		}
 	}
 
-	_, err = conn.Do("RPUSH", signature.RoutingKey, msg)
+	_, err = conn.Do("RPUSH", priorityQueue(b.GetConfig(), signature.RoutingKey, signature.Priority), msg)
 	return err
 }
 
@@ -224,21 +275,21 @@ func (b *Broker) GetPendingTasks(queue string) ([]*tasks.Signature, error) {
 	if queue == "" {
 		queue = b.GetConfig().DefaultQueue
 	}
-	dataBytes, err := conn.Do("LRANGE", queue, 0, -1)
-	if err != nil {
-		return nil, err
-	}
-	results, err := redis.ByteSlices(dataBytes, err)
-	if err != nil {
-		return nil, err
+
+	// Messages of all priority levels, highest priority first
+	var results [][]byte
+	for _, key := range priorityQueues(b.GetConfig(), queue) {
+		items, err := redis.ByteSlices(conn.Do("LRANGE", key, 0, -1))
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, items...)
 	}
 
 	taskSignatures := make([]*tasks.Signature, len(results))
 	for i, result := range results {
 		signature := new(tasks.Signature)
-		decoder := json.NewDecoder(bytes.NewReader(result))
-		decoder.UseNumber()
-		if err := decoder.Decode(signature); err != nil {
+		if err := b.UnmarshalSignature(result, signature); err != nil {
 			return nil, err
 		}
 		taskSignatures[i] = signature
@@ -263,9 +314,7 @@ func (b *Broker) GetDelayedTasks() ([]*tasks.Signature, error) {
 	taskSignatures := make([]*tasks.Signature, len(results))
 	for i, result := range results {
 		signature := new(tasks.Signature)
-		decoder := json.NewDecoder(bytes.NewReader(result))
-		decoder.UseNumber()
-		if err := decoder.Decode(signature); err != nil {
+		if err := b.UnmarshalSignature(result, signature); err != nil {
 			return nil, err
 		}
 		taskSignatures[i] = signature
@@ -273,6 +322,19 @@ func (b *Broker) GetDelayedTasks() ([]*tasks.Signature, error) {
 	return taskSignatures, nil
 }
 
+// deliver sends the message to the deliveries channel. If consume has returned meanwhile, the
+// message is given back to the queue and false is returned.
+func (b *Broker) deliver(deliveries chan<- []byte, consumeDone <-chan struct{}, delivery []byte, taskProcessor iface.TaskProcessor) bool {
+	select {
+	case deliveries <- delivery:
+		return true
+	case <-consumeDone:
+		b.requeueMessage(delivery, taskProcessor)
+		b.ackMessage(delivery)
+		return false
+	}
+}
+
 // consume takes delivered messages from the channel and manages a worker pool
 // to process tasks concurrently
 func (b *Broker) consume(deliveries <-chan []byte, concurrency int, taskProcessor iface.TaskProcessor) error {
@@ -299,6 +361,7 @@ func (b *Broker) consume(deliveries <-chan []byte, concurrency int, taskProcesso
 				select {
 				case <-b.GetStopChan():
 					b.requeueMessage(d, taskProcessor)
+					b.ackMessage(d)
 					continue
 				case <-pool:
 				}
@@ -313,6 +376,8 @@ func (b *Broker) consume(deliveries <-chan []byte, concurrency int, taskProcesso
 					errorsChan <- err
 				}
 
+				b.ackMessage(d)
+
 				b.processingWG.Done()
 
 				if concurrency > 0 {
@@ -327,10 +392,15 @@ func (b *Broker) consume(deliveries <-chan []byte, concurrency int, taskProcesso
 // consumeOne processes a single message using TaskProcessor
 func (b *Broker) consumeOne(delivery []byte, taskProcessor iface.TaskProcessor) error {
 	signature := new(tasks.Signature)
-	decoder := json.NewDecoder(bytes.NewReader(delivery))
-	decoder.UseNumber()
-	if err := decoder.Decode(signature); err != nil {
-		return errs.NewErrCouldNotUnmarshalTaskSignature(delivery, err)
+	if err := b.UnmarshalSignature(delivery, signature); err != nil {
+		unmarshalErr := errs.NewErrCouldNotUnmarshalTaskSignature(delivery, err)
+		b.deadLetterUnparseable(getQueue(b.GetConfig(), taskProcessor), delivery, unmarshalErr)
+		// Keep consuming, rejected messages must not be able to interrupt the worker
+		if security.IsRejected(err) {
+			log.ERROR.Print(unmarshalErr)
+			return nil
+		}
+		return unmarshalErr
 	}
 
 	// If the task is not registered, we requeue it,
@@ -349,8 +419,8 @@ func (b *Broker) consumeOne(delivery []byte, taskProcessor iface.TaskProcessor)
 	return taskProcessor.Process(signature)
 }
 
-// nextTask pops next available task from the default queue
-func (b *Broker) nextTask(queue string) (result []byte, err error) {
+// nextTask pops next available task from the queues, checked in the order given
+func (b *Broker) nextTask(queues []string) (result []byte, err error) {
 	conn := b.open()
 	defer conn.Close()
 
@@ -370,7 +440,44 @@ func (b *Broker) nextTask(queue string) (result []byte, err error) {
 	//   math.Ceil(0.2) --> 1 (timeout after 1 second)
 	pollPeriodSeconds := math.Ceil(pollPeriod.Seconds())
 
-	items, err := redis.ByteSlices(conn.Do("BLPOP", queue, pollPeriodSeconds))
+	// With priority levels, each level is a list and they are checked in order
+	keys := b.priority.order(b.GetConfig(), queues)
+
+	if b.consumerID != "" {
+		processing := processingKey(b.queue, b.consumerID)
+
+		// BLMOVE blocks on a single list only, so all lists are checked without blocking first and
+		// the first one is blocked on briefly
+		if len(keys) > 1 {
+			for _, key := range keys {
+				result, err = redis.Bytes(conn.Do("LMOVE", key, processing, "LEFT", "RIGHT"))
+				if err == nil {
+					b.priority.taken(queues, key)
+					return result, nil
+				}
+				if err != redis.ErrNil {
+					return []byte{}, err
+				}
+			}
+		}
+
+		// Move the message to the processing list so it survives a crash of this consumer
+		timeoutSeconds := pollPeriodSeconds
+		if len(keys) > 1 {
+			// BLMOVE, unlike BLPOP before Redis 6, accepts a decimal timeout
+			timeoutSeconds = getMoveTimeout(pollPeriod).Seconds()
+		}
+		result, err = redis.Bytes(conn.Do("BLMOVE", keys[0], processing, "LEFT", "RIGHT", timeoutSeconds))
+		if err != nil {
+			return []byte{}, err
+		}
+
+		b.priority.taken(queues, keys[0])
+		return result, nil
+	}
+
+	// BLPOP checks the keys in the given order
+	items, err := redis.ByteSlices(conn.Do("BLPOP", redis.Args{}.AddFlat(keys).Add(pollPeriodSeconds)...))
 	if err != nil {
 		return []byte{}, err
 	}
@@ -381,6 +488,7 @@ func (b *Broker) nextTask(queue string) (result []byte, err error) {
 		return []byte{}, redis.ErrNil
 	}
 
+	b.priority.taken(queues, string(items[0]))
 	result = items[1]
 
 	return result, nil
@@ -472,6 +580,14 @@ func (b *Broker) open() redis.Conn {
 	return b.pool.Get()
 }
 
+// getQueues returns the queues to check for the next message, in that order
+func getQueues(config *config.Config, taskProcessor iface.TaskProcessor) []string {
+	if multiQueueProcessor, ok := taskProcessor.(iface.MultiQueueTaskProcessor); ok {
+		return multiQueueProcessor.NextQueues()
+	}
+	return []string{getQueue(config, taskProcessor)}
+}
+
 func getQueue(config *config.Config, taskProcessor iface.TaskProcessor) string {
 	customQueue := taskProcessor.CustomQueue()
 	if customQueue == "" {
@@ -483,5 +599,134 @@ func getQueue(config *config.Config, taskProcessor iface.TaskProcessor) string {
 func (b *Broker) requeueMessage(delivery []byte, taskProcessor iface.TaskProcessor) {
 	conn := b.open()
 	defer conn.Close()
-	conn.Do("RPUSH", getQueue(b.GetConfig(), taskProcessor), delivery)
+	conn.Do("RPUSH", deliveryQueue(&b.Broker, delivery, getQueue(b.GetConfig(), taskProcessor)), delivery)
+}
+
+// registerConsumer sends the first heartbeat of this consumer and recovers
+// messages of consumers which died in the meantime
+func (b *Broker) registerConsumer(consumerTag, queue string) error {
+	b.queue = queue
+	b.consumerID = newConsumerID(consumerTag)
+
+	if err := b.heartbeat(); err != nil {
+		b.consumerID = ""
+		return err
+	}
+
+	return b.requeueDeadConsumers()
+}
+
+// unregisterConsumer requeues messages this consumer did not process
+// and removes it from the consumers set
+func (b *Broker) unregisterConsumer() error {
+	return b.requeueInFlight(b.consumerID)
+}
+
+// monitorConsumers keeps the heartbeat of this consumer alive and requeues
+// messages held by consumers which stopped sending heartbeats
+func (b *Broker) monitorConsumers() {
+	ticker := time.NewTicker(getHeartbeatPeriod(b.GetConfig()))
+	defer ticker.Stop()
+
+	for {
+		select {
+		// A way to stop this goroutine from b.StopConsuming
+		case <-b.GetStopChan():
+			return
+		case <-ticker.C:
+			if err := b.heartbeat(); err != nil {
+				log.ERROR.Printf("Consumer heartbeat error: %s", err)
+			}
+			if err := b.requeueDeadConsumers(); err != nil {
+				log.ERROR.Printf("Requeue of dead consumers error: %s", err)
+			}
+		}
+	}
+}
+
+// heartbeat records the current time as the last sign of life of this consumer
+func (b *Broker) heartbeat() error {
+	conn := b.open()
+	defer conn.Close()
+
+	_, err := conn.Do("ZADD", consumersKey(b.queue), time.Now().UTC().Unix(), b.consumerID)
+	return err
+}
+
+// requeueDeadConsumers puts messages of consumers which have not sent a heartbeat
+// within the visibility timeout back to the queue
+func (b *Broker) requeueDeadConsumers() error {
+	conn := b.open()
+	defer conn.Close()
+
+	deadline := time.Now().UTC().Add(-getVisibilityTimeout(b.GetConfig())).Unix()
+
+	consumerIDs, err := redis.Strings(conn.Do("ZRANGEBYSCORE", consumersKey(b.queue), "-inf", deadline))
+	if err != nil {
+		return err
+	}
+
+	for _, consumerID := range consumerIDs {
+		if consumerID == b.consumerID {
+			continue
+		}
+
+		log.WARNING.Printf("Consumer %s stopped sending heartbeats. Requeuing its in-flight messages", consumerID)
+		if err := b.requeueInFlight(consumerID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// requeueInFlight moves all messages from the processing list of a consumer
+// back to the head of the queue and forgets the consumer
+func (b *Broker) requeueInFlight(consumerID string) error {
+	conn := b.open()
+	defer conn.Close()
+
+	key := processingKey(b.queue, consumerID)
+
+	for {
+		// Peek at the message to put it back to the list of its priority. The message is only
+		// moved if the list did not change in between, e.g. by an acknowledgement or another
+		// consumer requeuing the same messages.
+		if _, err := conn.Do("WATCH", key); err != nil {
+			return err
+		}
+		delivery, err := redis.Bytes(conn.Do("LINDEX", key, -1))
+		if err == redis.ErrNil {
+			conn.Do("UNWATCH")
+			break
+		}
+		if err != nil {
+			conn.Do("UNWATCH")
+			return err
+		}
+
+		conn.Send("MULTI")
+		conn.Send("LMOVE", key, deliveryQueue(&b.Broker, delivery, b.queue), "RIGHT", "LEFT")
+		// A nil reply means the list changed after WATCH, try again
+		if _, err := conn.Do("EXEC"); err != nil {
+			return err
+		}
+	}
+
+	_, err := conn.Do("ZREM", consumersKey(b.queue), consumerID)
+	return err
+}
+
+// ackMessage removes a processed message from the processing list of this consumer
+func (b *Broker) ackMessage(delivery []byte) {
+	if b.consumerID == "" {
+		return
+	}
+
+	conn := b.open()
+	defer conn.Close()
+
+	if _, err := conn.Do("LREM", processingKey(b.queue, b.consumerID), 1, delivery); err != nil {
+		log.ERROR.Printf("Failed to acknowledge message %s: %s", delivery, err)
+	}
 }
@@ -0,0 +1,60 @@
+package redis
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/oarkflow/machinery/config"
+	"github.com/oarkflow/machinery/utils"
+)
+
+const defaultVisibilityTimeout = 300 // seconds
+
+// maxMoveTimeout caps how long a consumer of several lists blocks on the first of them, as BLMOVE
+// blocks on a single list and messages arriving in the other lists wait until it returns
+const maxMoveTimeout = 100 * time.Millisecond
+
+// getMoveTimeout returns how long a consumer of several lists blocks on the first of them
+func getMoveTimeout(pollPeriod time.Duration) time.Duration {
+	if pollPeriod > maxMoveTimeout {
+		return maxMoveTimeout
+	}
+	return pollPeriod
+}
+
+// isReliableQueue returns true if at-least-once delivery is enabled
+func isReliableQueue(cnf *config.Config) bool {
+	return cnf.Redis != nil && cnf.Redis.ReliableQueue
+}
+
+// getVisibilityTimeout returns the period after which a silent consumer is considered dead
+func getVisibilityTimeout(cnf *config.Config) time.Duration {
+	visibilityTimeout := defaultVisibilityTimeout
+	if cnf.Redis != nil && cnf.Redis.VisibilityTimeout > 0 {
+		visibilityTimeout = cnf.Redis.VisibilityTimeout
+	}
+	return time.Duration(visibilityTimeout) * time.Second
+}
+
+// getHeartbeatPeriod returns how often a consumer refreshes its heartbeat and
+// looks for dead consumers, a third of the visibility timeout
+func getHeartbeatPeriod(cnf *config.Config) time.Duration {
+	return getVisibilityTimeout(cnf) / 3
+}
+
+// newConsumerID returns an identifier unique to this consumer process
+func newConsumerID(consumerTag string) string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s:%s:%d:%s", consumerTag, hostname, os.Getpid(), utils.GetPureUUID())
+}
+
+// consumersKey is the sorted set of consumers of a queue scored by their last heartbeat
+func consumersKey(queue string) string {
+	return queue + ":consumers"
+}
+
+// processingKey is the list of messages a consumer has taken from a queue but not acknowledged yet
+func processingKey(queue, consumerID string) string {
+	return queue + ":processing:" + consumerID
+}
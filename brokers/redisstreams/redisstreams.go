@@ -0,0 +1,752 @@
+package redisstreams
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/oarkflow/machinery/brokers/errs"
+	"github.com/oarkflow/machinery/brokers/iface"
+	"github.com/oarkflow/machinery/common"
+	"github.com/oarkflow/machinery/config"
+	"github.com/oarkflow/machinery/log"
+	"github.com/oarkflow/machinery/security"
+	"github.com/oarkflow/machinery/tasks"
+	"github.com/oarkflow/machinery/utils"
+)
+
+const (
+	defaultRedisDelayedTasksKey = "delayed_tasks"
+	defaultConsumerGroup        = "machinery_group"
+	defaultClaimMinIdle         = 300 // seconds
+	defaultMaxDeliveries        = 5
+
+	// bodyField is the stream entry field holding the encoded signature
+	bodyField = "body"
+)
+
+// Broker represents a Redis Streams broker. Every queue is a stream consumed
+// by a consumer group, messages are acknowledged once processed and messages
+// left pending by dead consumers are claimed by the living ones
+type Broker struct {
+	common.Broker
+	rclient      redis.UniversalClient
+	consumingWG  sync.WaitGroup // wait group to make sure whole consumption completes
+	processingWG sync.WaitGroup // use wait group to make sure task processing completes
+	delayedWG    sync.WaitGroup
+	receivingWG  sync.WaitGroup
+	heartbeatWG  sync.WaitGroup
+
+	// inFlight holds the IDs of the messages being processed, they are kept from being claimed
+	inFlight   map[string]struct{}
+	inFlightMu sync.Mutex
+
+	redisDelayedTasksKey string
+	consumerGroup        string
+	consumerName         string
+	lastClaim            time.Time
+}
+
+// New creates new Broker instance
+func New(cnf *config.Config, addrs []string, db int) iface.Broker {
+	b := &Broker{Broker: common.NewBroker(cnf), inFlight: make(map[string]struct{})}
+
+	var password string
+	parts := strings.Split(addrs[0], "@")
+	if len(parts) >= 2 {
+		// with password
+		password = strings.Join(parts[:len(parts)-1], "@")
+		addrs[0] = parts[len(parts)-1] // addr is the last one without @
+	}
+
+	ropt := &redis.UniversalOptions{
+		Addrs:     addrs,
+		DB:        db,
+		Password:  password,
+		TLSConfig: cnf.TLSConfig,
+	}
+	if cnf.Redis != nil {
+		ropt.MasterName = cnf.Redis.MasterName
+	}
+
+	b.rclient = redis.NewUniversalClient(ropt)
+
+	b.redisDelayedTasksKey = defaultRedisDelayedTasksKey
+	b.consumerGroup = defaultConsumerGroup
+	if cnf.Redis != nil {
+		if cnf.Redis.DelayedTasksKey != "" {
+			b.redisDelayedTasksKey = cnf.Redis.DelayedTasksKey
+		}
+		if cnf.Redis.ConsumerGroup != "" {
+			b.consumerGroup = cnf.Redis.ConsumerGroup
+		}
+	}
+
+	return b
+}
+
+// StartConsuming enters a loop and waits for incoming messages
+func (b *Broker) StartConsuming(consumerTag string, concurrency int, taskProcessor iface.TaskProcessor) (bool, error) {
+	b.consumingWG.Add(1)
+	defer b.consumingWG.Done()
+
+	if concurrency < 1 {
+		concurrency = runtime.NumCPU() * 2
+	}
+
+	b.Broker.StartConsuming(consumerTag, concurrency, taskProcessor)
+
+	// Ping the server to make sure connection is live
+	_, err := b.rclient.Ping(context.Background()).Result()
+	if err != nil {
+		b.GetRetryFunc()(b.GetRetryStopChan())
+
+		// Return err if retry is still true.
+		// If retry is false, broker.StopConsuming() has been called and
+		// therefore Redis might have been stopped. Return nil exit
+		// StartConsuming()
+		if b.GetRetry() {
+			return b.GetRetry(), err
+		}
+		return b.GetRetry(), errs.ErrConsumerStopped
+	}
+
+	stream := getQueue(b.GetConfig(), taskProcessor)
+
+	if err := b.createGroup(stream); err != nil {
+		return b.GetRetry(), err
+	}
+
+	if b.consumerName == "" {
+		hostname, _ := os.Hostname()
+		b.consumerName = fmt.Sprintf("%s:%s:%d:%s", consumerTag, hostname, os.Getpid(), utils.GetPureUUID())
+	}
+
+	// Keep the messages being processed from being claimed by other consumers
+	heartbeatDone := make(chan struct{})
+	defer close(heartbeatDone)
+	b.heartbeatWG.Add(1)
+	go func() {
+		defer b.heartbeatWG.Done()
+		b.heartbeat(stream, heartbeatDone)
+	}()
+
+	// Channel to which we will push tasks ready for processing by worker
+	deliveries := make(chan redis.XMessage, concurrency)
+	// Closed once consume has returned, nobody takes deliveries after that
+	consumeDone := make(chan struct{})
+	pool := make(chan struct{}, concurrency)
+
+	// initialize worker pool with maxWorkers workers
+	for i := 0; i < concurrency; i++ {
+		pool <- struct{}{}
+	}
+
+	// A receiving goroutine keeps reading messages from the stream by XREADGROUP
+	// and claiming messages which were left pending by dead consumers
+	b.receivingWG.Add(1)
+	go func() {
+		defer b.receivingWG.Done()
+		defer close(deliveries)
+
+		log.INFO.Print("[*] Waiting for messages. To exit press CTRL+C")
+
+		for {
+			select {
+			// A way to stop this goroutine from b.StopConsuming
+			case <-b.GetStopChan():
+				return
+			case <-consumeDone:
+				return
+			case <-pool:
+				if taskProcessor.PreConsumeHandler() {
+					messages, err := b.nextTasks(stream)
+					if err != nil && err != redis.Nil {
+						log.ERROR.Printf("Stream read error: %s", err)
+					}
+					for i, message := range messages {
+						select {
+						case deliveries <- message:
+						case <-consumeDone:
+							// Give the messages back to the stream as nobody takes them anymore
+							for _, message := range messages[i:] {
+								b.requeueMessage(stream, message)
+							}
+							return
+						}
+					}
+				}
+
+				pool <- struct{}{}
+			}
+		}
+	}()
+
+	// A goroutine to watch for delayed tasks and push them to the stream
+	// for consumption by the worker
+	b.delayedWG.Add(1)
+	go func() {
+		defer b.delayedWG.Done()
+
+		for {
+			select {
+			// A way to stop this goroutine from b.StopConsuming
+			case <-b.GetStopChan():
+				return
+			default:
+				task, err := b.nextDelayedTask(b.redisDelayedTasksKey)
+				if err != nil {
+					continue
+				}
+
+				signature := new(tasks.Signature)
+				if err := b.UnmarshalSignature(task, signature); err != nil {
+					log.ERROR.Print(errs.NewErrCouldNotUnmarshalTaskSignature(task, err))
+					continue
+				}
+
+				if err := b.Publish(context.Background(), signature); err != nil {
+					log.ERROR.Print(err)
+				}
+			}
+		}
+	}()
+
+	err = b.consume(deliveries, stream, concurrency, taskProcessor)
+	close(consumeDone)
+
+	// Give messages delivered after consume returned back to the stream
+	b.receivingWG.Wait()
+	for message := range deliveries {
+		b.requeueMessage(stream, message)
+	}
+
+	if err != nil {
+		return b.GetRetry(), err
+	}
+
+	// Waiting for any tasks being processed to finish
+	b.processingWG.Wait()
+
+	return b.GetRetry(), nil
+}
+
+// StopConsuming quits the loop
+func (b *Broker) StopConsuming() {
+	b.Broker.StopConsuming()
+	// Waiting for the delayed tasks goroutine to have stopped
+	b.delayedWG.Wait()
+	// Waiting for consumption to finish
+	b.consumingWG.Wait()
+	// Waiting for the receiving goroutine to have stopped
+	b.receivingWG.Wait()
+	// Waiting for the heartbeat goroutine to have stopped
+	b.heartbeatWG.Wait()
+
+	b.rclient.Close()
+}
+
+// Publish places a new message on the default queue
+func (b *Broker) Publish(ctx context.Context, signature *tasks.Signature) error {
+	// Adjust routing key (this decides which queue the message will be published to)
+	b.Broker.AdjustRoutingKey(signature)
+
+	msg, err := b.MarshalSignature(signature)
+	if err != nil {
+		return fmt.Errorf("Marshal error: %s", err)
+	}
+
+	// Check the ETA signature field, if it is set and it is in the future,
+	// delay the task
+	if signature.ETA != nil {
+		now := time.Now().UTC()
+
+		if signature.ETA.After(now) {
+			score := signature.ETA.UnixNano()
+			return b.rclient.ZAdd(context.Background(), b.redisDelayedTasksKey, redis.Z{Score: float64(score), Member: msg}).Err()
+		}
+	}
+
+	return b.add(signature.RoutingKey, msg)
+}
+
+// GetPendingTasks returns a slice of task signatures which are waiting in the stream,
+// i.e. messages not delivered to the consumer group yet followed by messages
+// delivered to consumers but not acknowledged
+func (b *Broker) GetPendingTasks(queue string) ([]*tasks.Signature, error) {
+	if queue == "" {
+		queue = b.GetConfig().DefaultQueue
+	}
+	ctx := context.Background()
+
+	groups, err := b.rclient.XInfoGroups(ctx, queue).Result()
+	if err != nil {
+		if isNoStreamError(err) {
+			return []*tasks.Signature{}, nil
+		}
+		return nil, err
+	}
+
+	var group *redis.XInfoGroup
+	for i := range groups {
+		if groups[i].Name == b.consumerGroup {
+			group = &groups[i]
+		}
+	}
+
+	// Nobody has consumed the stream yet, every entry is waiting
+	if group == nil {
+		messages, err := b.rclient.XRange(ctx, queue, "-", "+").Result()
+		if err != nil {
+			return nil, err
+		}
+		return b.decodeMessages(messages)
+	}
+
+	messages, err := b.rclient.XRange(ctx, queue, "("+group.LastDeliveredID, "+").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	if group.Pending > 0 {
+		pending, err := b.rclient.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: queue,
+			Group:  b.consumerGroup,
+			Start:  "-",
+			End:    "+",
+			Count:  group.Pending,
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range pending {
+			inFlight, err := b.rclient.XRange(ctx, queue, entry.ID, entry.ID).Result()
+			if err != nil {
+				return nil, err
+			}
+			messages = append(messages, inFlight...)
+		}
+	}
+
+	return b.decodeMessages(messages)
+}
+
+// GetDelayedTasks returns a slice of task signatures that are scheduled, but not yet in the queue
+func (b *Broker) GetDelayedTasks() ([]*tasks.Signature, error) {
+	results, err := b.rclient.ZRange(context.Background(), b.redisDelayedTasksKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	taskSignatures := make([]*tasks.Signature, len(results))
+	for i, result := range results {
+		signature := new(tasks.Signature)
+		if err := b.UnmarshalSignature([]byte(result), signature); err != nil {
+			return nil, err
+		}
+		taskSignatures[i] = signature
+	}
+	return taskSignatures, nil
+}
+
+// consume takes delivered messages from the channel and manages a worker pool
+// to process tasks concurrently
+func (b *Broker) consume(deliveries <-chan redis.XMessage, stream string, concurrency int, taskProcessor iface.TaskProcessor) error {
+	errorsChan := make(chan error, concurrency*2)
+	pool := make(chan struct{}, concurrency)
+
+	// init pool for Worker tasks execution, as many slots as Worker concurrency param
+	go func() {
+		for i := 0; i < concurrency; i++ {
+			pool <- struct{}{}
+		}
+	}()
+
+	for {
+		select {
+		case err := <-errorsChan:
+			return err
+		case d, open := <-deliveries:
+			if !open {
+				return nil
+			}
+			if concurrency > 0 {
+				// get execution slot from pool (blocks until one is available)
+				select {
+				case <-b.GetStopChan():
+					b.requeueMessage(stream, d)
+					continue
+				case <-pool:
+				}
+			}
+
+			b.processingWG.Add(1)
+			b.trackInFlight(d.ID, true)
+
+			// Consume the task inside a goroutine so multiple tasks
+			// can be processed concurrently
+			go func() {
+				if err := b.consumeOne(d, stream, taskProcessor); err != nil {
+					errorsChan <- err
+				}
+
+				// Acknowledge the message so it leaves the pending entries list
+				b.ackMessage(stream, d.ID)
+				b.trackInFlight(d.ID, false)
+
+				b.processingWG.Done()
+
+				if concurrency > 0 {
+					// give slot back to pool
+					pool <- struct{}{}
+				}
+			}()
+		}
+	}
+}
+
+// consumeOne processes a single message using TaskProcessor
+func (b *Broker) consumeOne(message redis.XMessage, stream string, taskProcessor iface.TaskProcessor) error {
+	delivery := messageBody(message)
+
+	signature := new(tasks.Signature)
+	if err := b.UnmarshalSignature(delivery, signature); err != nil {
+		unmarshalErr := errs.NewErrCouldNotUnmarshalTaskSignature(delivery, err)
+		b.deadLetterUnparseable(stream, delivery, unmarshalErr)
+		// Keep consuming, rejected messages must not be able to interrupt the worker
+		if security.IsRejected(err) {
+			log.ERROR.Print(unmarshalErr)
+			return nil
+		}
+		return unmarshalErr
+	}
+
+	// If the task is not registered, we requeue it,
+	// there might be different workers for processing specific tasks
+	if !b.IsTaskRegistered(signature.Name) {
+		if signature.IgnoreWhenTaskNotRegistered {
+			return nil
+		}
+		log.INFO.Printf("Task not registered with this worker. Requeuing message: %s", delivery)
+
+		return b.add(stream, delivery)
+	}
+
+	log.DEBUG.Printf("Received new message: %s", delivery)
+
+	return taskProcessor.Process(signature)
+}
+
+// nextTasks claims messages which have been pending for too long, when there are
+// none it reads the next new message from the stream
+func (b *Broker) nextTasks(stream string) ([]redis.XMessage, error) {
+	ctx := context.Background()
+	minIdle := getClaimMinIdle(b.GetConfig())
+
+	// Look for abandoned messages every third of the idle period
+	if time.Since(b.lastClaim) > minIdle/3 {
+		messages, err := b.claimTasks(ctx, stream, minIdle)
+		if err != nil {
+			return nil, err
+		}
+		if len(messages) > 0 {
+			return messages, nil
+		}
+
+		b.lastClaim = time.Now()
+	}
+
+	pollPeriodMilliseconds := 1000 // default poll period for normal tasks
+	if b.GetConfig().Redis != nil {
+		configuredPollPeriod := b.GetConfig().Redis.NormalTasksPollPeriod
+		if configuredPollPeriod > 0 {
+			pollPeriodMilliseconds = configuredPollPeriod
+		}
+	}
+	pollPeriod := time.Duration(pollPeriodMilliseconds) * time.Millisecond
+
+	streams, err := b.rclient.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    b.consumerGroup,
+		Consumer: b.consumerName,
+		Streams:  []string{stream, ">"},
+		Count:    1,
+		Block:    pollPeriod,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []redis.XMessage
+	for _, s := range streams {
+		messages = append(messages, s.Messages...)
+	}
+
+	return messages, nil
+}
+
+// claimTasks claims a message which has been pending for longer than minIdle. Messages delivered
+// as often as allowed already are dead-lettered instead, so that a message which keeps killing
+// its consumers is not claimed forever.
+func (b *Broker) claimTasks(ctx context.Context, stream string, minIdle time.Duration) ([]redis.XMessage, error) {
+	pending, err := b.rclient.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: stream,
+		Group:  b.consumerGroup,
+		Idle:   minIdle,
+		Start:  "-",
+		End:    "+",
+		Count:  10,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	maxDeliveries := getMaxDeliveries(b.GetConfig())
+	for _, entry := range pending {
+		if entry.RetryCount >= maxDeliveries {
+			b.deadLetterPending(ctx, stream, entry)
+			continue
+		}
+
+		messages, err := b.rclient.XClaim(ctx, &redis.XClaimArgs{
+			Stream:   stream,
+			Group:    b.consumerGroup,
+			Consumer: b.consumerName,
+			MinIdle:  minIdle,
+			Messages: []string{entry.ID},
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(messages) > 0 {
+			log.WARNING.Printf("Claimed message %s idle for more than %s", messages[0].ID, minIdle)
+			return messages, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// deadLetterPending moves a pending message which has been delivered too often to the dead-letter
+// queue and acknowledges it. It is dropped if no dead-letter queue is configured.
+func (b *Broker) deadLetterPending(ctx context.Context, stream string, entry redis.XPendingExt) {
+	reason := fmt.Errorf("Message delivered %d times without being processed", entry.RetryCount)
+
+	messages, err := b.rclient.XRange(ctx, stream, entry.ID, entry.ID).Result()
+	if err != nil {
+		log.ERROR.Printf("Failed to read message %s: %s", entry.ID, err)
+		return
+	}
+
+	if len(messages) > 0 && b.GetDeadLetterQueue() != "" {
+		delivery := messageBody(messages[0])
+
+		deadLetter := tasks.NewUnparseableDeadLetter(stream, delivery, "", "", reason)
+		signature := new(tasks.Signature)
+		if err := b.UnmarshalSignature(delivery, signature); err == nil {
+			deadLetter = tasks.NewDeadLetter(signature, reason)
+		}
+
+		// Keep the message pending, it is dead-lettered again by the next claim
+		if err := b.PublishDeadLetter(ctx, deadLetter); err != nil {
+			log.ERROR.Printf("Failed to publish message %s to the dead-letter queue: %s", entry.ID, err)
+			return
+		}
+	}
+
+	log.ERROR.Printf("Dead-lettered message %s: %s", entry.ID, reason)
+	b.ackMessage(stream, entry.ID)
+}
+
+// heartbeat refreshes the idle time of the messages being processed every third of the
+// claim period until done is closed, so that they are not claimed by other consumers
+func (b *Broker) heartbeat(stream string, done <-chan struct{}) {
+	ticker := time.NewTicker(getClaimMinIdle(b.GetConfig()) / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			b.inFlightMu.Lock()
+			ids := make([]string, 0, len(b.inFlight))
+			for id := range b.inFlight {
+				ids = append(ids, id)
+			}
+			b.inFlightMu.Unlock()
+
+			if len(ids) == 0 {
+				continue
+			}
+
+			// JUSTID does not count as a delivery
+			err := b.rclient.XClaimJustID(context.Background(), &redis.XClaimArgs{
+				Stream:   stream,
+				Group:    b.consumerGroup,
+				Consumer: b.consumerName,
+				Messages: ids,
+			}).Err()
+			if err != nil {
+				log.ERROR.Printf("Failed to refresh messages being processed: %s", err)
+			}
+		}
+	}
+}
+
+// trackInFlight adds the message to or removes it from the messages being processed
+func (b *Broker) trackInFlight(id string, processing bool) {
+	b.inFlightMu.Lock()
+	defer b.inFlightMu.Unlock()
+
+	if processing {
+		b.inFlight[id] = struct{}{}
+	} else {
+		delete(b.inFlight, id)
+	}
+}
+
+// requeueMessage adds the message to the stream again and acknowledges the original one
+func (b *Broker) requeueMessage(stream string, message redis.XMessage) {
+	if err := b.add(stream, messageBody(message)); err != nil {
+		log.ERROR.Printf("Failed to requeue message %s: %s", message.ID, err)
+		return
+	}
+	b.ackMessage(stream, message.ID)
+}
+
+// ackMessage acknowledges the message so it leaves the pending entries list
+func (b *Broker) ackMessage(stream, id string) {
+	if err := b.rclient.XAck(context.Background(), stream, b.consumerGroup, id).Err(); err != nil {
+		log.ERROR.Printf("Failed to acknowledge message %s: %s", id, err)
+	}
+}
+
+// nextDelayedTask pops a value from the ZSET key using WATCH/MULTI/EXEC commands.
+func (b *Broker) nextDelayedTask(key string) (result []byte, err error) {
+	var items []string
+
+	pollPeriod := 500 // default poll period for delayed tasks
+	if b.GetConfig().Redis != nil {
+		configuredPollPeriod := b.GetConfig().Redis.DelayedTasksPollPeriod
+		// the default period is 0, which bombards redis with requests, despite
+		// our intention of doing the opposite
+		if configuredPollPeriod > 0 {
+			pollPeriod = configuredPollPeriod
+		}
+	}
+
+	// Space out queries to ZSET so we don't bombard redis
+	// server with relentless ZRANGEBYSCOREs
+	time.Sleep(time.Duration(pollPeriod) * time.Millisecond)
+	watchFunc := func(tx *redis.Tx) error {
+		now := time.Now().UTC().UnixNano()
+
+		// https://redis.io/commands/zrangebyscore
+		ctx := context.Background()
+		items, err = tx.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+			Min: "0", Max: strconv.FormatInt(now, 10), Offset: 0, Count: 1,
+		}).Result()
+		if err != nil {
+			return err
+		}
+		if len(items) != 1 {
+			return redis.Nil
+		}
+
+		// only return the first zrange value if there are no other changes in this key
+		// to make sure a delayed task would only be consumed once
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZRem(ctx, key, items[0])
+			result = []byte(items[0])
+			return nil
+		})
+
+		return err
+	}
+
+	err = b.rclient.Watch(context.Background(), watchFunc, key)
+	return
+}
+
+// add appends an encoded signature to the stream
+func (b *Broker) add(stream string, msg []byte) error {
+	args := &redis.XAddArgs{
+		Stream: stream,
+		Values: map[string]interface{}{bodyField: msg},
+	}
+	if b.GetConfig().Redis != nil && b.GetConfig().Redis.StreamMaxLen > 0 {
+		args.MaxLen = b.GetConfig().Redis.StreamMaxLen
+		args.Approx = true
+	}
+
+	return b.rclient.XAdd(context.Background(), args).Err()
+}
+
+// createGroup creates the consumer group (and the stream) unless it exists already
+func (b *Broker) createGroup(stream string) error {
+	err := b.rclient.XGroupCreateMkStream(context.Background(), stream, b.consumerGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("Create consumer group error: %s", err)
+	}
+	return nil
+}
+
+func getQueue(config *config.Config, taskProcessor iface.TaskProcessor) string {
+	customQueue := taskProcessor.CustomQueue()
+	if customQueue == "" {
+		return config.DefaultQueue
+	}
+	return customQueue
+}
+
+// getClaimMinIdle returns how long a message has to stay pending before it is claimed
+func getClaimMinIdle(cnf *config.Config) time.Duration {
+	minIdle := defaultClaimMinIdle
+	if cnf.Redis != nil && cnf.Redis.VisibilityTimeout > 0 {
+		minIdle = cnf.Redis.VisibilityTimeout
+	}
+	return time.Duration(minIdle) * time.Second
+}
+
+// getMaxDeliveries returns how often a message may be delivered before it is dead-lettered
+func getMaxDeliveries(cnf *config.Config) int64 {
+	if cnf.Redis != nil && cnf.Redis.StreamMaxDeliveries > 0 {
+		return cnf.Redis.StreamMaxDeliveries
+	}
+	return defaultMaxDeliveries
+}
+
+// messageBody returns the encoded signature of a stream entry
+func messageBody(message redis.XMessage) []byte {
+	body, _ := message.Values[bodyField].(string)
+	return []byte(body)
+}
+
+// decodeMessages decodes signatures of stream entries
+func (b *Broker) decodeMessages(messages []redis.XMessage) ([]*tasks.Signature, error) {
+	taskSignatures := make([]*tasks.Signature, len(messages))
+	for i, message := range messages {
+		signature := new(tasks.Signature)
+		if err := b.UnmarshalSignature(messageBody(message), signature); err != nil {
+			return nil, err
+		}
+		taskSignatures[i] = signature
+	}
+	return taskSignatures, nil
+}
+
+// isNoStreamError returns true if the error is caused by a missing stream key
+func isNoStreamError(err error) bool {
+	return strings.Contains(err.Error(), "no such key")
+}
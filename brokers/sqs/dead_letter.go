@@ -0,0 +1,189 @@
+package sqs
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+
+	"github.com/oarkflow/machinery/brokers/errs"
+	"github.com/oarkflow/machinery/log"
+	"github.com/oarkflow/machinery/tasks"
+
+	awssqs "github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const (
+	// deadLetterMessageGroupID is used for dead letters on a FIFO queue when the task has no message group
+	deadLetterMessageGroupID = "machinery_dead_letters"
+)
+
+// deadLetterQueueURL returns the URL of the dead-letter queue
+func (b *Broker) deadLetterQueueURL() (*string, error) {
+	queue := b.GetDeadLetterQueue()
+	if queue == "" {
+		return nil, errs.ErrDeadLetterQueueNotConfigured
+	}
+	return aws.String(b.GetConfig().Broker + "/" + queue), nil
+}
+
+// PublishDeadLetter places a failed or unparseable message on the dead-letter queue
+func (b *Broker) PublishDeadLetter(ctx context.Context, signature *tasks.Signature) error {
+	qURL, err := b.deadLetterQueueURL()
+	if err != nil {
+		return err
+	}
+
+	msg, attributes, err := b.encodeMessage(signature)
+	if err != nil {
+		return fmt.Errorf("Marshal error: %s", err)
+	}
+
+	MsgInput := &awssqs.SendMessageInput{
+		MessageBody:       aws.String(msg),
+		MessageAttributes: attributes,
+		QueueUrl:          qURL,
+	}
+
+	if strings.HasSuffix(b.GetDeadLetterQueue(), ".fifo") {
+		MsgInput.MessageDeduplicationId = aws.String(signature.UUID)
+		MsgGroupID := signature.BrokerMessageGroupId
+		if MsgGroupID == "" {
+			MsgGroupID = deadLetterMessageGroupID
+		}
+		MsgInput.MessageGroupId = aws.String(MsgGroupID)
+	}
+
+	_, err = b.service.SendMessageWithContext(ctx, MsgInput)
+	return err
+}
+
+// GetDeadLetters returns up to limit messages from the dead-letter queue, all of them when limit is not positive.
+// SQS does not support browsing a queue, so messages are received without hiding them from other
+// consumers and the result might be incomplete for large queues.
+func (b *Broker) GetDeadLetters(limit int) ([]*tasks.Signature, error) {
+	deadLetters := make([]*tasks.Signature, 0)
+	err := b.receiveDeadLetters(func(message *awssqs.Message, signature *tasks.Signature) bool {
+		deadLetters = append(deadLetters, signature)
+		return limit <= 0 || len(deadLetters) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deadLetters, nil
+}
+
+// RemoveDeadLetter removes a message from the dead-letter queue by task UUID and returns it
+func (b *Broker) RemoveDeadLetter(taskUUID string) (*tasks.Signature, error) {
+	qURL, err := b.deadLetterQueueURL()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		deadLetter    *tasks.Signature
+		receiptHandle *string
+	)
+	err = b.receiveDeadLetters(func(message *awssqs.Message, signature *tasks.Signature) bool {
+		if signature.UUID != taskUUID {
+			return true
+		}
+		deadLetter = signature
+		receiptHandle = message.ReceiptHandle
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	if deadLetter == nil {
+		return nil, errs.ErrDeadLetterNotFound
+	}
+
+	if _, err := b.service.DeleteMessage(&awssqs.DeleteMessageInput{
+		QueueUrl:      qURL,
+		ReceiptHandle: receiptHandle,
+	}); err != nil {
+		return nil, err
+	}
+	return deadLetter, nil
+}
+
+// PurgeDeadLetters deletes all messages from the dead-letter queue
+func (b *Broker) PurgeDeadLetters() error {
+	qURL, err := b.deadLetterQueueURL()
+	if err != nil {
+		return err
+	}
+
+	_, err = b.service.PurgeQueue(&awssqs.PurgeQueueInput{QueueUrl: qURL})
+	return err
+}
+
+// receiveDeadLetters calls visit for every message received from the dead-letter queue until
+// it returns false or no more unseen messages are received. Messages stay visible to other consumers.
+func (b *Broker) receiveDeadLetters(visit func(message *awssqs.Message, signature *tasks.Signature) bool) error {
+	qURL, err := b.deadLetterQueueURL()
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]struct{})
+	for {
+		output, err := b.service.ReceiveMessage(&awssqs.ReceiveMessageInput{
+			MessageAttributeNames: []*string{
+				aws.String(contentTypeAttribute),
+			},
+			QueueUrl:            qURL,
+			MaxNumberOfMessages: aws.Int64(10),
+			VisibilityTimeout:   aws.Int64(0),
+		})
+		if err != nil {
+			return err
+		}
+
+		unseen := 0
+		for _, message := range output.Messages {
+			if _, ok := seen[*message.MessageId]; ok {
+				continue
+			}
+			seen[*message.MessageId] = struct{}{}
+			unseen++
+
+			signature := new(tasks.Signature)
+			if err := b.decodeMessage(message, signature); err != nil {
+				log.ERROR.Print(errs.NewErrCouldNotUnmarshalTaskSignature([]byte(*message.Body), err))
+				continue
+			}
+			if !visit(message, signature) {
+				return nil
+			}
+		}
+
+		if unseen == 0 {
+			return nil
+		}
+	}
+}
+
+// deadLetterUnparseable keeps a message which could not be unmarshaled in the dead-letter queue
+func (b *Broker) deadLetterUnparseable(queue string, message *awssqs.Message, err error) {
+	if b.GetDeadLetterQueue() == "" {
+		return
+	}
+
+	// Binary messages are sent base64 encoded along with their content type
+	body := []byte(*message.Body)
+	contentType := messageAttribute(message, contentTypeAttribute)
+	if contentType != "" {
+		if decoded, decodeErr := base64.StdEncoding.DecodeString(*message.Body); decodeErr == nil {
+			body = decoded
+		}
+	}
+
+	deadLetter := tasks.NewUnparseableDeadLetter(queue, body, contentType, messageAttribute(message, contentEncodingAttribute), err)
+	if err := b.PublishDeadLetter(context.Background(), deadLetter); err != nil {
+		log.ERROR.Printf("Failed to publish unparseable message to the dead-letter queue: %s", err)
+	}
+}
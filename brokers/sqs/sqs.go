@@ -2,7 +2,7 @@ package sqs
 
 import (
 	"context"
-	"encoding/json"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"strings"
@@ -15,9 +15,11 @@ import (
 
 	"github.com/oarkflow/machinery/brokers/errs"
 	"github.com/oarkflow/machinery/brokers/iface"
+	"github.com/oarkflow/machinery/codecs"
 	"github.com/oarkflow/machinery/common"
 	"github.com/oarkflow/machinery/config"
 	"github.com/oarkflow/machinery/log"
+	"github.com/oarkflow/machinery/security"
 	"github.com/oarkflow/machinery/tasks"
 
 	awssqs "github.com/aws/aws-sdk-go/service/sqs"
@@ -25,6 +27,10 @@ import (
 
 const (
 	maxAWSSQSDelay = time.Minute * 15 // Max supported SQS delay is 15 min: https://docs.aws.amazon.com/AWSSimpleQueueService/latest/APIReference/API_SendMessage.html
+	// contentTypeAttribute is the message attribute holding the content type of messages not encoded as JSON
+	contentTypeAttribute = "ContentType"
+	// contentEncodingAttribute is the message attribute holding the compression of compressed messages
+	contentEncodingAttribute = "ContentEncoding"
 )
 
 // Broker represents a AWS SQS broker
@@ -126,17 +132,18 @@ func (b *Broker) StopConsuming() {
 
 // Publish places a new message on the default queue
 func (b *Broker) Publish(ctx context.Context, signature *tasks.Signature) error {
-	msg, err := json.Marshal(signature)
+	msg, attributes, err := b.encodeMessage(signature)
 	if err != nil {
-		return fmt.Errorf("JSON marshal error: %s", err)
+		return fmt.Errorf("Marshal error: %s", err)
 	}
 
 	// Check that signature.RoutingKey is set, if not switch to DefaultQueue
 	b.AdjustRoutingKey(signature)
 
 	MsgInput := &awssqs.SendMessageInput{
-		MessageBody: aws.String(string(msg)),
-		QueueUrl:    aws.String(b.GetConfig().Broker + "/" + signature.RoutingKey),
+		MessageBody:       aws.String(msg),
+		MessageAttributes: attributes,
+		QueueUrl:          aws.String(b.GetConfig().Broker + "/" + signature.RoutingKey),
 	}
 
 	// if this is a fifo queue, there needs to be some additional parameters.
@@ -202,14 +209,22 @@ func (b *Broker) consumeOne(delivery *awssqs.ReceiveMessageOutput, taskProcessor
 	}
 
 	sig := new(tasks.Signature)
-	decoder := json.NewDecoder(strings.NewReader(*delivery.Messages[0].Body))
-	decoder.UseNumber()
-	if err := decoder.Decode(sig); err != nil {
+	if err := b.decodeMessage(delivery.Messages[0], sig); err != nil {
 		log.ERROR.Printf("unmarshal error. the delivery is %v", delivery)
+		body := []byte(*delivery.Messages[0].Body)
+		queue := taskProcessor.CustomQueue()
+		if queue == "" {
+			queue = b.GetConfig().DefaultQueue
+		}
+		b.deadLetterUnparseable(queue, delivery.Messages[0], errs.NewErrCouldNotUnmarshalTaskSignature(body, err))
 		// if the unmarshal fails, remove the delivery from the queue
 		if delErr := b.deleteOne(delivery); delErr != nil {
 			log.ERROR.Printf("error when deleting the delivery. delivery is %v, Error=%s", delivery, delErr)
 		}
+		// Keep consuming, rejected messages must not be able to interrupt the worker
+		if security.IsRejected(err) {
+			return nil
+		}
 		return err
 	}
 	if delivery.Messages[0].ReceiptHandle != nil {
@@ -367,3 +382,52 @@ func (b *Broker) getQueueURL(taskProcessor iface.TaskProcessor) *string {
 
 	return aws.String(b.GetConfig().Broker + "/" + queueName)
 }
+
+// encodeMessage encodes the signature into the body and the attributes of a message. SQS message
+// bodies have to be text, so binary messages of other codecs, signed or compressed ones are base64 encoded.
+func (b *Broker) encodeMessage(signature *tasks.Signature) (string, map[string]*awssqs.MessageAttributeValue, error) {
+	msg, contentType, contentEncoding, err := b.EncodeSignature(signature)
+	if err != nil {
+		return "", nil, err
+	}
+	if contentType == codecs.JSON.ContentType() && contentEncoding == "" {
+		return string(msg), nil, nil
+	}
+
+	attributes := map[string]*awssqs.MessageAttributeValue{
+		contentTypeAttribute: {
+			DataType:    aws.String("String"),
+			StringValue: aws.String(contentType),
+		},
+	}
+	if contentEncoding != "" {
+		attributes[contentEncodingAttribute] = &awssqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(contentEncoding),
+		}
+	}
+	return base64.StdEncoding.EncodeToString(msg), attributes, nil
+}
+
+// messageAttribute returns the value of a string attribute of the message
+func messageAttribute(message *awssqs.Message, name string) string {
+	attribute, ok := message.MessageAttributes[name]
+	if !ok || attribute.StringValue == nil {
+		return ""
+	}
+	return *attribute.StringValue
+}
+
+// decodeMessage decodes a message encoded by encodeMessage with any codec and compression
+func (b *Broker) decodeMessage(message *awssqs.Message, signature *tasks.Signature) error {
+	attribute, ok := message.MessageAttributes[contentTypeAttribute]
+	if !ok || attribute.StringValue == nil {
+		return b.UnmarshalSignature([]byte(*message.Body), signature)
+	}
+
+	msg, err := base64.StdEncoding.DecodeString(*message.Body)
+	if err != nil {
+		return err
+	}
+	return b.UnmarshalSignatureContentType(*attribute.StringValue, msg, signature)
+}
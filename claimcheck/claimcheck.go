@@ -0,0 +1,445 @@
+package claimcheck
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/oarkflow/machinery/blobs/iface"
+	"github.com/oarkflow/machinery/common"
+	"github.com/oarkflow/machinery/compression"
+	"github.com/oarkflow/machinery/config"
+	"github.com/oarkflow/machinery/factory"
+	"github.com/oarkflow/machinery/security"
+	"github.com/oarkflow/machinery/tasks"
+)
+
+// Headers of signatures whose arguments are kept in the blob store
+const (
+	// ArgsHeader holds the key of the blob keeping the JSON encoded arguments
+	ArgsHeader = "claim_check_args"
+	// ArgsKeyIDHeader names the key which encrypted the blob of the arguments
+	ArgsKeyIDHeader = "claim_check_key_id"
+	// ArgsDigestHeader holds the hex encoded SHA-256 digest of the blob of the arguments, which
+	// authenticates the blob together with the signature of the message
+	ArgsDigestHeader = "claim_check_sha256"
+	// ArgsResolvedHeader holds the number of arguments loaded from the blob store by the worker.
+	// The reference to the blob is kept, so that a retried task does not store them again.
+	ArgsResolvedHeader = "claim_check_resolved"
+)
+
+var (
+	// ErrNotConfigured is returned when arguments or results kept in the blob store are
+	// resolved without a claim check configured
+	ErrNotConfigured = errors.New("Claim check not configured")
+	// ErrDigestMismatch is returned when the blob of the arguments does not match the digest
+	// of the message referencing it
+	ErrDigestMismatch = errors.New("Blob does not match its digest")
+
+	claimChecks sync.Map
+)
+
+// ClaimCheck keeps task arguments and results larger than a threshold in a blob store and
+// replaces them by references to their blobs. A nil ClaimCheck keeps nothing there.
+type ClaimCheck struct {
+	cnf       *config.Config
+	store     iface.Store
+	threshold int
+}
+
+// ForConfig returns the ClaimCheck of the config, nil if it is not configured.
+// It is created once per config.
+func ForConfig(cnf *config.Config) (*ClaimCheck, error) {
+	if cnf == nil || cnf.ClaimCheck == nil {
+		return nil, nil
+	}
+
+	if claimCheck, ok := claimChecks.Load(cnf.ClaimCheck); ok {
+		return claimCheck.(*ClaimCheck), nil
+	}
+
+	claimCheck, err := New(cnf)
+	if err != nil {
+		return nil, err
+	}
+	// Blob stores kept in memory must be shared, so the first one created wins
+	stored, _ := claimChecks.LoadOrStore(cnf.ClaimCheck, claimCheck)
+	return stored.(*ClaimCheck), nil
+}
+
+// New creates ClaimCheck instance with the blob store of the config
+func New(cnf *config.Config) (*ClaimCheck, error) {
+	store := cnf.ClaimCheck.Store
+	if store == nil {
+		var err error
+		if store, err = factory.BlobStoreFactory(cnf); err != nil {
+			return nil, err
+		}
+	}
+
+	threshold := cnf.ClaimCheck.Threshold
+	if threshold <= 0 {
+		threshold = config.DefaultClaimCheckThreshold
+	}
+
+	return &ClaimCheck{cnf: cnf, store: store, threshold: threshold}, nil
+}
+
+// CheckSignature returns a copy of the signature, and of its callbacks, with arguments larger than
+// the threshold kept in the blob store. The signature is returned as is if the claim check is not
+// configured. A signature whose arguments have been loaded from the blob store by ResolveSignature,
+// such as a retried task, keeps the reference to them. Arguments appended to a signature whose
+// arguments are kept in the blob store, such as results passed on to a callback, are kept together
+// with them in a new blob, which replaces the former one.
+func (c *ClaimCheck) CheckSignature(ctx context.Context, signature *tasks.Signature) (*tasks.Signature, error) {
+	return c.checkSignature(ctx, signature, false)
+}
+
+// CheckWorkflow returns a copy of the workflow with the arguments of its nodes checked by CheckSignature.
+// Their blobs expire together with task states, as the blobs of nodes which never run are not deleted.
+func (c *ClaimCheck) CheckWorkflow(ctx context.Context, workflow *tasks.Workflow) (*tasks.Workflow, error) {
+	if c == nil {
+		return workflow, nil
+	}
+
+	checked := *workflow
+	checked.Nodes = make(map[string]*tasks.Signature, len(workflow.Nodes))
+	for name, signature := range workflow.Nodes {
+		checkedSignature, err := c.checkSignature(ctx, signature, true)
+		if err != nil {
+			return nil, err
+		}
+		checked.Nodes[name] = checkedSignature
+	}
+	return &checked, nil
+}
+
+// checkSignature implements CheckSignature, keeping the arguments in expiring blobs if expiring is set
+func (c *ClaimCheck) checkSignature(ctx context.Context, signature *tasks.Signature, expiring bool) (*tasks.Signature, error) {
+	if c == nil || signature == nil {
+		return signature, nil
+	}
+
+	checked := *signature
+	var replacedKey string
+	if key, ok := signature.Headers[ArgsHeader].(string); ok {
+		if resolved, ok := signature.Headers[ArgsResolvedHeader]; ok {
+			count, err := resolvedCount(resolved)
+			if err != nil || count < 0 || count > len(signature.Args) {
+				return nil, fmt.Errorf("Invalid %s header: %v", ArgsResolvedHeader, resolved)
+			}
+			checked.Args = signature.Args[count:]
+			checked.Headers = copyHeaders(signature.Headers, ArgsResolvedHeader)
+			return c.checkCallbacks(ctx, signature, &checked, expiring)
+		}
+		if len(signature.Args) == 0 {
+			return c.checkCallbacks(ctx, signature, &checked, expiring)
+		}
+
+		if err := c.resolveArgs(ctx, &checked); err != nil {
+			return nil, err
+		}
+		checked.Headers = copyHeaders(checked.Headers, ArgsHeader, ArgsKeyIDHeader, ArgsDigestHeader, ArgsResolvedHeader)
+		replacedKey = key
+	}
+
+	if len(checked.Args) > 0 {
+		encoded, err := json.Marshal(checked.Args)
+		if err != nil {
+			return nil, fmt.Errorf("JSON marshal error: %s", err)
+		}
+
+		if len(encoded) > c.threshold {
+			key := fmt.Sprintf("args/%s", uuid.New().String())
+			keyID, digest, err := c.put(ctx, key, encoded, expiring)
+			if err != nil {
+				return nil, err
+			}
+
+			headers := copyHeaders(checked.Headers)
+			headers[ArgsHeader] = key
+			headers[ArgsDigestHeader] = digest
+			if keyID != "" {
+				headers[ArgsKeyIDHeader] = keyID
+			}
+			checked.Headers = headers
+			checked.Args = nil
+		}
+	}
+
+	if replacedKey != "" {
+		if err := c.store.Delete(ctx, replacedKey); err != nil {
+			return nil, fmt.Errorf("Blob store error: %s", err)
+		}
+	}
+	return c.checkCallbacks(ctx, signature, &checked, expiring)
+}
+
+// checkCallbacks checks the callbacks of the signature into its checked copy
+func (c *ClaimCheck) checkCallbacks(ctx context.Context, signature, checked *tasks.Signature, expiring bool) (*tasks.Signature, error) {
+	var err error
+	if checked.OnSuccess, err = c.checkSignatures(ctx, signature.OnSuccess, expiring); err != nil {
+		return nil, err
+	}
+	if checked.OnError, err = c.checkSignatures(ctx, signature.OnError, expiring); err != nil {
+		return nil, err
+	}
+	if checked.ChordCallback, err = c.checkSignature(ctx, signature.ChordCallback, expiring); err != nil {
+		return nil, err
+	}
+	return checked, nil
+}
+
+func (c *ClaimCheck) checkSignatures(ctx context.Context, signatures []*tasks.Signature, expiring bool) ([]*tasks.Signature, error) {
+	if signatures == nil {
+		return nil, nil
+	}
+
+	checked := make([]*tasks.Signature, len(signatures))
+	for i, signature := range signatures {
+		checkedSignature, err := c.checkSignature(ctx, signature, expiring)
+		if err != nil {
+			return nil, err
+		}
+		checked[i] = checkedSignature
+	}
+	return checked, nil
+}
+
+// ResolveSignature loads the arguments of the signature kept in the blob store by CheckSignature.
+// Callbacks are left as they are, they are resolved by the worker processing them.
+func (c *ClaimCheck) ResolveSignature(ctx context.Context, signature *tasks.Signature) error {
+	if _, ok := signature.Headers[ArgsHeader]; !ok {
+		return nil
+	}
+	if _, ok := signature.Headers[ArgsResolvedHeader]; ok {
+		return nil
+	}
+	if c == nil {
+		return ErrNotConfigured
+	}
+	return c.resolveArgs(ctx, signature)
+}
+
+// resolveArgs places the arguments kept in the blob store before the other arguments of the signature
+// and counts them in a header. Headers are copied as they might be shared with other signatures.
+func (c *ClaimCheck) resolveArgs(ctx context.Context, signature *tasks.Signature) error {
+	key, _ := signature.Headers[ArgsHeader].(string)
+	keyID, _ := signature.Headers[ArgsKeyIDHeader].(string)
+	digest, _ := signature.Headers[ArgsDigestHeader].(string)
+
+	encoded, err := c.get(ctx, key, keyID, digest)
+	if err != nil {
+		return err
+	}
+
+	var args []tasks.Arg
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	if err := decoder.Decode(&args); err != nil {
+		return fmt.Errorf("JSON unmarshal error: %s", err)
+	}
+
+	signature.Headers = copyHeaders(signature.Headers)
+	signature.Headers[ArgsResolvedHeader] = len(args)
+	signature.Args = append(args, signature.Args...)
+	return nil
+}
+
+// DeleteArgs removes the blob keeping the arguments of the signature, if any, once its task
+// will not run again
+func (c *ClaimCheck) DeleteArgs(ctx context.Context, signature *tasks.Signature) error {
+	key, ok := signature.Headers[ArgsHeader].(string)
+	if !ok {
+		return nil
+	}
+	if c == nil {
+		return ErrNotConfigured
+	}
+
+	if err := c.store.Delete(ctx, key); err != nil {
+		return fmt.Errorf("Blob store error: %s", err)
+	}
+	return nil
+}
+
+// copyHeaders returns a copy of the headers without the excluded ones
+func copyHeaders(headers tasks.Headers, excluded ...string) tasks.Headers {
+	copied := make(tasks.Headers, len(headers)+3)
+	for header, value := range headers {
+		copied[header] = value
+	}
+	for _, header := range excluded {
+		delete(copied, header)
+	}
+	return copied
+}
+
+// resolvedCount returns the number of resolved arguments held by the header value
+func resolvedCount(value interface{}) (int, error) {
+	switch value := value.(type) {
+	case int:
+		return value, nil
+	case int64:
+		return int(value), nil
+	case uint64:
+		return int(value), nil
+	case float64:
+		return int(value), nil
+	case json.Number:
+		count, err := value.Int64()
+		return int(count), err
+	case string:
+		return strconv.Atoi(value)
+	}
+	return 0, fmt.Errorf("Unexpected type %T", value)
+}
+
+// CheckTaskResults returns the task results with the values larger than the threshold kept in
+// the blob store. Compressed or encrypted values are kept as they are. The results are returned
+// as they are if the claim check is not configured.
+func (c *ClaimCheck) CheckTaskResults(ctx context.Context, taskUUID string, taskResults []*tasks.TaskResult) ([]*tasks.TaskResult, error) {
+	if c == nil {
+		return taskResults, nil
+	}
+
+	checked := make([]*tasks.TaskResult, len(taskResults))
+	for i, taskResult := range taskResults {
+		var encoded []byte
+		var err error
+		if taskResult.IsEncoded() {
+			encoded, err = taskResult.EncodedValue()
+		} else {
+			encoded, err = json.Marshal(taskResult.Value)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(encoded) <= c.threshold {
+			checked[i] = taskResult
+			continue
+		}
+
+		key := fmt.Sprintf("results/%s/%d", taskUUID, i)
+		if err := c.putBlob(ctx, key, encoded, true); err != nil {
+			return nil, fmt.Errorf("Blob store error: %s", err)
+		}
+		checked[i] = &tasks.TaskResult{
+			Type:     taskResult.Type,
+			Encoding: taskResult.Encoding,
+			KeyID:    taskResult.KeyID,
+			Ref:      key,
+		}
+	}
+	return checked, nil
+}
+
+// ResolveTaskResults loads the values of task results kept in the blob store by CheckTaskResults in place
+func (c *ClaimCheck) ResolveTaskResults(ctx context.Context, taskResults []*tasks.TaskResult) error {
+	for _, taskResult := range taskResults {
+		if taskResult.Ref == "" {
+			continue
+		}
+		if c == nil {
+			return ErrNotConfigured
+		}
+
+		encoded, err := c.store.Get(ctx, taskResult.Ref)
+		if err != nil {
+			return fmt.Errorf("Blob store error: %s", err)
+		}
+
+		if taskResult.IsEncoded() {
+			taskResult.Value = encoded
+		} else {
+			var value interface{}
+			decoder := json.NewDecoder(bytes.NewReader(encoded))
+			decoder.UseNumber()
+			if err := decoder.Decode(&value); err != nil {
+				return fmt.Errorf("JSON unmarshal error: %s", err)
+			}
+			taskResult.Value = value
+		}
+		taskResult.Ref = ""
+	}
+	return nil
+}
+
+// putBlob stores the data under the key, with the expiry of task states if expiring is set
+// and the store supports it
+func (c *ClaimCheck) putBlob(ctx context.Context, key string, data []byte, expiring bool) error {
+	store, ok := c.store.(iface.ExpiringStore)
+	if !expiring || !ok {
+		return c.store.Put(ctx, key, data)
+	}
+
+	expiresIn := c.cnf.ResultsExpireIn
+	if expiresIn == 0 {
+		// expire results after 1 hour by default
+		expiresIn = config.DefaultResultsExpireIn
+	}
+	return store.PutExpiring(ctx, key, data, time.Duration(expiresIn)*time.Second)
+}
+
+// put compresses and encrypts the data if configured and stores it under the key.
+// It returns the ID of the key which encrypted the data and the digest of the blob.
+func (c *ClaimCheck) put(ctx context.Context, key string, data []byte, expiring bool) (string, string, error) {
+	sec, err := security.ForConfig(c.cnf)
+	if err != nil {
+		return "", "", err
+	}
+
+	data, _, err = common.Compress(c.cnf, data)
+	if err != nil {
+		return "", "", err
+	}
+	data, keyID, err := sec.Encrypt(data, []byte(key))
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := c.putBlob(ctx, key, data, expiring); err != nil {
+		return "", "", fmt.Errorf("Blob store error: %s", err)
+	}
+	digest := sha256.Sum256(data)
+	return keyID, hex.EncodeToString(digest[:]), nil
+}
+
+// get returns the data stored by put under the key once it matches the digest, decrypted with
+// the key of keyID and decompressed
+func (c *ClaimCheck) get(ctx context.Context, key, keyID, digest string) ([]byte, error) {
+	data, err := c.store.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("Blob store error: %s", err)
+	}
+
+	expected, err := hex.DecodeString(digest)
+	if err != nil {
+		return nil, ErrDigestMismatch
+	}
+	actual := sha256.Sum256(data)
+	if subtle.ConstantTimeCompare(expected, actual[:]) != 1 {
+		return nil, ErrDigestMismatch
+	}
+
+	sec, err := security.ForConfig(c.cnf)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = sec.Decrypt(keyID, data, []byte(key)); err != nil {
+		return nil, err
+	}
+
+	return compression.Decompress(data, common.MaxDecompressedSize(c.cnf))
+}
@@ -0,0 +1,38 @@
+package codecs
+
+import (
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+
+	"github.com/oarkflow/machinery/tasks"
+)
+
+var (
+	cborEncMode, _ = cbor.EncOptions{
+		Time: cbor.TimeRFC3339Nano,
+	}.EncMode()
+	cborDecMode, _ = cbor.DecOptions{
+		DefaultMapType: reflect.TypeOf(map[string]interface{}(nil)),
+	}.DecMode()
+)
+
+// cborCodec encodes signatures as CBOR. Struct fields are named after their
+// json tags, like with JSON, and times are encoded as RFC 3339 strings.
+type cborCodec struct{}
+
+// ContentType ...
+func (cborCodec) ContentType() string {
+	return "application/cbor"
+}
+
+// Marshal ...
+func (cborCodec) Marshal(signature *tasks.Signature) ([]byte, error) {
+	signature = normalizeNumbers(signature)
+	return cborEncMode.Marshal(signature)
+}
+
+// Unmarshal ...
+func (cborCodec) Unmarshal(data []byte, signature *tasks.Signature) error {
+	return cborDecMode.Unmarshal(data, signature)
+}
@@ -0,0 +1,142 @@
+package codecs
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/oarkflow/machinery/compression"
+	"github.com/oarkflow/machinery/config"
+	"github.com/oarkflow/machinery/tasks"
+)
+
+// Codec encodes task signatures into message bodies and decodes them back
+type Codec interface {
+	// ContentType identifies the codec in message headers
+	ContentType() string
+	Marshal(signature *tasks.Signature) ([]byte, error)
+	Unmarshal(data []byte, signature *tasks.Signature) error
+}
+
+var (
+	// JSON encodes signatures as JSON, the default
+	JSON Codec = jsonCodec{}
+	// MessagePack encodes signatures as MessagePack
+	MessagePack Codec = msgpackCodec{}
+	// CBOR encodes signatures as CBOR
+	CBOR Codec = cborCodec{}
+
+	codecsByName = map[string]Codec{
+		"json":    JSON,
+		"msgpack": MessagePack,
+		"cbor":    CBOR,
+	}
+)
+
+// frameDelimiter starts and ends the content type framing messages sent over transports
+// without message headers. A JSON signature can never start with it.
+const frameDelimiter = 0
+
+// ErrUnknownCodec ...
+type ErrUnknownCodec struct {
+	codec string
+}
+
+// Error implements error interface
+func (e ErrUnknownCodec) Error() string {
+	return fmt.Sprintf("Unknown codec: %s", e.codec)
+}
+
+// ForName returns the codec with the given name (json, msgpack or cbor), JSON when empty
+func ForName(name string) (Codec, error) {
+	if name == "" {
+		return JSON, nil
+	}
+
+	codec, ok := codecsByName[name]
+	if !ok {
+		return nil, ErrUnknownCodec{codec: name}
+	}
+	return codec, nil
+}
+
+// ForContentType returns the codec with the given content type, JSON when empty
+func ForContentType(contentType string) (Codec, error) {
+	if contentType == "" {
+		return JSON, nil
+	}
+
+	for _, codec := range codecsByName {
+		if codec.ContentType() == contentType {
+			return codec, nil
+		}
+	}
+	return nil, ErrUnknownCodec{codec: contentType}
+}
+
+// Marshal encodes the signature for transports without message headers. Messages of
+// codecs other than JSON are framed with their content type, so that consumers can
+// decode queues holding messages of different codecs.
+func Marshal(codec Codec, signature *tasks.Signature) ([]byte, error) {
+	body, err := codec.Marshal(signature)
+	if err != nil {
+		return nil, err
+	}
+	if codec == JSON {
+		return body, nil
+	}
+
+	contentType := codec.ContentType()
+	message := make([]byte, 0, len(contentType)+len(body)+2)
+	message = append(message, frameDelimiter)
+	message = append(message, contentType...)
+	message = append(message, frameDelimiter)
+	return append(message, body...), nil
+}
+
+// Unmarshal decodes a message encoded by Marshal with any codec, decompressing it if needed
+// up to the default maximum decompressed size
+func Unmarshal(data []byte, signature *tasks.Signature) error {
+	data, err := compression.Decompress(data, config.DefaultMaxDecompressedSize)
+	if err != nil {
+		return err
+	}
+
+	contentType, body := unframe(data)
+	codec, err := ForContentType(contentType)
+	if err != nil {
+		return err
+	}
+	return codec.Unmarshal(body, signature)
+}
+
+// UnmarshalContentType decodes a message body with the codec of the content type given
+// in the message headers, or as encoded by Marshal when the headers do not tell
+func UnmarshalContentType(contentType string, data []byte, signature *tasks.Signature) error {
+	if contentType == "" || contentType == JSON.ContentType() {
+		return Unmarshal(data, signature)
+	}
+
+	codec, err := ForContentType(contentType)
+	if err != nil {
+		return err
+	}
+
+	data, err = compression.Decompress(data, config.DefaultMaxDecompressedSize)
+	if err != nil {
+		return err
+	}
+	return codec.Unmarshal(data, signature)
+}
+
+// unframe returns the content type and the body of a message encoded by Marshal
+func unframe(data []byte) (string, []byte) {
+	if len(data) == 0 || data[0] != frameDelimiter {
+		return "", data
+	}
+
+	end := bytes.IndexByte(data[1:], frameDelimiter)
+	if end < 0 {
+		return "", data
+	}
+	return string(data[1 : end+1]), data[end+2:]
+}
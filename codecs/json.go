@@ -0,0 +1,28 @@
+package codecs
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/oarkflow/machinery/tasks"
+)
+
+// jsonCodec encodes signatures as JSON
+type jsonCodec struct{}
+
+// ContentType ...
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+// Marshal ...
+func (jsonCodec) Marshal(signature *tasks.Signature) ([]byte, error) {
+	return json.Marshal(signature)
+}
+
+// Unmarshal decodes numbers as json.Number, so that integers do not lose precision
+func (jsonCodec) Unmarshal(data []byte, signature *tasks.Signature) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(signature)
+}
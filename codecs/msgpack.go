@@ -0,0 +1,39 @@
+package codecs
+
+import (
+	"bytes"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/oarkflow/machinery/tasks"
+)
+
+// msgpackCodec encodes signatures as MessagePack. Struct fields are named after
+// their json tags, like with JSON.
+type msgpackCodec struct{}
+
+// ContentType ...
+func (msgpackCodec) ContentType() string {
+	return "application/x-msgpack"
+}
+
+// Marshal ...
+func (msgpackCodec) Marshal(signature *tasks.Signature) ([]byte, error) {
+	signature = normalizeNumbers(signature)
+
+	var buf bytes.Buffer
+	encoder := msgpack.NewEncoder(&buf)
+	encoder.SetCustomStructTag("json")
+	if err := encoder.Encode(signature); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes integers into the smallest integer type holding them and binary
+// data as []byte
+func (msgpackCodec) Unmarshal(data []byte, signature *tasks.Signature) error {
+	decoder := msgpack.NewDecoder(bytes.NewReader(data))
+	decoder.SetCustomStructTag("json")
+	return decoder.Decode(signature)
+}
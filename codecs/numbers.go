@@ -0,0 +1,81 @@
+package codecs
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/oarkflow/machinery/tasks"
+)
+
+// normalizeNumbers returns a copy of the signature with the json.Number values of a signature
+// decoded from JSON, e.g. when it gets retried, replaced with actual numbers, as other codecs
+// would encode them as strings. The signature itself is left as it is, as its arguments,
+// headers and callbacks may be shared with other signatures.
+func normalizeNumbers(signature *tasks.Signature) *tasks.Signature {
+	if signature == nil {
+		return nil
+	}
+
+	normalized := *signature
+	if signature.Args != nil {
+		normalized.Args = make([]tasks.Arg, len(signature.Args))
+		for i, arg := range signature.Args {
+			arg.Value = normalizeNumber(arg.Value)
+			normalized.Args[i] = arg
+		}
+	}
+	if signature.Headers != nil {
+		normalized.Headers = make(tasks.Headers, len(signature.Headers))
+		for key, value := range signature.Headers {
+			normalized.Headers[key] = normalizeNumber(value)
+		}
+	}
+
+	normalized.OnSuccess = normalizeCallbacks(signature.OnSuccess)
+	normalized.OnError = normalizeCallbacks(signature.OnError)
+	normalized.ChordCallback = normalizeNumbers(signature.ChordCallback)
+	return &normalized
+}
+
+// normalizeCallbacks returns normalized copies of the callbacks
+func normalizeCallbacks(callbacks []*tasks.Signature) []*tasks.Signature {
+	if callbacks == nil {
+		return nil
+	}
+	normalized := make([]*tasks.Signature, len(callbacks))
+	for i, callback := range callbacks {
+		normalized[i] = normalizeNumbers(callback)
+	}
+	return normalized
+}
+
+// normalizeNumber converts a json.Number, also within slices and maps, to int64, uint64
+// or float64. Slices and maps are copied rather than modified.
+func normalizeNumber(value interface{}) interface{} {
+	switch value := value.(type) {
+	case json.Number:
+		if n, err := value.Int64(); err == nil {
+			return n
+		}
+		if n, err := strconv.ParseUint(string(value), 10, 64); err == nil {
+			return n
+		}
+		if f, err := value.Float64(); err == nil {
+			return f
+		}
+		return value.String()
+	case []interface{}:
+		normalized := make([]interface{}, len(value))
+		for i := range value {
+			normalized[i] = normalizeNumber(value[i])
+		}
+		return normalized
+	case map[string]interface{}:
+		normalized := make(map[string]interface{}, len(value))
+		for key := range value {
+			normalized[key] = normalizeNumber(value[key])
+		}
+		return normalized
+	}
+	return value
+}
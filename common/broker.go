@@ -5,9 +5,11 @@ import (
 	"sync"
 
 	"github.com/oarkflow/machinery/brokers/iface"
+	"github.com/oarkflow/machinery/codecs"
 	"github.com/oarkflow/machinery/config"
 	"github.com/oarkflow/machinery/log"
 	"github.com/oarkflow/machinery/retry"
+	"github.com/oarkflow/machinery/security"
 	"github.com/oarkflow/machinery/tasks"
 )
 
@@ -41,6 +43,105 @@ func (b *Broker) GetConfig() *config.Config {
 	return b.cnf
 }
 
+// GetCodec returns the codec encoding published messages, JSON if not configured
+func (b *Broker) GetCodec() codecs.Codec {
+	if b.cnf == nil {
+		return codecs.JSON
+	}
+
+	codec, err := codecs.ForName(b.cnf.Codec)
+	if err != nil {
+		return codecs.JSON
+	}
+	return codec
+}
+
+// Compress compresses an encoded message larger than the compression threshold, see Compress
+func (b *Broker) Compress(msg []byte) ([]byte, string, error) {
+	return Compress(b.cnf, msg)
+}
+
+// EncodeSignature encrypts the arguments of the signature if configured, encodes it with the codec of
+// the broker, compresses and signs it. It returns the content type and the content encoding of the
+// message for transports with message headers.
+func (b *Broker) EncodeSignature(signature *tasks.Signature) ([]byte, string, string, error) {
+	sec, err := security.ForConfig(b.cnf)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	codec := b.GetCodec()
+	if sec.VerifiesSignatures() {
+		msg, err := b.MarshalSignature(signature)
+		return msg, security.ContentType, "", err
+	}
+
+	sealed, err := sec.SealSignature(signature)
+	if err != nil {
+		return nil, "", "", err
+	}
+	msg, err := codec.Marshal(sealed)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	msg, contentEncoding, err := b.Compress(msg)
+	return msg, codec.ContentType(), contentEncoding, err
+}
+
+// MarshalSignature is like EncodeSignature for transports without message headers, the message
+// tells its codec itself
+func (b *Broker) MarshalSignature(signature *tasks.Signature) ([]byte, error) {
+	sec, err := security.ForConfig(b.cnf)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := sec.SealSignature(signature)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := codecs.Marshal(b.GetCodec(), sealed)
+	if err != nil {
+		return nil, err
+	}
+	// The compressed message gets signed, so that it is verified before being decompressed
+	if msg, _, err = b.Compress(msg); err != nil {
+		return nil, err
+	}
+	return sec.Sign(msg)
+}
+
+// UnmarshalSignature decodes a message encoded by MarshalSignature, verifying its signature
+// and decrypting its arguments
+func (b *Broker) UnmarshalSignature(data []byte, signature *tasks.Signature) error {
+	return b.UnmarshalSignatureContentType("", data, signature)
+}
+
+// UnmarshalSignatureContentType decodes a message encoded by EncodeSignature with the content
+// type given in the message headers
+func (b *Broker) UnmarshalSignatureContentType(contentType string, data []byte, signature *tasks.Signature) error {
+	sec, err := security.ForConfig(b.cnf)
+	if err != nil {
+		return err
+	}
+
+	if security.IsSigned(data) || sec.VerifiesSignatures() {
+		if data, err = sec.Verify(data); err != nil {
+			return err
+		}
+		contentType = ""
+	}
+	if data, err = Decompress(b.cnf, data); err != nil {
+		return err
+	}
+
+	if err := codecs.UnmarshalContentType(contentType, data, signature); err != nil {
+		return err
+	}
+	return sec.OpenSignature(signature)
+}
+
 // GetRetry ...
 func (b *Broker) GetRetry() bool {
 	return b.retry
@@ -85,6 +186,11 @@ func (b *Broker) IsTaskRegistered(name string) bool {
 	return false
 }
 
+// GetDeadLetterQueue returns the dead-letter queue name, empty when dead-lettering is disabled
+func (b *Broker) GetDeadLetterQueue() string {
+	return b.cnf.DeadLetterQueue
+}
+
 // GetPendingTasks returns a slice of task.Signatures waiting in the queue
 func (b *Broker) GetPendingTasks(queue string) ([]*tasks.Signature, error) {
 	return nil, errors.New("Not implemented")
@@ -0,0 +1,39 @@
+package common
+
+import (
+	"github.com/oarkflow/machinery/compression"
+	"github.com/oarkflow/machinery/config"
+)
+
+// Compress compresses an encoded message or task result larger than the compression threshold
+// of the config. It returns the content encoding of the data, which is empty when it was not compressed.
+func Compress(cnf *config.Config, data []byte) ([]byte, string, error) {
+	if cnf == nil {
+		return data, "", nil
+	}
+
+	compressor, err := compression.ForName(cnf.Compression)
+	if err != nil {
+		return nil, "", err
+	}
+
+	threshold := cnf.CompressionThreshold
+	if threshold == 0 {
+		threshold = config.DefaultCompressionThreshold
+	}
+	return compression.Compress(compressor, threshold, data)
+}
+
+// Decompress decompresses a message or task result compressed by Compress, rejecting
+// data which decompresses to more than the maximum decompressed size of the config
+func Decompress(cnf *config.Config, data []byte) ([]byte, error) {
+	return compression.Decompress(data, MaxDecompressedSize(cnf))
+}
+
+// MaxDecompressedSize returns the maximum size in bytes of decompressed data of the config
+func MaxDecompressedSize(cnf *config.Config) int {
+	if cnf == nil || cnf.MaxDecompressedSize == 0 {
+		return config.DefaultMaxDecompressedSize
+	}
+	return cnf.MaxDecompressedSize
+}
@@ -2,11 +2,14 @@ package common
 
 import (
 	"crypto/tls"
+	"strings"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
 
 	"github.com/oarkflow/machinery/config"
+
+	goredis "github.com/redis/go-redis/v9"
 )
 
 var (
@@ -85,3 +88,35 @@ func (rc *RedisConnector) open(socketPath, host, password string, db int, cnf *c
 
 	return redis.Dial("tcp", host, opts...)
 }
+
+// NewUniversalClient returns a go-redis client for the addresses, which connects with the TLS
+// config and the sentinel master name, pool size and timeouts of the Redis config, like the
+// pools returned by NewPool. A password may precede the first address followed by @.
+func NewUniversalClient(cnf *config.Config, addrs []string, db int) goredis.UniversalClient {
+	redisCnf := cnf.Redis
+	if redisCnf == nil {
+		redisCnf = defaultConfig
+	}
+
+	addrs = append([]string(nil), addrs...)
+	var password string
+	parts := strings.Split(addrs[0], "@")
+	if len(parts) >= 2 {
+		password = strings.Join(parts[:len(parts)-1], "@")
+		addrs[0] = parts[len(parts)-1] // addr is the last one without @
+	}
+
+	return goredis.NewUniversalClient(&goredis.UniversalOptions{
+		Addrs:           addrs,
+		DB:              db,
+		Password:        password,
+		TLSConfig:       cnf.TLSConfig,
+		MasterName:      redisCnf.MasterName,
+		PoolSize:        redisCnf.MaxActive,
+		MaxIdleConns:    redisCnf.MaxIdle,
+		ConnMaxIdleTime: time.Duration(redisCnf.IdleTimeout) * time.Second,
+		ReadTimeout:     time.Duration(redisCnf.ReadTimeout) * time.Second,
+		WriteTimeout:    time.Duration(redisCnf.WriteTimeout) * time.Second,
+		DialTimeout:     time.Duration(redisCnf.ConnectTimeout) * time.Second,
+	})
+}
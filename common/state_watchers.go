@@ -0,0 +1,84 @@
+package common
+
+import (
+	"sync"
+)
+
+// StateWatchers keeps track of the callers waiting for state changes of tasks
+// and wakes them up when the backend gets notified of a change
+type StateWatchers struct {
+	mu       sync.Mutex
+	watchers map[string]map[chan struct{}]struct{}
+}
+
+// Watch registers a new watcher of the task. The subscribe function is called when the task
+// gets its first watcher and unsubscribe when its last watcher stops watching. They are never
+// called concurrently. It returns a channel signalled when the state of the task may have changed
+// and a function to call to stop watching.
+func (w *StateWatchers) Watch(taskUUID string, subscribe func() error, unsubscribe func()) (<-chan struct{}, func(), error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.watchers == nil {
+		w.watchers = make(map[string]map[chan struct{}]struct{})
+	}
+
+	watchers, ok := w.watchers[taskUUID]
+	if !ok {
+		watchers = make(map[chan struct{}]struct{})
+		w.watchers[taskUUID] = watchers
+		if subscribe != nil {
+			if err := subscribe(); err != nil {
+				delete(w.watchers, taskUUID)
+				return nil, nil, err
+			}
+		}
+	}
+
+	notifications := make(chan struct{}, 1)
+	watchers[notifications] = struct{}{}
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+
+			delete(watchers, notifications)
+			if len(watchers) > 0 {
+				return
+			}
+			delete(w.watchers, taskUUID)
+			if unsubscribe != nil {
+				unsubscribe()
+			}
+		})
+	}
+
+	return notifications, stop, nil
+}
+
+// Notify wakes up the watchers of the task without blocking
+func (w *StateWatchers) Notify(taskUUID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for notifications := range w.watchers[taskUUID] {
+		select {
+		case notifications <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Tasks returns the UUIDs of the watched tasks
+func (w *StateWatchers) Tasks() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	taskUUIDs := make([]string, 0, len(w.watchers))
+	for taskUUID := range w.watchers {
+		taskUUIDs = append(taskUUIDs, taskUUID)
+	}
+	return taskUUIDs
+}
@@ -0,0 +1,105 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// Compressor compresses message bodies and task results
+type Compressor interface {
+	// ContentEncoding identifies the compression in message headers
+	ContentEncoding() string
+	Compress(data []byte) ([]byte, error)
+	// Decompress fails with ErrMaxSizeExceeded when the data decompresses to more than
+	// maxSize bytes, the size is not limited if maxSize is not positive
+	Decompress(data []byte, maxSize int) ([]byte, error)
+}
+
+var (
+	// Gzip compresses with gzip
+	Gzip Compressor = gzipCompressor{}
+	// Zstd compresses with Zstandard
+	Zstd Compressor = zstdCompressor{}
+
+	compressorsByName = map[string]Compressor{
+		"gzip": Gzip,
+		"zstd": Zstd,
+	}
+
+	// magicBytes are the bytes every payload compressed by a compressor starts with
+	magicBytes = map[Compressor][]byte{
+		Gzip: {0x1f, 0x8b},
+		Zstd: {0x28, 0xb5, 0x2f, 0xfd},
+	}
+)
+
+// ErrMaxSizeExceeded is returned when data decompresses to more than the maximum size
+var ErrMaxSizeExceeded = errors.New("Decompressed size exceeds the maximum size")
+
+// ErrUnknownCompression ...
+type ErrUnknownCompression struct {
+	compression string
+}
+
+// Error implements error interface
+func (e ErrUnknownCompression) Error() string {
+	return fmt.Sprintf("Unknown compression: %s", e.compression)
+}
+
+// ForContentEncoding returns the compressor with the given content encoding
+func ForContentEncoding(contentEncoding string) (Compressor, error) {
+	for _, compressor := range compressorsByName {
+		if compressor.ContentEncoding() == contentEncoding {
+			return compressor, nil
+		}
+	}
+	return nil, ErrUnknownCompression{compression: contentEncoding}
+}
+
+// ForName returns the compressor with the given name (gzip or zstd), nil when empty
+func ForName(name string) (Compressor, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	compressor, ok := compressorsByName[name]
+	if !ok {
+		return nil, ErrUnknownCompression{compression: name}
+	}
+	return compressor, nil
+}
+
+// Compress compresses the data with the compressor when it is larger than threshold bytes.
+// It returns the content encoding of the result, which is empty if it was not compressed.
+func Compress(compressor Compressor, threshold int, data []byte) ([]byte, string, error) {
+	if compressor == nil || len(data) <= threshold {
+		return data, "", nil
+	}
+
+	compressed, err := compressor.Compress(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("Compression error: %s", err)
+	}
+	// Incompressible data is not worth decompressing
+	if len(compressed) >= len(data) {
+		return data, "", nil
+	}
+	return compressed, compressor.ContentEncoding(), nil
+}
+
+// Decompress detects compressed data by its magic bytes and decompresses it, other data
+// is returned as is. Neither JSON, MessagePack nor CBOR messages start with these bytes.
+// Data decompressing to more than maxSize bytes is rejected if maxSize is positive.
+func Decompress(data []byte, maxSize int) ([]byte, error) {
+	for compressor, magic := range magicBytes {
+		if bytes.HasPrefix(data, magic) {
+			decompressed, err := compressor.Decompress(data, maxSize)
+			if err != nil {
+				return nil, fmt.Errorf("Decompression error: %s", err)
+			}
+			return decompressed, nil
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,50 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+)
+
+// gzipCompressor compresses with gzip
+type gzipCompressor struct{}
+
+// ContentEncoding ...
+func (gzipCompressor) ContentEncoding() string {
+	return "gzip"
+}
+
+// Compress ...
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress ...
+func (gzipCompressor) Decompress(data []byte, maxSize int) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	if maxSize <= 0 {
+		return io.ReadAll(reader)
+	}
+
+	// Read one byte more than allowed to tell whether the data exceeds the maximum size
+	decompressed, err := io.ReadAll(io.LimitReader(reader, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(decompressed) > maxSize {
+		return nil, ErrMaxSizeExceeded
+	}
+	return decompressed, nil
+}
@@ -0,0 +1,63 @@
+package compression
+
+import (
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	// Encoders and decoders are safe for concurrent use of EncodeAll and DecodeAll
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+	// zstdDecoders are the decoders limited to a maximum decompressed size by that size
+	zstdDecoders sync.Map
+)
+
+// zstdCompressor compresses with Zstandard
+type zstdCompressor struct{}
+
+// ContentEncoding ...
+func (zstdCompressor) ContentEncoding() string {
+	return "zstd"
+}
+
+// Compress ...
+func (zstdCompressor) Compress(data []byte) ([]byte, error) {
+	return zstdEncoder.EncodeAll(data, nil), nil
+}
+
+// Decompress ...
+func (zstdCompressor) Decompress(data []byte, maxSize int) ([]byte, error) {
+	decoder, err := zstdDecoderFor(maxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	decompressed, err := decoder.DecodeAll(data, nil)
+	if err == zstd.ErrDecoderSizeExceeded {
+		return nil, ErrMaxSizeExceeded
+	}
+	return decompressed, err
+}
+
+// zstdDecoderFor returns the decoder limited to the maximum decompressed size, the
+// unlimited one if maxSize is not positive
+func zstdDecoderFor(maxSize int) (*zstd.Decoder, error) {
+	if maxSize <= 0 {
+		return zstdDecoder, nil
+	}
+	if decoder, ok := zstdDecoders.Load(maxSize); ok {
+		return decoder.(*zstd.Decoder), nil
+	}
+
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(maxSize)))
+	if err != nil {
+		return nil, err
+	}
+	actual, loaded := zstdDecoders.LoadOrStore(maxSize, decoder)
+	if loaded {
+		decoder.Close()
+	}
+	return actual.(*zstd.Decoder), nil
+}
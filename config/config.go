@@ -8,13 +8,22 @@ import (
 
 	"cloud.google.com/go/pubsub"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"go.mongodb.org/mongo-driver/mongo"
+
+	blobsiface "github.com/oarkflow/machinery/blobs/iface"
 )
 
 const (
 	// DefaultResultsExpireIn is a default time used to expire task states and group metadata from the backend
 	DefaultResultsExpireIn = 3600
+	// DefaultCompressionThreshold is a default size in bytes above which messages and task results are compressed
+	DefaultCompressionThreshold = 1024
+	// DefaultMaxDecompressedSize is a default maximum size in bytes of decompressed messages and task results
+	DefaultMaxDecompressedSize = 64 << 20
+	// DefaultClaimCheckThreshold is a default size in bytes above which task arguments and results are kept in the blob store
+	DefaultClaimCheckThreshold = 65536
 )
 
 var (
@@ -31,8 +40,9 @@ var (
 			PrefetchCount: 3,
 		},
 		DynamoDB: &DynamoDBConfig{
-			TaskStatesTable: "task_states",
-			GroupMetasTable: "group_metas",
+			TaskStatesTable:    "task_states",
+			GroupMetasTable:    "group_metas",
+			WorkflowMetasTable: "workflow_metas",
 		},
 		Redis: &RedisConfig{
 			MaxIdle:                3,
@@ -68,6 +78,53 @@ type Config struct {
 	// NoUnixSignals - when set disables signal handling in machinery
 	NoUnixSignals bool            `yaml:"no_unix_signals" envconfig:"NO_UNIX_SIGNALS"`
 	DynamoDB      *DynamoDBConfig `yaml:"dynamodb"`
+	// RevokePollPeriod specifies the period in milliseconds when polling the result
+	// backend for revocation of running tasks
+	// Default: 1000
+	RevokePollPeriod int `yaml:"revoke_poll_period" envconfig:"REVOKE_POLL_PERIOD"`
+	// DeadLetterQueue specifies the queue which receives tasks which failed for good
+	// and messages which could not be unmarshaled. When empty, they are dropped.
+	DeadLetterQueue string `yaml:"dead_letter_queue" envconfig:"DEAD_LETTER_QUEUE"`
+	// RateLimiter specifies where the token buckets of rate limited tasks are kept,
+	// either redis:// (or sentinel://) shared by all workers or eager:// in memory.
+	// Default: eager://
+	RateLimiter string `yaml:"rate_limiter" envconfig:"RATE_LIMITER"`
+	// Codec specifies how task messages are encoded, either json, msgpack or cbor.
+	// Consumers decode messages of any codec, so it can be changed on a live queue.
+	// Default: json
+	Codec string `yaml:"codec" envconfig:"CODEC"`
+	// Compression specifies how messages and task results larger than CompressionThreshold
+	// are compressed, either gzip or zstd. When empty, nothing is compressed.
+	Compression string `yaml:"compression" envconfig:"COMPRESSION"`
+	// CompressionThreshold specifies the size in bytes above which messages and task results are compressed
+	// Default: 1024
+	CompressionThreshold int `yaml:"compression_threshold" envconfig:"COMPRESSION_THRESHOLD"`
+	// MaxDecompressedSize specifies the maximum size in bytes a compressed message or task result
+	// may decompress to, larger ones are rejected
+	// Default: 67108864
+	MaxDecompressedSize int `yaml:"max_decompressed_size" envconfig:"MAX_DECOMPRESSED_SIZE"`
+	// Security signs and encrypts task messages and results
+	Security *SecurityConfig `yaml:"security"`
+	// ClaimCheck keeps task arguments and results larger than its threshold in a blob store
+	// and passes references to them in messages and the result backend instead
+	ClaimCheck *ClaimCheckConfig `yaml:"claim_check"`
+}
+
+// ClaimCheckConfig wraps the configuration of the blob store keeping oversized task arguments and results
+type ClaimCheckConfig struct {
+	// URL specifies the blob store, either file:///path/to/dir, s3://bucket/prefix or eager://
+	// which keeps blobs in memory. Arguments are deleted once their task completes, results
+	// expire after ResultsExpireIn. S3 removes expired results only with a lifecycle rule.
+	URL string `yaml:"url" envconfig:"CLAIM_CHECK_URL"`
+	// Threshold specifies the size in bytes above which task arguments and results are kept in the blob store
+	// Default: 65536
+	Threshold int `yaml:"threshold" envconfig:"CLAIM_CHECK_THRESHOLD"`
+	// S3Endpoint specifies the endpoint of an S3 compatible store such as MinIO, buckets are
+	// addressed path-style then. When empty, AWS S3 is used.
+	S3Endpoint string `yaml:"s3_endpoint" envconfig:"CLAIM_CHECK_S3_ENDPOINT"`
+	S3Client   *s3.S3 `yaml:"-" ignored:"true"`
+	// Store is used instead of the blob store of the URL when set
+	Store blobsiface.Store `yaml:"-" ignored:"true"`
 }
 
 // QueueBindingArgs arguments which are used when binding to the exchange
@@ -93,6 +150,10 @@ type DynamoDBConfig struct {
 	Client          *dynamodb.DynamoDB
 	TaskStatesTable string `yaml:"task_states_table" envconfig:"TASK_STATES_TABLE"`
 	GroupMetasTable string `yaml:"group_metas_table" envconfig:"GROUP_METAS_TABLE"`
+	// WorkflowMetasTable keeps the definitions of workflows, keyed by the WorkflowUUID string
+	// attribute. It is only required to send workflows.
+	// Default: workflow_metas
+	WorkflowMetasTable string `yaml:"workflow_metas_table" envconfig:"WORKFLOW_METAS_TABLE"`
 }
 
 // SQSConfig wraps SQS related configuration
@@ -150,6 +211,62 @@ type RedisConfig struct {
 
 	// MasterName specifies a redis master name in order to configure a sentinel-backed redis FailoverClient
 	MasterName string `yaml:"master_name" envconfig:"REDIS_MASTER_NAME"`
+
+	// ReliableQueue enables at-least-once delivery. Messages are moved into a per-consumer
+	// processing list (BLMOVE, requires Redis 6.2+) and only removed once the task has been processed.
+	// Default: false
+	ReliableQueue bool `yaml:"reliable_queue" envconfig:"REDIS_RELIABLE_QUEUE"`
+
+	// VisibilityTimeout specifies the period in seconds after which a consumer which stopped
+	// sending heartbeats is considered dead and its in-flight messages are requeued.
+	// The Redis Streams broker claims pending messages which have been idle for this long.
+	// Default: 300
+	VisibilityTimeout int `yaml:"visibility_timeout" envconfig:"REDIS_VISIBILITY_TIMEOUT"`
+
+	// ConsumerGroup specifies the consumer group used by the Redis Streams broker
+	// Default: machinery_group
+	ConsumerGroup string `yaml:"consumer_group" envconfig:"REDIS_CONSUMER_GROUP"`
+
+	// StreamMaxLen caps the length of a stream (approximately) when publishing with the
+	// Redis Streams broker. When zero, streams are not trimmed.
+	StreamMaxLen int64 `yaml:"stream_max_len" envconfig:"REDIS_STREAM_MAX_LEN"`
+
+	// StreamMaxDeliveries caps how often the Redis Streams broker delivers a message. A message
+	// left pending after that many deliveries is moved to the dead-letter queue (dropped when
+	// none is configured) instead of being claimed again.
+	// Default: 5
+	StreamMaxDeliveries int64 `yaml:"stream_max_deliveries" envconfig:"REDIS_STREAM_MAX_DELIVERIES"`
+
+	// PriorityLevels enables priority queues when greater than 1. Each level is a separate list
+	// and a message goes to the level of its Signature.Priority (capped at the highest level).
+	// Workers always take messages from higher levels first.
+	// Default: 1 (disabled)
+	PriorityLevels int `yaml:"priority_levels" envconfig:"REDIS_PRIORITY_LEVELS"`
+
+	// PriorityStarvationLimit protects low priority messages from starvation. After this many
+	// consecutive messages taken from above the lowest level, lower levels are checked first once.
+	// When zero, strict priority is used.
+	PriorityStarvationLimit int `yaml:"priority_starvation_limit" envconfig:"REDIS_PRIORITY_STARVATION_LIMIT"`
+}
+
+// SecurityConfig wraps signing and encryption of task messages and results
+type SecurityConfig struct {
+	// SigningAlgorithm is either hmac-sha256 or ed25519. When set, workers reject messages
+	// which are not signed with one of SigningKeys. When empty, messages are not signed.
+	SigningAlgorithm string `yaml:"signing_algorithm" envconfig:"SECURITY_SIGNING_ALGORITHM"`
+	// SigningKeyID selects the key of SigningKeys which signs published messages
+	SigningKeyID string `yaml:"signing_key_id" envconfig:"SECURITY_SIGNING_KEY_ID"`
+	// SigningKeys maps key IDs to base64 encoded keys, HMAC secrets or Ed25519 private keys.
+	// Workers which only verify messages can hold Ed25519 public keys instead, they cannot publish
+	// retries, callbacks or dead letters then. Messages signed with any of the keys are accepted,
+	// so keys can be rotated by adding a new one first.
+	SigningKeys map[string]string `yaml:"signing_keys" envconfig:"SECURITY_SIGNING_KEYS"`
+	// EncryptionKeyID selects the key of EncryptionKeys which encrypts task arguments and results.
+	// When empty, nothing is encrypted.
+	EncryptionKeyID string `yaml:"encryption_key_id" envconfig:"SECURITY_ENCRYPTION_KEY_ID"`
+	// EncryptionKeys maps key IDs to base64 encoded AES-128, AES-192 or AES-256 keys used with GCM.
+	// Arguments and results encrypted with any of the keys can be decrypted.
+	EncryptionKeys map[string]string `yaml:"encryption_keys" envconfig:"SECURITY_ENCRYPTION_KEYS"`
 }
 
 // GCPPubSubConfig wraps GCP PubSub related configuration
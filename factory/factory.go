@@ -0,0 +1,468 @@
+package factory
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	neturl "net/url"
+	"strconv"
+	"strings"
+
+	"github.com/oarkflow/machinery/codecs"
+	"github.com/oarkflow/machinery/compression"
+	"github.com/oarkflow/machinery/config"
+	"github.com/oarkflow/machinery/security"
+
+	amqpbroker "github.com/oarkflow/machinery/brokers/amqp"
+	eagerbroker "github.com/oarkflow/machinery/brokers/eager"
+	gcppubsubbroker "github.com/oarkflow/machinery/brokers/gcppubsub"
+	brokeriface "github.com/oarkflow/machinery/brokers/iface"
+	redisbroker "github.com/oarkflow/machinery/brokers/redis"
+	redisstreamsbroker "github.com/oarkflow/machinery/brokers/redisstreams"
+	sqsbroker "github.com/oarkflow/machinery/brokers/sqs"
+
+	amqpbackend "github.com/oarkflow/machinery/backends/amqp"
+	dynamobackend "github.com/oarkflow/machinery/backends/dynamodb"
+	eagerbackend "github.com/oarkflow/machinery/backends/eager"
+	backendiface "github.com/oarkflow/machinery/backends/iface"
+	memcachebackend "github.com/oarkflow/machinery/backends/memcache"
+	mongobackend "github.com/oarkflow/machinery/backends/mongo"
+	nullbackend "github.com/oarkflow/machinery/backends/null"
+	redisbackend "github.com/oarkflow/machinery/backends/redis"
+
+	eagerblobs "github.com/oarkflow/machinery/blobs/eager"
+	filesystemblobs "github.com/oarkflow/machinery/blobs/filesystem"
+	blobsiface "github.com/oarkflow/machinery/blobs/iface"
+	s3blobs "github.com/oarkflow/machinery/blobs/s3"
+
+	eagerlock "github.com/oarkflow/machinery/locks/eager"
+	lockiface "github.com/oarkflow/machinery/locks/iface"
+	redislock "github.com/oarkflow/machinery/locks/redis"
+
+	eagerratelimiter "github.com/oarkflow/machinery/ratelimiters/eager"
+	ratelimiteriface "github.com/oarkflow/machinery/ratelimiters/iface"
+	redisratelimiter "github.com/oarkflow/machinery/ratelimiters/redis"
+)
+
+const (
+	// DefaultLockRetries is a number of attempts made by a Redis lock created by LockFactory
+	DefaultLockRetries = 3
+)
+
+var (
+	// ErrMissingMasterName is returned when a sentinel URL is used without Redis.MasterName
+	ErrMissingMasterName = errors.New("Redis sentinel requires redis.master_name to be configured")
+	// ErrMissingRedisConfig is returned when several Redis addresses are used without Redis config
+	ErrMissingRedisConfig = errors.New("Multiple Redis addresses require redis config")
+)
+
+// BrokerFactory creates a new object of iface.Broker based on the scheme of the cnf.Broker URL.
+// Supported schemes are amqp://, amqps://, redis://, rediss://, redis+socket://,
+// sentinel://, redis+streams://, sqs:// (or https://sqs...), gcppubsub:// and eager://
+func BrokerFactory(cnf *config.Config) (brokeriface.Broker, error) {
+	if _, err := codecs.ForName(cnf.Codec); err != nil {
+		return nil, err
+	}
+	if _, err := compression.ForName(cnf.Compression); err != nil {
+		return nil, err
+	}
+	if _, err := security.ForConfig(cnf); err != nil {
+		return nil, err
+	}
+
+	brokerURL := firstURL(cnf.Broker, cnf.MultipleBrokerSeparator)
+
+	switch {
+	case strings.HasPrefix(brokerURL, "amqp://"), strings.HasPrefix(brokerURL, "amqps://"):
+		return amqpbroker.New(cnf), nil
+	case strings.HasPrefix(brokerURL, "redis://"), strings.HasPrefix(brokerURL, "rediss://"):
+		addrs, db, err := parseRedisAddrs(cnf.Broker, cnf.MultipleBrokerSeparator)
+		if err != nil {
+			return nil, err
+		}
+		cnf := withTLS(cnf, brokerURL)
+		if len(addrs) > 1 {
+			if cnf.Redis == nil {
+				return nil, ErrMissingRedisConfig
+			}
+			return redisbroker.NewGR(cnf, addrs, db), nil
+		}
+
+		redisHost, redisPassword, redisDB, err := ParseRedisURL(brokerURL)
+		if err != nil {
+			return nil, err
+		}
+		return redisbroker.New(cnf, redisHost, redisPassword, "", redisDB), nil
+	case strings.HasPrefix(brokerURL, "redis+socket://"):
+		redisSocket, redisPassword, redisDB, err := ParseRedisSocketURL(brokerURL)
+		if err != nil {
+			return nil, err
+		}
+		return redisbroker.New(cnf, "", redisPassword, redisSocket, redisDB), nil
+	case isSentinelURL(brokerURL):
+		addrs, db, err := parseSentinelAddrs(cnf, cnf.Broker, cnf.MultipleBrokerSeparator)
+		if err != nil {
+			return nil, err
+		}
+		return redisbroker.NewGR(cnf, addrs, db), nil
+	case strings.HasPrefix(brokerURL, "redis+streams://"):
+		addrs, db, err := parseRedisAddrs(cnf.Broker, cnf.MultipleBrokerSeparator)
+		if err != nil {
+			return nil, err
+		}
+		return redisstreamsbroker.New(cnf, addrs, db), nil
+	case strings.HasPrefix(brokerURL, "sqs://"):
+		// The SQS broker builds queue URLs from cnf.Broker, so hand it a copy
+		// of the config pointing at the HTTPS endpoint
+		sqsCnf := *cnf
+		sqsCnf.Broker = "https://" + strings.TrimSuffix(strings.TrimPrefix(brokerURL, "sqs://"), "/")
+		return sqsbroker.New(&sqsCnf), nil
+	case strings.HasPrefix(brokerURL, "https://sqs"):
+		return sqsbroker.New(cnf), nil
+	case strings.HasPrefix(brokerURL, "gcppubsub://"):
+		projectID, subscriptionName, err := ParseGCPPubSubURL(brokerURL)
+		if err != nil {
+			return nil, err
+		}
+		return gcppubsubbroker.New(cnf, projectID, subscriptionName)
+	case strings.HasPrefix(brokerURL, "eager"):
+		return eagerbroker.New(), nil
+	}
+
+	return nil, fmt.Errorf("Factory failed with broker URL: %v", cnf.Broker)
+}
+
+// BackendFactory creates a new object of iface.Backend based on the scheme of the cnf.ResultBackend URL.
+// Supported schemes are amqp://, amqps://, redis://, rediss://, redis+socket://,
+// sentinel://, mongodb://, mongodb+srv://, memcache://, dynamodb:// (or https://dynamodb...),
+// eager:// and null://
+func BackendFactory(cnf *config.Config) (backendiface.Backend, error) {
+	backendURL := cnf.ResultBackend
+
+	switch {
+	case strings.HasPrefix(backendURL, "amqp://"), strings.HasPrefix(backendURL, "amqps://"):
+		return amqpbackend.New(cnf), nil
+	case strings.HasPrefix(backendURL, "mongodb://"), strings.HasPrefix(backendURL, "mongodb+srv://"):
+		return mongobackend.New(cnf)
+	case strings.HasPrefix(backendURL, "redis://"), strings.HasPrefix(backendURL, "rediss://"):
+		addrs, db, err := parseRedisAddrs(backendURL, "")
+		if err != nil {
+			return nil, err
+		}
+		cnf := withTLS(cnf, backendURL)
+		if len(addrs) > 1 {
+			if cnf.Redis == nil {
+				return nil, ErrMissingRedisConfig
+			}
+			return redisbackend.NewGR(cnf, addrs, db), nil
+		}
+
+		redisHost, redisPassword, redisDB, err := ParseRedisURL(backendURL)
+		if err != nil {
+			return nil, err
+		}
+		return redisbackend.New(cnf, redisHost, redisPassword, "", redisDB), nil
+	case strings.HasPrefix(backendURL, "redis+socket://"):
+		redisSocket, redisPassword, redisDB, err := ParseRedisSocketURL(backendURL)
+		if err != nil {
+			return nil, err
+		}
+		return redisbackend.New(cnf, "", redisPassword, redisSocket, redisDB), nil
+	case isSentinelURL(backendURL):
+		addrs, db, err := parseSentinelAddrs(cnf, backendURL, "")
+		if err != nil {
+			return nil, err
+		}
+		return redisbackend.NewGR(cnf, addrs, db), nil
+	case strings.HasPrefix(backendURL, "memcache://"):
+		servers := strings.Split(strings.TrimPrefix(backendURL, "memcache://"), ",")
+		return memcachebackend.New(cnf, servers), nil
+	case strings.HasPrefix(backendURL, "dynamodb://"), strings.HasPrefix(backendURL, "https://dynamodb"):
+		return dynamobackend.New(cnf), nil
+	case strings.HasPrefix(backendURL, "eager"):
+		return eagerbackend.NewWithConfig(cnf), nil
+	case strings.HasPrefix(backendURL, "null"):
+		return nullbackend.New(), nil
+	}
+
+	return nil, fmt.Errorf("Factory failed with result backend: %v", cnf.ResultBackend)
+}
+
+// LockFactory creates a new object of iface.Lock based on the scheme of the cnf.Lock URL.
+// Supported schemes are redis://, rediss://, sentinel:// and eager://. An empty
+// cnf.Lock falls back to the in-process eager lock
+func LockFactory(cnf *config.Config) (lockiface.Lock, error) {
+	lockURL := cnf.Lock
+
+	switch {
+	case lockURL == "", strings.HasPrefix(lockURL, "eager"):
+		return eagerlock.New(), nil
+	case strings.HasPrefix(lockURL, "redis://"), strings.HasPrefix(lockURL, "rediss://"):
+		addrs, db, err := parseRedisAddrs(lockURL, "")
+		if err != nil {
+			return nil, err
+		}
+		return redislock.New(withTLS(cnf, lockURL), addrs, db, DefaultLockRetries), nil
+	case isSentinelURL(lockURL):
+		addrs, db, err := parseSentinelAddrs(cnf, lockURL, "")
+		if err != nil {
+			return nil, err
+		}
+		return redislock.New(cnf, addrs, db, DefaultLockRetries), nil
+	}
+
+	return nil, fmt.Errorf("Factory failed with lock URL: %v", cnf.Lock)
+}
+
+// RateLimiterFactory creates a new object of iface.RateLimiter based on the scheme of the
+// cnf.RateLimiter URL. Supported schemes are redis://, rediss://, sentinel:// and eager://.
+// An empty cnf.RateLimiter falls back to the in-memory eager rate limiter
+func RateLimiterFactory(cnf *config.Config) (ratelimiteriface.RateLimiter, error) {
+	rateLimiterURL := cnf.RateLimiter
+
+	switch {
+	case rateLimiterURL == "", strings.HasPrefix(rateLimiterURL, "eager"):
+		return eagerratelimiter.New(), nil
+	case strings.HasPrefix(rateLimiterURL, "redis://"), strings.HasPrefix(rateLimiterURL, "rediss://"):
+		addrs, db, err := parseRedisAddrs(rateLimiterURL, "")
+		if err != nil {
+			return nil, err
+		}
+		return redisratelimiter.New(withTLS(cnf, rateLimiterURL), addrs, db), nil
+	case isSentinelURL(rateLimiterURL):
+		addrs, db, err := parseSentinelAddrs(cnf, rateLimiterURL, "")
+		if err != nil {
+			return nil, err
+		}
+		return redisratelimiter.New(cnf, addrs, db), nil
+	}
+
+	return nil, fmt.Errorf("Factory failed with rate limiter URL: %v", cnf.RateLimiter)
+}
+
+// BlobStoreFactory creates a new object of iface.Store based on the scheme of the cnf.ClaimCheck.URL.
+// Supported schemes are file://, s3:// and eager://
+func BlobStoreFactory(cnf *config.Config) (blobsiface.Store, error) {
+	if cnf.ClaimCheck == nil {
+		return nil, errors.New("Claim check not configured")
+	}
+	blobStoreURL := cnf.ClaimCheck.URL
+
+	switch {
+	case strings.HasPrefix(blobStoreURL, "eager"):
+		return eagerblobs.New(), nil
+	case strings.HasPrefix(blobStoreURL, "file://"):
+		dir := strings.TrimPrefix(blobStoreURL, "file://")
+		if dir == "" {
+			return nil, fmt.Errorf("Blob store URL %s has no directory", blobStoreURL)
+		}
+		return filesystemblobs.New(dir), nil
+	case strings.HasPrefix(blobStoreURL, "s3://"):
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(blobStoreURL, "s3://"), "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("Blob store URL %s has no bucket", blobStoreURL)
+		}
+		return s3blobs.New(cnf, bucket, prefix), nil
+	}
+
+	return nil, fmt.Errorf("Factory failed with blob store URL: %v", blobStoreURL)
+}
+
+// ParseRedisURL extracts host, password and database from a redis://pwd@host/db URL
+func ParseRedisURL(url string) (host, password string, db int, err error) {
+	var u *neturl.URL
+	u, err = neturl.Parse(url)
+	if err != nil {
+		return
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		err = errors.New("No redis scheme found")
+		return
+	}
+
+	if u.User != nil {
+		var exists bool
+		password, exists = u.User.Password()
+		if !exists {
+			password = u.User.Username()
+		}
+	}
+
+	host = u.Host
+
+	parts := strings.Split(u.Path, "/")
+	if len(parts) > 1 && parts[1] != "" {
+		db, err = strconv.Atoi(parts[1])
+		if err != nil {
+			err = fmt.Errorf("Invalid redis database in %s: %s", url, err)
+			return
+		}
+	}
+
+	return
+}
+
+// ParseRedisSocketURL extracts Redis connection options from a URL with the
+// redis+socket://password@/path/to/file.sock:/db scheme
+func ParseRedisSocketURL(url string) (path, password string, db int, err error) {
+	parts := strings.Split(url, "redis+socket://")
+	if parts[0] != "" {
+		err = errors.New("No redis scheme found")
+		return
+	}
+
+	if len(parts) != 2 {
+		err = fmt.Errorf("Redis socket connection string should be in format redis+socket://password@/path/to/file.sock:/db, instead got %s", url)
+		return
+	}
+
+	remainder := parts[1]
+
+	// Extract password if any
+	parts = strings.SplitN(remainder, "@", 2)
+	if len(parts) == 2 {
+		password = parts[0]
+		remainder = parts[1]
+	} else {
+		remainder = parts[0]
+	}
+
+	// Extract path
+	parts = strings.SplitN(remainder, ":", 2)
+	path = parts[0]
+	if path == "" {
+		err = fmt.Errorf("Redis socket connection string should be in format redis+socket://password@/path/to/file.sock:/db, instead got %s", url)
+		return
+	}
+	if len(parts) == 2 {
+		remainder = parts[1]
+	}
+
+	// Extract DB if any
+	parts = strings.SplitN(remainder, "/", 2)
+	if len(parts) == 2 && parts[1] != "" {
+		db, err = strconv.Atoi(parts[1])
+		if err != nil {
+			err = fmt.Errorf("Invalid redis database in %s: %s", url, err)
+			return
+		}
+	}
+
+	return
+}
+
+// ParseGCPPubSubURL parses a gcppubsub://YOUR_GCP_PROJECT_ID/YOUR_PUBSUB_SUBSCRIPTION_NAME URL
+func ParseGCPPubSubURL(url string) (string, string, error) {
+	parts := strings.Split(url, "gcppubsub://")
+	if parts[0] != "" {
+		return "", "", errors.New("No gcppubsub scheme found")
+	}
+
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("gcppubsub scheme should be in format gcppubsub://YOUR_GCP_PROJECT_ID/YOUR_PUBSUB_SUBSCRIPTION_NAME, instead got %s", url)
+	}
+
+	remainder := parts[1]
+
+	parts = strings.Split(remainder, "/")
+	if len(parts) == 2 {
+		if len(parts[0]) == 0 {
+			return "", "", fmt.Errorf("gcppubsub scheme should be in format gcppubsub://YOUR_GCP_PROJECT_ID/YOUR_PUBSUB_SUBSCRIPTION_NAME, instead got %s", url)
+		}
+		if len(parts[1]) == 0 {
+			return "", "", fmt.Errorf("gcppubsub scheme should be in format gcppubsub://YOUR_GCP_PROJECT_ID/YOUR_PUBSUB_SUBSCRIPTION_NAME, instead got %s", url)
+		}
+		return parts[0], parts[1], nil
+	}
+
+	return "", "", fmt.Errorf("gcppubsub scheme should be in format gcppubsub://YOUR_GCP_PROJECT_ID/YOUR_PUBSUB_SUBSCRIPTION_NAME, instead got %s", url)
+}
+
+// firstURL returns the first URL of a list joined by separator
+func firstURL(urls, separator string) string {
+	if separator == "" {
+		return urls
+	}
+	return strings.Split(urls, separator)[0]
+}
+
+// isSentinelURL returns true for sentinel:// and redis+sentinel:// URLs
+func isSentinelURL(url string) bool {
+	return strings.HasPrefix(url, "sentinel://") || strings.HasPrefix(url, "redis+sentinel://")
+}
+
+// withTLS makes sure a rediss:// URL is dialed over TLS. The config is returned as is unless
+// it lacks a TLS config, then a copy of it with the default TLS config is returned.
+func withTLS(cnf *config.Config, url string) *config.Config {
+	if !strings.HasPrefix(url, "rediss://") || cnf.TLSConfig != nil {
+		return cnf
+	}
+
+	tlsCnf := *cnf
+	tlsCnf.TLSConfig = &tls.Config{}
+	return &tlsCnf
+}
+
+// parseRedisAddrs turns redis://pwd@host1:port,host2:port/db (optionally with every
+// address carrying its own scheme and joined by separator) into the "pwd@host1:port",
+// "host2:port" form expected by the go-redis based constructors
+func parseRedisAddrs(urls, separator string) ([]string, int, error) {
+	if separator == "" {
+		separator = ","
+	}
+
+	var (
+		addrs []string
+		db    int
+	)
+	for _, url := range strings.Split(urls, separator) {
+		url = strings.TrimSpace(url)
+		for _, scheme := range []string{"redis://", "rediss://", "sentinel://", "redis+sentinel://", "redis+streams://"} {
+			url = strings.TrimPrefix(url, scheme)
+		}
+
+		// the database may only be given once, after the last address
+		if i := strings.Index(url, "/"); i >= 0 {
+			if dbPart := strings.Trim(url[i:], "/"); dbPart != "" {
+				var err error
+				db, err = strconv.Atoi(dbPart)
+				if err != nil {
+					return nil, 0, fmt.Errorf("Invalid redis database in %s: %s", urls, err)
+				}
+			}
+			url = url[:i]
+		}
+
+		for _, addr := range strings.Split(url, ",") {
+			if addr != "" {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+
+	if len(addrs) == 0 {
+		return nil, 0, fmt.Errorf("Redis connection string should be in format redis://password@host:port/db, instead got %s", urls)
+	}
+
+	// NewGR expects the password as "password@host:port", drop the user part
+	// of a "user:password@host:port" user info
+	if i := strings.LastIndex(addrs[0], "@"); i >= 0 {
+		userInfo := addrs[0][:i]
+		if j := strings.Index(userInfo, ":"); j >= 0 {
+			userInfo = userInfo[j+1:]
+		}
+		addrs[0] = userInfo + "@" + addrs[0][i+1:]
+	}
+
+	return addrs, db, nil
+}
+
+// parseSentinelAddrs parses a sentinel://pwd@host1:port,host2:port/db URL and
+// makes sure the master name needed by the failover client is configured
+func parseSentinelAddrs(cnf *config.Config, urls, separator string) ([]string, int, error) {
+	if cnf.Redis == nil || cnf.Redis.MasterName == "" {
+		return nil, 0, ErrMissingMasterName
+	}
+
+	return parseRedisAddrs(urls, separator)
+}
@@ -17,10 +17,19 @@ type Lock struct {
 		sync.RWMutex
 		m map[string]int64
 	}
+	owned struct {
+		sync.Mutex
+		m map[string]ownedLock
+	}
+}
+
+type ownedLock struct {
+	owner   string
+	timeout int64
 }
 
 func New() *Lock {
-	return &Lock{
+	lock := &Lock{
 		retries:  3,
 		interval: 5 * time.Second,
 		register: struct {
@@ -28,6 +37,8 @@ func New() *Lock {
 			m map[string]int64
 		}{m: make(map[string]int64)},
 	}
+	lock.owned.m = make(map[string]ownedLock)
+	return lock
 }
 
 func (e *Lock) LockWithRetries(key string, value int64) error {
@@ -53,3 +64,23 @@ func (e *Lock) Lock(key string, value int64) error {
 	}
 	return ErrEagerLockFailed
 }
+
+func (e *Lock) LockOwned(key, owner string, value int64) (bool, string, error) {
+	e.owned.Lock()
+	defer e.owned.Unlock()
+	current, exist := e.owned.m[key]
+	if !exist || time.Now().UnixNano() > current.timeout || current.owner == owner {
+		e.owned.m[key] = ownedLock{owner: owner, timeout: value}
+		return true, owner, nil
+	}
+	return false, current.owner, nil
+}
+
+func (e *Lock) UnlockOwned(key, owner string) error {
+	e.owned.Lock()
+	defer e.owned.Unlock()
+	if current, exist := e.owned.m[key]; exist && current.owner == owner {
+		delete(e.owned.m, key)
+	}
+	return nil
+}
@@ -11,3 +11,17 @@ type Lock interface {
 	//value: at the nanosecond timestamp that lock needs to be released automatically
 	Lock(key string, value int64) error
 }
+
+// OwnedLock - a lock which records its owner so that only the owner releases it
+type OwnedLock interface {
+	//Acquire the lock for the owner
+	//key: the name of the lock,
+	//owner: the identifier of the holder of the lock,
+	//value: at the nanosecond timestamp that lock needs to be released automatically
+	//A lock already held by the owner is extended to the new timestamp.
+	//It returns false and the current owner when the lock is held by another owner
+	LockOwned(key, owner string, value int64) (bool, string, error)
+
+	//Release the lock if it is held by the owner
+	UnlockOwned(key, owner string) error
+}
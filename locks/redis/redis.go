@@ -1,13 +1,14 @@
 package redis
 
 import (
+	"context"
 	"errors"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 
+	"github.com/oarkflow/machinery/common"
 	"github.com/oarkflow/machinery/config"
 )
 
@@ -15,38 +16,45 @@ var (
 	ErrRedisLockFailed = errors.New("redis lock: failed to acquire lock")
 )
 
+// unlockOwnedScript deletes the lock only if it is still held by the owner
+var unlockOwnedScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// lockOwnedScript takes the lock for the owner or extends it if the owner already holds it,
+// it returns the owner of the lock
+var lockOwnedScript = redis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+if not current then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return ARGV[1]
+end
+if current == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return current
+`)
+
 type Lock struct {
 	rclient  redis.UniversalClient
 	retries  int
 	interval time.Duration
 }
 
+// New creates a Lock connected with the Redis settings of the config. LockWithRetries makes
+// retries attempts after the first one, the other methods do not depend on retries.
 func New(cnf *config.Config, addrs []string, db, retries int) Lock {
-	if retries <= 0 {
-		return Lock{}
-	}
-	lock := Lock{retries: retries}
-
-	var password string
-
-	parts := strings.Split(addrs[0], "@")
-	if len(parts) >= 2 {
-		password = strings.Join(parts[:len(parts)-1], "@")
-		addrs[0] = parts[len(parts)-1] // addr is the last one without @
+	if retries < 0 {
+		retries = 0
 	}
 
-	ropt := &redis.UniversalOptions{
-		Addrs:    addrs,
-		DB:       db,
-		Password: password,
+	return Lock{
+		rclient: common.NewUniversalClient(cnf, addrs, db),
+		retries: retries,
 	}
-	if cnf.Redis != nil {
-		ropt.MasterName = cnf.Redis.MasterName
-	}
-
-	lock.rclient = redis.NewUniversalClient(ropt)
-
-	return lock
 }
 
 func (r Lock) LockWithRetries(key string, unixTsToExpireNs int64) error {
@@ -65,7 +73,7 @@ func (r Lock) LockWithRetries(key string, unixTsToExpireNs int64) error {
 func (r Lock) Lock(key string, unixTsToExpireNs int64) error {
 	now := time.Now().UnixNano()
 	expiration := time.Duration(unixTsToExpireNs + 1 - now)
-	ctx := r.rclient.Context()
+	ctx := context.Background()
 
 	success, err := r.rclient.SetNX(ctx, key, unixTsToExpireNs, expiration).Result()
 	if err != nil {
@@ -108,3 +116,21 @@ func (r Lock) Lock(key string, unixTsToExpireNs int64) error {
 
 	return nil
 }
+
+func (r Lock) LockOwned(key, owner string, unixTsToExpireNs int64) (bool, string, error) {
+	expiration := time.Duration(unixTsToExpireNs - time.Now().UnixNano())
+	if expiration < time.Millisecond {
+		// a zero expiration would keep the lock forever
+		expiration = time.Millisecond
+	}
+
+	current, err := lockOwnedScript.Run(context.Background(), r.rclient, []string{key}, owner, expiration.Milliseconds()).Text()
+	if err != nil {
+		return false, "", err
+	}
+	return current == owner, current, nil
+}
+
+func (r Lock) UnlockOwned(key, owner string) error {
+	return unlockOwnedScript.Run(context.Background(), r.rclient, []string{key}, owner).Err()
+}
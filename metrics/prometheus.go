@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultNamespace prefixes the names of all metrics of the Collector
+const DefaultNamespace = "machinery"
+
+// Collector is a Recorder exposing measurements as Prometheus metrics.
+// Register it with a prometheus.Registerer of the application.
+type Collector struct {
+	received      *prometheus.CounterVec
+	succeeded     *prometheus.CounterVec
+	failed        *prometheus.CounterVec
+	retried       *prometheus.CounterVec
+	duration      *prometheus.HistogramVec
+	queueWait     *prometheus.HistogramVec
+	inFlight      *prometheus.GaugeVec
+	brokerErrors  *prometheus.CounterVec
+	backendErrors *prometheus.CounterVec
+}
+
+// NewCollector creates new Collector instance, an empty namespace defaults to DefaultNamespace
+func NewCollector(namespace string) *Collector {
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+
+	taskLabels := []string{"task", "queue"}
+	buckets := prometheus.ExponentialBuckets(0.005, 2, 16) // 5ms up to ~2.7 minutes
+
+	return &Collector{
+		received: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tasks_received_total",
+			Help:      "Number of tasks received by workers.",
+		}, taskLabels),
+		succeeded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tasks_succeeded_total",
+			Help:      "Number of tasks which succeeded.",
+		}, taskLabels),
+		failed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tasks_failed_total",
+			Help:      "Number of tasks which failed for good.",
+		}, taskLabels),
+		retried: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tasks_retried_total",
+			Help:      "Number of task retries.",
+		}, taskLabels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "task_duration_seconds",
+			Help:      "Execution time of tasks.",
+			Buckets:   buckets,
+		}, taskLabels),
+		queueWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "task_queue_wait_seconds",
+			Help:      "Time tasks spent in the queue before being received by a worker.",
+			Buckets:   buckets,
+		}, taskLabels),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "tasks_in_flight",
+			Help:      "Number of tasks being processed by a worker.",
+		}, []string{"worker"}),
+		brokerErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "broker_errors_total",
+			Help:      "Number of failed broker operations.",
+		}, []string{"operation"}),
+		backendErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "backend_errors_total",
+			Help:      "Number of failed result backend operations.",
+		}, []string{"operation"}),
+	}
+}
+
+// collectors returns all metrics of the collector
+func (c *Collector) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		c.received,
+		c.succeeded,
+		c.failed,
+		c.retried,
+		c.duration,
+		c.queueWait,
+		c.inFlight,
+		c.brokerErrors,
+		c.backendErrors,
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range c.collectors() {
+		collector.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, collector := range c.collectors() {
+		collector.Collect(ch)
+	}
+}
+
+// TaskReceived increments the received tasks counter
+func (c *Collector) TaskReceived(taskName, queue string) {
+	c.received.WithLabelValues(taskName, queue).Inc()
+}
+
+// TaskSucceeded increments the succeeded tasks counter
+func (c *Collector) TaskSucceeded(taskName, queue string) {
+	c.succeeded.WithLabelValues(taskName, queue).Inc()
+}
+
+// TaskFailed increments the failed tasks counter
+func (c *Collector) TaskFailed(taskName, queue string) {
+	c.failed.WithLabelValues(taskName, queue).Inc()
+}
+
+// TaskRetried increments the task retries counter
+func (c *Collector) TaskRetried(taskName, queue string) {
+	c.retried.WithLabelValues(taskName, queue).Inc()
+}
+
+// TaskDuration observes execution time of a task
+func (c *Collector) TaskDuration(taskName, queue string, duration time.Duration) {
+	c.duration.WithLabelValues(taskName, queue).Observe(duration.Seconds())
+}
+
+// QueueWait observes the time a task spent in the queue
+func (c *Collector) QueueWait(taskName, queue string, wait time.Duration) {
+	c.queueWait.WithLabelValues(taskName, queue).Observe(wait.Seconds())
+}
+
+// InFlight adjusts the in-flight tasks gauge of a worker
+func (c *Collector) InFlight(worker string, delta int) {
+	c.inFlight.WithLabelValues(worker).Add(float64(delta))
+}
+
+// BrokerError increments the broker errors counter
+func (c *Collector) BrokerError(operation string) {
+	c.brokerErrors.WithLabelValues(operation).Inc()
+}
+
+// BackendError increments the result backend errors counter
+func (c *Collector) BackendError(operation string) {
+	c.backendErrors.WithLabelValues(operation).Inc()
+}
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"time"
+)
+
+// Recorder receives measurements from workers, brokers and result backends
+type Recorder interface {
+	TaskReceived(taskName, queue string)
+	TaskSucceeded(taskName, queue string)
+	TaskFailed(taskName, queue string)
+	TaskRetried(taskName, queue string)
+	TaskDuration(taskName, queue string, duration time.Duration)
+	// QueueWait records how long a task waited in the queue before a worker received it
+	QueueWait(taskName, queue string, wait time.Duration)
+	// InFlight adds delta to the number of tasks being processed by a worker
+	InFlight(worker string, delta int)
+	BrokerError(operation string)
+	BackendError(operation string)
+}
+
+// Nop is a Recorder which discards all measurements
+type Nop struct{}
+
+// TaskReceived does nothing
+func (Nop) TaskReceived(taskName, queue string) {}
+
+// TaskSucceeded does nothing
+func (Nop) TaskSucceeded(taskName, queue string) {}
+
+// TaskFailed does nothing
+func (Nop) TaskFailed(taskName, queue string) {}
+
+// TaskRetried does nothing
+func (Nop) TaskRetried(taskName, queue string) {}
+
+// TaskDuration does nothing
+func (Nop) TaskDuration(taskName, queue string, duration time.Duration) {}
+
+// QueueWait does nothing
+func (Nop) QueueWait(taskName, queue string, wait time.Duration) {}
+
+// InFlight does nothing
+func (Nop) InFlight(worker string, delta int) {}
+
+// BrokerError does nothing
+func (Nop) BrokerError(operation string) {}
+
+// BackendError does nothing
+func (Nop) BackendError(operation string) {}
@@ -0,0 +1,81 @@
+package machinery
+
+import (
+	"context"
+	"time"
+
+	"github.com/oarkflow/machinery/claimcheck"
+	"github.com/oarkflow/machinery/tasks"
+)
+
+// Handler executes a task on the worker and returns its results
+type Handler func(ctx context.Context, signature *tasks.Signature) ([]*tasks.TaskResult, error)
+
+// Middleware wraps task execution, see Worker.Use
+type Middleware func(next Handler) Handler
+
+// PublishHandler publishes a task to the broker
+type PublishHandler func(ctx context.Context, signature *tasks.Signature) error
+
+// PublishMiddleware wraps publishing of tasks, see Server.UsePublish
+type PublishMiddleware func(next PublishHandler) PublishHandler
+
+// Use appends middlewares wrapping execution of every task by the worker. The first
+// middleware is the outermost one. A middleware can change the context passed on to
+// the task, inspect or replace its results and error, or return an error without
+// calling next to fail the task. It should be set before the worker is launched.
+func (worker *Worker) Use(middlewares ...Middleware) {
+	worker.middlewares = append(worker.middlewares, middlewares...)
+}
+
+// UsePublish appends middlewares wrapping every publish to the broker. The first
+// middleware is the outermost one. It should be set before any task is sent.
+func (server *Server) UsePublish(middlewares ...PublishMiddleware) {
+	server.publishMiddlewares = append(server.publishMiddlewares, middlewares...)
+}
+
+// wrapHandler applies the worker middlewares to the handler
+func (worker *Worker) wrapHandler(handler Handler) Handler {
+	for i := len(worker.middlewares) - 1; i >= 0; i-- {
+		handler = worker.middlewares[i](handler)
+	}
+	return handler
+}
+
+// publish places the task on the broker through the publish middlewares
+func (server *Server) publish(ctx context.Context, signature *tasks.Signature) error {
+	handler := PublishHandler(func(ctx context.Context, signature *tasks.Signature) error {
+		// Timestamp the message so that workers can measure the time it waits in the queue,
+		// deferred messages keep their timestamp. Headers are copied as signatures of a group
+		// are published concurrently and might share them
+		headers := make(tasks.Headers, len(signature.Headers)+1)
+		for key, value := range signature.Headers {
+			headers[key] = value
+		}
+		_, deferred := headers[tasks.DeferralsHeader]
+		if _, ok := headers[tasks.PublishedAtHeader]; !ok || !deferred {
+			headers[tasks.PublishedAtHeader] = time.Now().UTC().Format(time.RFC3339Nano)
+		}
+		signature.Headers = headers
+
+		// Keep oversized arguments in the blob store and publish references to them instead
+		claimCheck, err := claimcheck.ForConfig(server.config)
+		if err != nil {
+			return err
+		}
+		checked, err := claimCheck.CheckSignature(ctx, signature)
+		if err != nil {
+			return err
+		}
+
+		if err := server.broker.Publish(ctx, checked); err != nil {
+			server.metrics.BrokerError("publish")
+			return err
+		}
+		return nil
+	})
+	for i := len(server.publishMiddlewares) - 1; i >= 0; i-- {
+		handler = server.publishMiddlewares[i](handler)
+	}
+	return handler(ctx, signature)
+}
@@ -0,0 +1,114 @@
+package machinery
+
+import (
+	"sync"
+)
+
+// QueueStrategy decides which queue a multi-queue worker takes the next message from
+type QueueStrategy int
+
+const (
+	// StrictPriority always takes a message from the first non-empty queue in the order given
+	StrictPriority QueueStrategy = iota
+	// WeightedRoundRobin spreads messages among queues proportionally to their weights.
+	// A queue with no message does not hold up the other queues.
+	WeightedRoundRobin
+)
+
+// WeightedQueue is a queue consumed by a multi-queue worker
+type WeightedQueue struct {
+	Name string
+	// Weight is the share of messages taken from the queue with WeightedRoundRobin, at least 1
+	Weight int
+}
+
+// queueSelector orders the queues of a multi-queue worker using smooth weighted round-robin
+type queueSelector struct {
+	strategy QueueStrategy
+	queues   []WeightedQueue
+	mu       sync.Mutex
+	current  []int
+}
+
+// newQueueSelector creates new queueSelector instance
+func newQueueSelector(strategy QueueStrategy, queues []WeightedQueue) *queueSelector {
+	selector := &queueSelector{
+		strategy: strategy,
+		queues:   make([]WeightedQueue, len(queues)),
+		current:  make([]int, len(queues)),
+	}
+	for i, queue := range queues {
+		if queue.Weight < 1 {
+			queue.Weight = 1
+		}
+		selector.queues[i] = queue
+	}
+	return selector
+}
+
+// names returns the names of the queues in the order given
+func (s *queueSelector) names() []string {
+	names := make([]string, len(s.queues))
+	for i, queue := range s.queues {
+		names[i] = queue.Name
+	}
+	return names
+}
+
+// next returns the queues in the order they should be checked for the next message.
+// With WeightedRoundRobin, the queue whose turn it is comes first followed by the others
+// in the order given, so that an empty queue passes its turn on.
+func (s *queueSelector) next() []string {
+	names := s.names()
+	if s.strategy != WeightedRoundRobin || len(names) < 2 {
+		return names
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total, selected := 0, 0
+	for i, queue := range s.queues {
+		s.current[i] += queue.Weight
+		total += queue.Weight
+		if s.current[i] > s.current[selected] {
+			selected = i
+		}
+	}
+	s.current[selected] -= total
+
+	ordered := make([]string, 0, len(names))
+	ordered = append(ordered, names[selected])
+	for i, name := range names {
+		if i != selected {
+			ordered = append(ordered, name)
+		}
+	}
+	return ordered
+}
+
+// NewMultiQueueWorker creates Worker instance consuming several queues. Concurrency is shared
+// by all the queues and the strategy decides which queue the next message is taken from.
+// The first queue is the one reported by CustomQueue. It requires a broker implementing
+// MultiQueueBroker, currently the Redis brokers.
+func (server *Server) NewMultiQueueWorker(consumerTag string, concurrency int, strategy QueueStrategy, queues ...WeightedQueue) *Worker {
+	worker := server.NewWorker(consumerTag, concurrency)
+	if len(queues) > 0 {
+		worker.Queue = queues[0].Name
+		worker.queues = newQueueSelector(strategy, queues)
+	}
+	return worker
+}
+
+// NextQueues returns the queues of a multi-queue worker in the order they should be checked
+// for the next message, or just the queue of the worker
+func (worker *Worker) NextQueues() []string {
+	if worker.queues == nil {
+		queue := worker.Queue
+		if queue == "" {
+			queue = worker.server.GetConfig().DefaultQueue
+		}
+		return []string{queue}
+	}
+	return worker.queues.next()
+}
@@ -0,0 +1,61 @@
+package eager
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/oarkflow/machinery/ratelimiters/iface"
+)
+
+// RateLimiter is an in-memory token bucket rate limiter, limits are not shared between processes
+type RateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// New creates new RateLimiter instance
+func New() *RateLimiter {
+	return &RateLimiter{buckets: make(map[string]*bucket)}
+}
+
+// Allow takes a token from the bucket of the key
+func (r *RateLimiter) Allow(key string, limit iface.Limit) (bool, time.Duration, error) {
+	if limit.IsZero() {
+		return true, 0, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	capacity := float64(limit.Capacity())
+	interval := limit.Interval()
+
+	b, ok := r.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, updatedAt: now}
+		r.buckets[key] = b
+	}
+
+	// Refill the tokens for the time elapsed since the last call
+	if elapsed := now.Sub(b.updatedAt); elapsed > 0 {
+		b.tokens += float64(elapsed) / interval
+		if b.tokens > capacity {
+			b.tokens = capacity
+		}
+		b.updatedAt = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+
+	return false, time.Duration(math.Ceil((1 - b.tokens) * interval)), nil
+}
@@ -0,0 +1,39 @@
+package iface
+
+import (
+	"time"
+)
+
+// Limit allows Rate executions per Period, with bursts of up to Burst executions
+type Limit struct {
+	Rate   int
+	Period time.Duration
+	// Burst is the capacity of the token bucket, Rate when zero
+	Burst int
+}
+
+// IsZero returns true if no limit is set
+func (l Limit) IsZero() bool {
+	return l.Rate <= 0 || l.Period <= 0
+}
+
+// Capacity returns the number of tokens of a full bucket
+func (l Limit) Capacity() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Rate
+}
+
+// Interval returns the time in nanoseconds it takes to refill one token. It is a float
+// so that it is neither truncated nor zero when Rate does not divide Period.
+func (l Limit) Interval() float64 {
+	return float64(l.Period) / float64(l.Rate)
+}
+
+// RateLimiter - a token bucket rate limiter
+type RateLimiter interface {
+	// Allow takes a token from the bucket of the key. When the bucket is empty,
+	// it returns false and the time until the next token is available
+	Allow(key string, limit Limit) (bool, time.Duration, error)
+}
@@ -0,0 +1,80 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/oarkflow/machinery/common"
+	"github.com/oarkflow/machinery/config"
+	"github.com/oarkflow/machinery/ratelimiters/iface"
+)
+
+// tokenBucketScript refills the bucket for the time elapsed since the last call and takes
+// a token from it. It returns whether a token was taken and otherwise the milliseconds until
+// the next one is available. Time is read from Redis so that clocks of workers do not matter.
+var tokenBucketScript = redis.NewScript(`
+-- Redis before 5.0 requires effects replication to write after reading the time
+if redis.replicate_commands then
+	redis.replicate_commands()
+end
+
+local capacity = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "updated_at")
+local tokens = tonumber(bucket[1])
+local updatedAt = tonumber(bucket[2])
+if tokens == nil or updatedAt == nil then
+	tokens = capacity
+	updatedAt = now
+end
+
+if now > updatedAt then
+	tokens = math.min(capacity, tokens + (now - updatedAt) / interval)
+	updatedAt = now
+end
+
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) * interval / 1000)
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "updated_at", tostring(updatedAt))
+redis.call("PEXPIRE", KEYS[1], math.ceil(capacity * interval / 1000) + 1000)
+
+return {allowed, wait}
+`)
+
+// RateLimiter is a token bucket rate limiter shared by all workers connected to the same Redis
+type RateLimiter struct {
+	rclient redis.UniversalClient
+}
+
+// New creates new RateLimiter instance connected with the Redis settings of the config
+func New(cnf *config.Config, addrs []string, db int) *RateLimiter {
+	return &RateLimiter{rclient: common.NewUniversalClient(cnf, addrs, db)}
+}
+
+// Allow takes a token from the bucket of the key
+func (r *RateLimiter) Allow(key string, limit iface.Limit) (bool, time.Duration, error) {
+	if limit.IsZero() {
+		return true, 0, nil
+	}
+
+	// The interval is passed in microseconds to keep sub-millisecond rates accurate
+	interval := limit.Interval() / float64(time.Microsecond)
+	reply, err := tokenBucketScript.Run(context.Background(), r.rclient, []string{key}, limit.Capacity(), interval).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+
+	return reply[0] == 1, time.Duration(reply[1]) * time.Millisecond, nil
+}
@@ -0,0 +1,33 @@
+package retry
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrPermanent wraps an error which makes no sense to retry, e.g. invalid input.
+// A task returning it fails right away regardless of its RetryCount.
+type ErrPermanent struct {
+	err error
+}
+
+// Error implements the error interface
+func (e ErrPermanent) Error() string {
+	return fmt.Sprintf("Permanent error: %v", e.err)
+}
+
+// Unwrap returns the wrapped error
+func (e ErrPermanent) Unwrap() error {
+	return e.err
+}
+
+// NewErrPermanent returns new ErrPermanent instance
+func NewErrPermanent(err error) ErrPermanent {
+	return ErrPermanent{err: err}
+}
+
+// IsPermanent returns true if err or any error it wraps is ErrPermanent
+func IsPermanent(err error) bool {
+	var permanent ErrPermanent
+	return errors.As(err, &permanent)
+}
@@ -0,0 +1,147 @@
+package retry
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Policy decides how long to wait before retrying a failed task
+type Policy interface {
+	// NextDelay returns the delay before the given retry attempt, starting from 1
+	NextDelay(attempt int) time.Duration
+}
+
+// Classifier can be implemented by a Policy to decide which errors are worth retrying
+type Classifier interface {
+	Retryable(err error) bool
+}
+
+// PolicyFunc adapts an ordinary function to the Policy interface
+type PolicyFunc func(attempt int) time.Duration
+
+// NextDelay calls f(attempt)
+func (f PolicyFunc) NextDelay(attempt int) time.Duration {
+	return f(attempt)
+}
+
+// Fixed waits the same delay before every retry
+func Fixed(delay time.Duration) Policy {
+	return PolicyFunc(func(attempt int) time.Duration {
+		return delay
+	})
+}
+
+// Linear waits initial before the first retry and increment longer before every next one
+func Linear(initial, increment time.Duration) Policy {
+	return PolicyFunc(func(attempt int) time.Duration {
+		return initial + time.Duration(attempt-1)*increment
+	})
+}
+
+// FibonacciPolicy waits unit multiplied by successive Fibonacci numbers
+func FibonacciPolicy(unit time.Duration) Policy {
+	return PolicyFunc(func(attempt int) time.Duration {
+		fib := Fibonacci()
+		num := fib()
+		for i := 1; i < attempt; i++ {
+			num = fib()
+		}
+		return time.Duration(num) * unit
+	})
+}
+
+// ExponentialJitter waits base multiplied by factor for every previous attempt, randomized
+// between half and the whole of that delay so that retries of many tasks failed
+// at the same time do not hit the recovering service at once
+func ExponentialJitter(base time.Duration, factor float64) Policy {
+	return PolicyFunc(func(attempt int) time.Duration {
+		delay := float64(base) * math.Pow(factor, float64(attempt-1))
+		if delay > math.MaxInt64 {
+			delay = math.MaxInt64
+		}
+		half := int64(delay / 2)
+		if half <= 0 {
+			return time.Duration(delay)
+		}
+		return time.Duration(half + rand.Int63n(half+1))
+	})
+}
+
+// Capped limits delays of a policy to max
+func Capped(policy Policy, max time.Duration) Policy {
+	return cappedPolicy{Policy: policy, max: max}
+}
+
+type cappedPolicy struct {
+	Policy
+	max time.Duration
+}
+
+// NextDelay returns the delay of the wrapped policy, max at the most
+func (p cappedPolicy) NextDelay(attempt int) time.Duration {
+	delay := p.Policy.NextDelay(attempt)
+	if delay > p.max || delay < 0 {
+		return p.max
+	}
+	return delay
+}
+
+// Retryable keeps the classification of the wrapped policy
+func (p cappedPolicy) Retryable(err error) bool {
+	return IsRetryable(p.Policy, err)
+}
+
+// RetryIf makes a policy retry only errors for which retryable returns true
+func RetryIf(policy Policy, retryable func(err error) bool) Policy {
+	return classifiedPolicy{Policy: policy, retryable: retryable}
+}
+
+type classifiedPolicy struct {
+	Policy
+	retryable func(err error) bool
+}
+
+// Retryable calls the classification func
+func (p classifiedPolicy) Retryable(err error) bool {
+	return p.retryable(err)
+}
+
+// IsRetryable returns false for permanent errors and for errors rejected by the
+// policy, if it implements Classifier. A nil policy retries all other errors.
+func IsRetryable(policy Policy, err error) bool {
+	if IsPermanent(err) {
+		return false
+	}
+	if classifier, ok := policy.(Classifier); ok {
+		return classifier.Retryable(err)
+	}
+	return true
+}
+
+var policies = struct {
+	sync.RWMutex
+	m map[string]Policy
+}{m: map[string]Policy{
+	"fibonacci":   FibonacciPolicy(time.Second),
+	"exponential": Capped(ExponentialJitter(time.Second, 2), time.Hour),
+	"fixed":       Fixed(time.Second * 10),
+	"linear":      Linear(time.Second*10, time.Second*10),
+}}
+
+// RegisterPolicy makes a policy selectable by name with Signature.RetryPolicy.
+// The fibonacci, exponential, fixed and linear policies are registered by default.
+func RegisterPolicy(name string, policy Policy) {
+	policies.Lock()
+	defer policies.Unlock()
+	policies.m[name] = policy
+}
+
+// GetPolicy returns a policy registered by name
+func GetPolicy(name string) (Policy, bool) {
+	policies.RLock()
+	defer policies.RUnlock()
+	policy, ok := policies.m[name]
+	return policy, ok
+}
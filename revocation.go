@@ -0,0 +1,150 @@
+package machinery
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/oarkflow/machinery/log"
+
+	backendsiface "github.com/oarkflow/machinery/backends/iface"
+)
+
+// revocationWatcher cancels running tasks as soon as they get revoked. Tasks are watched
+// through the state notifications of the backend if it supports them, otherwise their
+// states are polled by a single goroutine shared by all the tasks of the server
+type revocationWatcher struct {
+	server  *Server
+	mu      sync.Mutex
+	tasks   map[*watchedTask]struct{}
+	polling bool
+}
+
+// watchedTask is a running task watched for revocation
+type watchedTask struct {
+	uuid    string
+	cancel  context.CancelFunc
+	revoked int32
+}
+
+func (task *watchedTask) revoke() {
+	atomic.StoreInt32(&task.revoked, 1)
+	task.cancel()
+}
+
+func (task *watchedTask) isRevoked() bool {
+	return atomic.LoadInt32(&task.revoked) == 1
+}
+
+func newRevocationWatcher(server *Server) *revocationWatcher {
+	return &revocationWatcher{
+		server: server,
+		tasks:  make(map[*watchedTask]struct{}),
+	}
+}
+
+// watch calls cancel once the task gets revoked. It returns a function to call to stop
+// watching, which reports whether the task has been revoked
+func (w *revocationWatcher) watch(taskUUID string, cancel context.CancelFunc) func() bool {
+	task := &watchedTask{uuid: taskUUID, cancel: cancel}
+
+	if notifier, ok := w.server.GetBackend().(backendsiface.StateNotifier); ok {
+		stop, err := w.notified(notifier, task)
+		if err == nil {
+			return stop
+		}
+		log.WARNING.Printf("Failed to watch state of task %s, polling it instead: %s", taskUUID, err)
+	}
+
+	return w.polled(task)
+}
+
+// notified checks whether the task has been revoked whenever its state changes
+func (w *revocationWatcher) notified(notifier backendsiface.StateNotifier, task *watchedTask) (func() bool, error) {
+	notifications, stopNotifications, err := notifier.WatchState(task.uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-notifications:
+				if w.server.IsTaskRevoked(task.uuid) {
+					task.revoke()
+					return
+				}
+			}
+		}
+	}()
+
+	return func() bool {
+		close(done)
+		wg.Wait()
+		stopNotifications()
+		return task.isRevoked()
+	}, nil
+}
+
+// polled adds the task to the ones polled by the shared goroutine, starting it if needed
+func (w *revocationWatcher) polled(task *watchedTask) func() bool {
+	w.mu.Lock()
+	w.tasks[task] = struct{}{}
+	if !w.polling {
+		w.polling = true
+		go w.poll()
+	}
+	w.mu.Unlock()
+
+	return func() bool {
+		w.mu.Lock()
+		delete(w.tasks, task)
+		w.mu.Unlock()
+		return task.isRevoked()
+	}
+}
+
+// poll checks the states of all the polled tasks every RevokePollPeriod,
+// it returns once there are no tasks left to poll
+func (w *revocationWatcher) poll() {
+	pollPeriodMilliseconds := 1000 // default poll period for revocation
+	if configuredPollPeriod := w.server.GetConfig().RevokePollPeriod; configuredPollPeriod > 0 {
+		pollPeriodMilliseconds = configuredPollPeriod
+	}
+
+	ticker := time.NewTicker(time.Duration(pollPeriodMilliseconds) * time.Millisecond)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		w.mu.Lock()
+		if len(w.tasks) == 0 {
+			w.polling = false
+			w.mu.Unlock()
+			return
+		}
+		polled := make([]*watchedTask, 0, len(w.tasks))
+		for task := range w.tasks {
+			polled = append(polled, task)
+		}
+		w.mu.Unlock()
+
+		for _, task := range polled {
+			if !w.server.IsTaskRevoked(task.uuid) {
+				continue
+			}
+			w.mu.Lock()
+			delete(w.tasks, task)
+			w.mu.Unlock()
+			task.revoke()
+		}
+	}
+}
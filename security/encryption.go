@@ -0,0 +1,242 @@
+package security
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/oarkflow/machinery/tasks"
+)
+
+// Headers of signatures whose arguments are encrypted
+const (
+	// EncryptedArgsHeader holds the base64 encoded nonce and ciphertext of the JSON encoded arguments
+	EncryptedArgsHeader = "encrypted_args"
+	// EncryptionKeyIDHeader names the key which encrypted the arguments
+	EncryptionKeyIDHeader = "encryption_key_id"
+)
+
+// EncryptsPayloads returns true if task arguments and results get encrypted
+func (s *Security) EncryptsPayloads() bool {
+	return s != nil && s.encryptionKeyID != ""
+}
+
+// SealSignature returns a copy of the signature, and of its callbacks, with the arguments encrypted.
+// The signature is returned as is if encryption is not configured.
+func (s *Security) SealSignature(signature *tasks.Signature) (*tasks.Signature, error) {
+	if !s.EncryptsPayloads() || signature == nil {
+		return signature, nil
+	}
+
+	sealed := *signature
+	if _, ok := signature.Headers[EncryptedArgsHeader]; !ok && len(signature.Args) > 0 {
+		plaintext, err := json.Marshal(signature.Args)
+		if err != nil {
+			return nil, fmt.Errorf("JSON marshal error: %s", err)
+		}
+		ciphertext, err := s.encrypt(s.encryptionKeyID, plaintext, []byte(signature.UUID))
+		if err != nil {
+			return nil, err
+		}
+
+		sealed.Headers = make(tasks.Headers, len(signature.Headers)+2)
+		for key, value := range signature.Headers {
+			sealed.Headers[key] = value
+		}
+		sealed.Headers[EncryptedArgsHeader] = base64.StdEncoding.EncodeToString(ciphertext)
+		sealed.Headers[EncryptionKeyIDHeader] = s.encryptionKeyID
+		sealed.Args = nil
+	}
+
+	var err error
+	if sealed.OnSuccess, err = s.sealSignatures(signature.OnSuccess); err != nil {
+		return nil, err
+	}
+	if sealed.OnError, err = s.sealSignatures(signature.OnError); err != nil {
+		return nil, err
+	}
+	if sealed.ChordCallback, err = s.SealSignature(signature.ChordCallback); err != nil {
+		return nil, err
+	}
+	return &sealed, nil
+}
+
+func (s *Security) sealSignatures(signatures []*tasks.Signature) ([]*tasks.Signature, error) {
+	if signatures == nil {
+		return nil, nil
+	}
+
+	sealed := make([]*tasks.Signature, len(signatures))
+	for i, signature := range signatures {
+		sealedSignature, err := s.SealSignature(signature)
+		if err != nil {
+			return nil, err
+		}
+		sealed[i] = sealedSignature
+	}
+	return sealed, nil
+}
+
+// OpenSignature decrypts the arguments of the signature, and of its callbacks, sealed by SealSignature.
+// Arguments which cannot be decrypted are reported with ErrRejectedMessage.
+func (s *Security) OpenSignature(signature *tasks.Signature) error {
+	if signature == nil {
+		return nil
+	}
+
+	if encoded, ok := signature.Headers[EncryptedArgsHeader].(string); ok {
+		keyID, _ := signature.Headers[EncryptionKeyIDHeader].(string)
+		ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return err
+		}
+		plaintext, err := s.decrypt(keyID, ciphertext, []byte(signature.UUID))
+		if err != nil {
+			return ErrRejectedMessage{reason: err}
+		}
+
+		var args []tasks.Arg
+		decoder := json.NewDecoder(bytes.NewReader(plaintext))
+		decoder.UseNumber()
+		if err := decoder.Decode(&args); err != nil {
+			return fmt.Errorf("JSON unmarshal error: %s", err)
+		}
+
+		signature.Args = args
+		delete(signature.Headers, EncryptedArgsHeader)
+		delete(signature.Headers, EncryptionKeyIDHeader)
+	}
+
+	for _, callback := range signature.OnSuccess {
+		if err := s.OpenSignature(callback); err != nil {
+			return err
+		}
+	}
+	for _, callback := range signature.OnError {
+		if err := s.OpenSignature(callback); err != nil {
+			return err
+		}
+	}
+	return s.OpenSignature(signature.ChordCallback)
+}
+
+// SealTaskResults returns the task results with their values encrypted, after compression if
+// they are compressed. The results are returned as they are if encryption is not configured.
+func (s *Security) SealTaskResults(taskUUID string, taskResults []*tasks.TaskResult) ([]*tasks.TaskResult, error) {
+	if !s.EncryptsPayloads() {
+		return taskResults, nil
+	}
+
+	sealed := make([]*tasks.TaskResult, len(taskResults))
+	for i, taskResult := range taskResults {
+		var plaintext []byte
+		var err error
+		if taskResult.Encoding != "" {
+			plaintext, err = taskResult.EncodedValue()
+		} else {
+			plaintext, err = json.Marshal(taskResult.Value)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		ciphertext, err := s.encrypt(s.encryptionKeyID, plaintext, []byte(taskUUID))
+		if err != nil {
+			return nil, err
+		}
+		sealed[i] = &tasks.TaskResult{
+			Type:     taskResult.Type,
+			Value:    ciphertext,
+			Encoding: taskResult.Encoding,
+			KeyID:    s.encryptionKeyID,
+		}
+	}
+	return sealed, nil
+}
+
+// OpenTaskResults decrypts the values of task results sealed by SealTaskResults in place
+func (s *Security) OpenTaskResults(taskUUID string, taskResults []*tasks.TaskResult) error {
+	for _, taskResult := range taskResults {
+		if taskResult.KeyID == "" {
+			continue
+		}
+
+		ciphertext, err := taskResult.EncodedValue()
+		if err != nil {
+			return err
+		}
+		plaintext, err := s.decrypt(taskResult.KeyID, ciphertext, []byte(taskUUID))
+		if err != nil {
+			return err
+		}
+
+		if taskResult.Encoding != "" {
+			taskResult.Value = plaintext
+		} else {
+			var value interface{}
+			decoder := json.NewDecoder(bytes.NewReader(plaintext))
+			decoder.UseNumber()
+			if err := decoder.Decode(&value); err != nil {
+				return fmt.Errorf("JSON unmarshal error: %s", err)
+			}
+			taskResult.Value = value
+		}
+		taskResult.KeyID = ""
+	}
+	return nil
+}
+
+// Encrypt encrypts the data with the encryption key, it returns the ciphertext and the key ID.
+// The data is returned as is without key ID if encryption is not configured.
+func (s *Security) Encrypt(plaintext, additionalData []byte) ([]byte, string, error) {
+	if !s.EncryptsPayloads() {
+		return plaintext, "", nil
+	}
+
+	ciphertext, err := s.encrypt(s.encryptionKeyID, plaintext, additionalData)
+	if err != nil {
+		return nil, "", err
+	}
+	return ciphertext, s.encryptionKeyID, nil
+}
+
+// Decrypt decrypts data encrypted by Encrypt with the key, data without key ID is returned as is
+func (s *Security) Decrypt(keyID string, ciphertext, additionalData []byte) ([]byte, error) {
+	if keyID == "" {
+		return ciphertext, nil
+	}
+	return s.decrypt(keyID, ciphertext, additionalData)
+}
+
+// encrypt returns the random nonce followed by the ciphertext of the plaintext
+func (s *Security) encrypt(keyID string, plaintext, additionalData []byte) ([]byte, error) {
+	aead := s.ciphers[keyID]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// decrypt returns the plaintext of a ciphertext returned by encrypt
+func (s *Security) decrypt(keyID string, ciphertext, additionalData []byte) ([]byte, error) {
+	if s == nil {
+		return nil, ErrUnknownKey{keyID: keyID}
+	}
+	aead, ok := s.ciphers[keyID]
+	if !ok {
+		return nil, ErrUnknownKey{keyID: keyID}
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, ErrInvalidCiphertext
+	}
+
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	return plaintext, nil
+}
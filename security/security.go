@@ -0,0 +1,165 @@
+package security
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/oarkflow/machinery/config"
+)
+
+// Signing algorithms
+const (
+	HMACSHA256 = "hmac-sha256"
+	Ed25519    = "ed25519"
+)
+
+var (
+	// ErrUnsignedMessage is returned when a message is not signed although signatures are required
+	ErrUnsignedMessage = errors.New("Message is not signed")
+	// ErrInvalidSignature is returned when the signature of a message does not match its content
+	ErrInvalidSignature = errors.New("Message signature is invalid")
+	// ErrNoSigningKey is returned when publishing a message without a key able to sign it
+	ErrNoSigningKey = errors.New("No signing key configured")
+	// ErrInvalidCiphertext is returned when encrypted arguments or results cannot be decrypted
+	ErrInvalidCiphertext = errors.New("Ciphertext is invalid")
+
+	securities sync.Map
+)
+
+// ErrUnknownKey ...
+type ErrUnknownKey struct {
+	keyID string
+}
+
+// Error implements error interface
+func (e ErrUnknownKey) Error() string {
+	return fmt.Sprintf("Unknown key: %s", e.keyID)
+}
+
+// ErrRejectedMessage is returned when a message fails verification of its signature
+type ErrRejectedMessage struct {
+	reason error
+}
+
+// Error implements error interface
+func (e ErrRejectedMessage) Error() string {
+	return fmt.Sprintf("Message rejected: %s", e.reason)
+}
+
+// IsRejected returns true if the error is caused by a message which failed verification
+func IsRejected(err error) bool {
+	return errors.As(err, new(ErrRejectedMessage))
+}
+
+// Security signs and verifies messages and encrypts and decrypts task arguments and results.
+// A nil Security does none of it.
+type Security struct {
+	algorithm       string
+	signingKeyID    string
+	hmacKeys        map[string][]byte
+	privateKeys     map[string]ed25519.PrivateKey
+	publicKeys      map[string]ed25519.PublicKey
+	encryptionKeyID string
+	ciphers         map[string]cipher.AEAD
+}
+
+// ForConfig returns the Security of the config, nil if it configures neither signing nor encryption.
+// It is created once per config.
+func ForConfig(cnf *config.Config) (*Security, error) {
+	if cnf == nil || cnf.Security == nil {
+		return nil, nil
+	}
+
+	if security, ok := securities.Load(cnf.Security); ok {
+		return security.(*Security), nil
+	}
+
+	security, err := New(cnf.Security)
+	if err != nil {
+		return nil, err
+	}
+	securities.Store(cnf.Security, security)
+	return security, nil
+}
+
+// New creates Security instance with the keys of the config
+func New(cnf *config.SecurityConfig) (*Security, error) {
+	if cnf.SigningAlgorithm == "" && cnf.EncryptionKeyID == "" && len(cnf.EncryptionKeys) == 0 {
+		return nil, nil
+	}
+
+	s := &Security{
+		algorithm:       cnf.SigningAlgorithm,
+		signingKeyID:    cnf.SigningKeyID,
+		hmacKeys:        make(map[string][]byte),
+		privateKeys:     make(map[string]ed25519.PrivateKey),
+		publicKeys:      make(map[string]ed25519.PublicKey),
+		encryptionKeyID: cnf.EncryptionKeyID,
+		ciphers:         make(map[string]cipher.AEAD),
+	}
+
+	for keyID, encoded := range cnf.SigningKeys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("Signing key %s is not base64 encoded: %s", keyID, err)
+		}
+
+		switch {
+		case s.algorithm == HMACSHA256:
+			s.hmacKeys[keyID] = key
+		case s.algorithm == Ed25519 && len(key) == ed25519.PrivateKeySize:
+			s.privateKeys[keyID] = ed25519.PrivateKey(key)
+			s.publicKeys[keyID] = ed25519.PrivateKey(key).Public().(ed25519.PublicKey)
+		case s.algorithm == Ed25519 && len(key) == ed25519.PublicKeySize:
+			s.publicKeys[keyID] = ed25519.PublicKey(key)
+		case s.algorithm == Ed25519:
+			return nil, fmt.Errorf("Signing key %s is neither an Ed25519 private nor public key", keyID)
+		default:
+			return nil, fmt.Errorf("Unknown signing algorithm: %s", s.algorithm)
+		}
+	}
+
+	if s.algorithm != "" && len(cnf.SigningKeys) == 0 {
+		return nil, errors.New("Signing requires signing keys")
+	}
+	if s.signingKeyID != "" && !s.canSign(s.signingKeyID) {
+		return nil, ErrUnknownKey{keyID: s.signingKeyID}
+	}
+
+	for keyID, encoded := range cnf.EncryptionKeys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("Encryption key %s is not base64 encoded: %s", keyID, err)
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("Encryption key %s is invalid: %s", keyID, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		s.ciphers[keyID] = aead
+	}
+
+	if _, ok := s.ciphers[s.encryptionKeyID]; s.encryptionKeyID != "" && !ok {
+		return nil, ErrUnknownKey{keyID: s.encryptionKeyID}
+	}
+
+	return s, nil
+}
+
+// canSign returns true if the key can sign messages rather than only verify them
+func (s *Security) canSign(keyID string) bool {
+	if _, ok := s.hmacKeys[keyID]; ok {
+		return true
+	}
+	_, ok := s.privateKeys[keyID]
+	return ok
+}
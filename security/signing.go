@@ -0,0 +1,131 @@
+package security
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+)
+
+// ContentType identifies signed messages. They are framed like messages of other codecs
+// than JSON, followed by the signature header on a line of its own and the signed message.
+const ContentType = "application/x-machinery-signed"
+
+var signedPrefix = []byte("\x00" + ContentType + "\x00")
+
+// signatureHeader tells which key signed a message
+type signatureHeader struct {
+	KeyID     string
+	Algorithm string
+	Signature []byte
+}
+
+// IsSigned returns true if the message has been signed
+func IsSigned(message []byte) bool {
+	return bytes.HasPrefix(message, signedPrefix)
+}
+
+// VerifiesSignatures returns true if messages have to be signed
+func (s *Security) VerifiesSignatures() bool {
+	return s != nil && s.algorithm != ""
+}
+
+// Sign prepends a header with the signature of the message made with the signing key.
+// The message is returned as is if signing is not configured.
+func (s *Security) Sign(message []byte) ([]byte, error) {
+	if !s.VerifiesSignatures() {
+		return message, nil
+	}
+	if !s.canSign(s.signingKeyID) {
+		return nil, ErrNoSigningKey
+	}
+
+	header, err := json.Marshal(&signatureHeader{
+		KeyID:     s.signingKeyID,
+		Algorithm: s.algorithm,
+		Signature: s.sign(s.signingKeyID, message),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("JSON marshal error: %s", err)
+	}
+
+	signed := make([]byte, 0, len(signedPrefix)+len(header)+len(message)+1)
+	signed = append(signed, signedPrefix...)
+	signed = append(signed, header...)
+	signed = append(signed, '\n')
+	return append(signed, message...), nil
+}
+
+// Verify checks the signature of a message signed by Sign and returns the message without it.
+// Messages which are not signed are rejected when signing is configured, otherwise they are
+// returned as is and the signature header is just stripped from signed ones. Messages failing
+// verification are reported with ErrRejectedMessage.
+func (s *Security) Verify(message []byte) ([]byte, error) {
+	if !IsSigned(message) {
+		if s.VerifiesSignatures() {
+			return nil, ErrRejectedMessage{reason: ErrUnsignedMessage}
+		}
+		return message, nil
+	}
+
+	signed := message[len(signedPrefix):]
+	end := bytes.IndexByte(signed, '\n')
+	if end < 0 {
+		return nil, ErrRejectedMessage{reason: ErrInvalidSignature}
+	}
+
+	header := new(signatureHeader)
+	if err := json.Unmarshal(signed[:end], header); err != nil {
+		return nil, ErrRejectedMessage{reason: ErrInvalidSignature}
+	}
+	signed = signed[end+1:]
+
+	if !s.VerifiesSignatures() {
+		return signed, nil
+	}
+	if header.Algorithm != s.algorithm {
+		return nil, ErrRejectedMessage{reason: fmt.Errorf("Message is signed with %s rather than %s", header.Algorithm, s.algorithm)}
+	}
+	if err := s.verify(header.KeyID, signed, header.Signature); err != nil {
+		return nil, ErrRejectedMessage{reason: err}
+	}
+	return signed, nil
+}
+
+// sign returns the signature of the message made with the key
+func (s *Security) sign(keyID string, message []byte) []byte {
+	if s.algorithm == Ed25519 {
+		return ed25519.Sign(s.privateKeys[keyID], message)
+	}
+
+	mac := hmac.New(sha256.New, s.hmacKeys[keyID])
+	mac.Write(message)
+	return mac.Sum(nil)
+}
+
+// verify checks the signature of the message made with the key
+func (s *Security) verify(keyID string, message, signature []byte) error {
+	if s.algorithm == Ed25519 {
+		publicKey, ok := s.publicKeys[keyID]
+		if !ok {
+			return ErrUnknownKey{keyID: keyID}
+		}
+		if !ed25519.Verify(publicKey, message, signature) {
+			return ErrInvalidSignature
+		}
+		return nil
+	}
+
+	key, ok := s.hmacKeys[keyID]
+	if !ok {
+		return ErrUnknownKey{keyID: keyID}
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(message)
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
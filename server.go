@@ -11,17 +11,23 @@ import (
 	"github.com/robfig/cron/v3"
 
 	"github.com/oarkflow/machinery/backends/result"
+	"github.com/oarkflow/machinery/claimcheck"
 	"github.com/oarkflow/machinery/config"
+	"github.com/oarkflow/machinery/factory"
 	"github.com/oarkflow/machinery/log"
+	"github.com/oarkflow/machinery/metrics"
+	"github.com/oarkflow/machinery/security"
 	"github.com/oarkflow/machinery/tasks"
 	"github.com/oarkflow/machinery/tracing"
 	"github.com/oarkflow/machinery/utils"
 
-	"github.com/opentracing/opentracing-go"
-
 	backendsiface "github.com/oarkflow/machinery/backends/iface"
+	eagerbroker "github.com/oarkflow/machinery/brokers/eager"
+	"github.com/oarkflow/machinery/brokers/errs"
 	brokersiface "github.com/oarkflow/machinery/brokers/iface"
 	lockiface "github.com/oarkflow/machinery/locks/iface"
+	eagerratelimiter "github.com/oarkflow/machinery/ratelimiters/eager"
+	ratelimitersiface "github.com/oarkflow/machinery/ratelimiters/iface"
 )
 
 // Server is the main Machinery object and stores all configuration
@@ -29,11 +35,19 @@ import (
 type Server struct {
 	config            *config.Config
 	registeredTasks   *sync.Map
+	taskOptions       *sync.Map
 	broker            brokersiface.Broker
 	backend           backendsiface.Backend
 	lock              lockiface.Lock
 	scheduler         *cron.Cron
 	prePublishHandler func(*tasks.Signature)
+	// publishMiddlewares wrap every publish to the broker
+	publishMiddlewares []PublishMiddleware
+	metrics            metrics.Recorder
+	tracer             tracing.Tracer
+	rateLimiter        ratelimitersiface.RateLimiter
+	// revocations cancels the running tasks once they get revoked
+	revocations *revocationWatcher
 }
 
 // NewServer creates Server instance
@@ -41,11 +55,16 @@ func NewServer(cnf *config.Config, brokerServer brokersiface.Broker, backendServ
 	srv := &Server{
 		config:          cnf,
 		registeredTasks: new(sync.Map),
+		taskOptions:     new(sync.Map),
 		broker:          brokerServer,
 		backend:         backendServer,
 		lock:            lock,
 		scheduler:       cron.New(),
+		metrics:         metrics.Nop{},
+		tracer:          tracing.OpenTracing{},
+		rateLimiter:     eagerratelimiter.New(),
 	}
+	srv.revocations = newRevocationWatcher(srv)
 
 	// Run scheduler job
 	go srv.scheduler.Run()
@@ -53,6 +72,44 @@ func NewServer(cnf *config.Config, brokerServer brokersiface.Broker, backendServ
 	return srv
 }
 
+// NewServerFromConfig creates Server instance with the broker, result backend
+// and lock built from the Broker, ResultBackend and Lock URLs of the config
+func NewServerFromConfig(cnf *config.Config) (*Server, error) {
+	brokerServer, err := factory.BrokerFactory(cnf)
+	if err != nil {
+		return nil, err
+	}
+
+	backendServer, err := factory.BackendFactory(cnf)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := factory.LockFactory(cnf)
+	if err != nil {
+		return nil, err
+	}
+
+	rateLimiter, err := factory.RateLimiterFactory(cnf)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := claimcheck.ForConfig(cnf); err != nil {
+		return nil, err
+	}
+
+	srv := NewServer(cnf, brokerServer, backendServer, lock)
+	srv.SetRateLimiter(rateLimiter)
+
+	// init for eager-mode
+	if eager, ok := brokerServer.(eagerbroker.Mode); ok {
+		eager.AssignWorker(srv.NewWorker("eager", 0))
+	}
+
+	return srv, nil
+}
+
 // NewWorker creates Worker instance
 func (server *Server) NewWorker(consumerTag string, concurrency int) *Worker {
 	return &Worker{
@@ -103,6 +160,36 @@ func (server *Server) SetConfig(cnf *config.Config) {
 	server.config = cnf
 }
 
+// SetMetricsRecorder sets the recorder of task, broker and backend measurements, e.g. metrics.Collector
+func (server *Server) SetMetricsRecorder(recorder metrics.Recorder) {
+	server.metrics = recorder
+}
+
+// GetMetricsRecorder returns the metrics recorder
+func (server *Server) GetMetricsRecorder() metrics.Recorder {
+	return server.metrics
+}
+
+// SetRateLimiter sets the rate limiter of tasks registered with TaskOptions.RateLimit
+func (server *Server) SetRateLimiter(rateLimiter ratelimitersiface.RateLimiter) {
+	server.rateLimiter = rateLimiter
+}
+
+// GetRateLimiter returns the rate limiter
+func (server *Server) GetRateLimiter() ratelimitersiface.RateLimiter {
+	return server.rateLimiter
+}
+
+// SetTracer sets the tracer of published and processed tasks, tracing.OpenTracing by default
+func (server *Server) SetTracer(tracer tracing.Tracer) {
+	server.tracer = tracer
+}
+
+// GetTracer returns the tracer
+func (server *Server) GetTracer() tracing.Tracer {
+	return server.tracer
+}
+
 // SetPreTaskHandler Sets pre publish handler
 func (server *Server) SetPreTaskHandler(handler func(*tasks.Signature)) {
 	server.prePublishHandler = handler
@@ -132,6 +219,24 @@ func (server *Server) RegisterTask(name string, taskFunc interface{}) error {
 	return nil
 }
 
+// RegisterTaskWithOptions registers a single task with defaults applied to all its signatures
+func (server *Server) RegisterTaskWithOptions(name string, taskFunc interface{}, options TaskOptions) error {
+	if err := server.RegisterTask(name, taskFunc); err != nil {
+		return err
+	}
+	server.taskOptions.Store(name, options)
+	return nil
+}
+
+// GetTaskOptions returns options of a registered task, zero options if none were registered
+func (server *Server) GetTaskOptions(name string) TaskOptions {
+	options, ok := server.taskOptions.Load(name)
+	if !ok {
+		return TaskOptions{}
+	}
+	return options.(TaskOptions)
+}
+
 // IsTaskRegistered returns true if the task name is registered with this broker
 func (server *Server) IsTaskRegistered(name string) bool {
 	_, ok := server.registeredTasks.Load(name)
@@ -149,11 +254,11 @@ func (server *Server) GetRegisteredTask(name string) (interface{}, error) {
 
 // SendTaskWithContext will inject the trace context in the signature headers before publishing it
 func (server *Server) SendTaskWithContext(ctx context.Context, signature *tasks.Signature) (*result.AsyncResult, error) {
-	span, _ := opentracing.StartSpanFromContext(ctx, "SendTask", tracing.ProducerOption(), tracing.MachineryTag)
+	ctx, span := server.tracer.StartProducerSpan(ctx, "SendTask", signature.Headers)
 	defer span.Finish()
 
-	// tag the span with some info about the signature
-	signature.Headers = tracing.HeadersWithSpan(signature.Headers, span)
+	// propagate the trace context in the signature headers
+	signature.Headers = server.tracer.Inject(ctx, signature.Headers)
 
 	// Make sure result backend is defined
 	if server.backend == nil {
@@ -166,8 +271,24 @@ func (server *Server) SendTaskWithContext(ctx context.Context, signature *tasks.
 		signature.UUID = fmt.Sprintf("task_%v", taskID)
 	}
 
+	// Refuse or collapse a duplicate of a unique task which is pending or running
+	if signature.UniqueKey != "" {
+		owner, err := server.lockUnique(signature)
+		if err != nil {
+			return nil, fmt.Errorf("Unique key lock error: %s", err)
+		}
+		if owner != "" {
+			if signature.CollapseDuplicate {
+				return result.NewAsyncResult(&tasks.Signature{UUID: owner, Name: signature.Name}, server.backend), nil
+			}
+			return nil, tasks.ErrDuplicateTask{UniqueKey: signature.UniqueKey, UUID: owner}
+		}
+	}
+
 	// Set initial task state to PENDING
 	if err := server.backend.SetStatePending(signature); err != nil {
+		server.metrics.BackendError("set_state_pending")
+		server.unlockUnique(signature)
 		return nil, fmt.Errorf("Set state pending error: %s", err)
 	}
 
@@ -175,7 +296,8 @@ func (server *Server) SendTaskWithContext(ctx context.Context, signature *tasks.
 		server.prePublishHandler(signature)
 	}
 
-	if err := server.broker.Publish(ctx, signature); err != nil {
+	if err := server.publish(ctx, signature); err != nil {
+		server.unlockUnique(signature)
 		return nil, fmt.Errorf("Publish message error: %s", err)
 	}
 
@@ -189,17 +311,12 @@ func (server *Server) SendTask(signature *tasks.Signature) (*result.AsyncResult,
 
 // SendChainWithContext will inject the trace context in all the signature headers before publishing it
 func (server *Server) SendChainWithContext(ctx context.Context, chain *tasks.Chain) (*result.ChainAsyncResult, error) {
-	span, _ := opentracing.StartSpanFromContext(ctx, "SendChain", tracing.ProducerOption(), tracing.MachineryTag, tracing.WorkflowChainTag)
+	ctx, span := server.tracer.StartProducerSpan(ctx, "SendChain", nil)
 	defer span.Finish()
 
-	tracing.AnnotateSpanWithChainInfo(span, chain)
+	tracing.AnnotateChain(ctx, server.tracer, span, chain)
 
-	return server.SendChain(chain)
-}
-
-// SendChain triggers a chain of tasks
-func (server *Server) SendChain(chain *tasks.Chain) (*result.ChainAsyncResult, error) {
-	_, err := server.SendTask(chain.Tasks[0])
+	_, err := server.SendTaskWithContext(ctx, chain.Tasks[0])
 	if err != nil {
 		return nil, err
 	}
@@ -207,12 +324,17 @@ func (server *Server) SendChain(chain *tasks.Chain) (*result.ChainAsyncResult, e
 	return result.NewChainAsyncResult(chain.Tasks, server.backend), nil
 }
 
+// SendChain triggers a chain of tasks
+func (server *Server) SendChain(chain *tasks.Chain) (*result.ChainAsyncResult, error) {
+	return server.SendChainWithContext(context.Background(), chain)
+}
+
 // SendGroupWithContext will inject the trace context in all the signature headers before publishing it
 func (server *Server) SendGroupWithContext(ctx context.Context, group *tasks.Group, sendConcurrency int) ([]*result.AsyncResult, error) {
-	span, _ := opentracing.StartSpanFromContext(ctx, "SendGroup", tracing.ProducerOption(), tracing.MachineryTag, tracing.WorkflowGroupTag)
+	ctx, span := server.tracer.StartProducerSpan(ctx, "SendGroup", nil)
 	defer span.Finish()
 
-	tracing.AnnotateSpanWithGroupInfo(span, group, sendConcurrency)
+	tracing.AnnotateGroup(ctx, server.tracer, span, group, sendConcurrency)
 
 	// Make sure result backend is defined
 	if server.backend == nil {
@@ -254,7 +376,7 @@ func (server *Server) SendGroupWithContext(ctx context.Context, group *tasks.Gro
 
 			// Publish task
 
-			err := server.broker.Publish(ctx, s)
+			err := server.publish(ctx, s)
 
 			if sendConcurrency > 0 {
 				pool <- struct{}{}
@@ -290,10 +412,10 @@ func (server *Server) SendGroup(group *tasks.Group, sendConcurrency int) ([]*res
 
 // SendChordWithContext will inject the trace context in all the signature headers before publishing it
 func (server *Server) SendChordWithContext(ctx context.Context, chord *tasks.Chord, sendConcurrency int) (*result.ChordAsyncResult, error) {
-	span, _ := opentracing.StartSpanFromContext(ctx, "SendChord", tracing.ProducerOption(), tracing.MachineryTag, tracing.WorkflowChordTag)
+	ctx, span := server.tracer.StartProducerSpan(ctx, "SendChord", nil)
 	defer span.Finish()
 
-	tracing.AnnotateSpanWithChordInfo(span, chord, sendConcurrency)
+	tracing.AnnotateChord(ctx, server.tracer, span, chord)
 
 	_, err := server.SendGroupWithContext(ctx, chord.Group, sendConcurrency)
 	if err != nil {
@@ -312,6 +434,305 @@ func (server *Server) SendChord(chord *tasks.Chord, sendConcurrency int) (*resul
 	return server.SendChordWithContext(context.Background(), chord, sendConcurrency)
 }
 
+// SendWorkflowWithContext will inject the trace context in all the signature headers before publishing
+// the nodes of the workflow which depend on no other node
+func (server *Server) SendWorkflowWithContext(ctx context.Context, workflow *tasks.Workflow) (*result.WorkflowAsyncResult, error) {
+	ctx, span := server.tracer.StartProducerSpan(ctx, "SendWorkflow", nil)
+	defer span.Finish()
+
+	tracing.AnnotateWorkflow(ctx, server.tracer, span, workflow)
+
+	// Make sure result backend is defined
+	if server.backend == nil {
+		return nil, errors.New("Result backend required")
+	}
+
+	// The AMQP backend publishes the state of a task only to the queue of its group, the join
+	// groups of nodes depending on several nodes would never complete
+	if server.backend.IsAMQP() {
+		return nil, errors.New("Workflows are not supported with the AMQP result backend")
+	}
+
+	if len(workflow.NodeNames) == 0 {
+		return nil, errors.New("Workflow requires at least one node")
+	}
+
+	backend, err := server.workflowBackend()
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the definition of the workflow in the result backend, the workers processing its
+	// nodes load it to trigger the nodes depending on them
+	stored, err := server.storedWorkflow(ctx, workflow)
+	if err != nil {
+		return nil, err
+	}
+	if err := backend.SaveWorkflow(stored); err != nil {
+		server.metrics.BackendError("save_workflow")
+		return nil, fmt.Errorf("Save workflow error: %s", err)
+	}
+
+	for _, name := range workflow.NodeNames {
+		if len(workflow.Dependencies[name]) == 0 {
+			continue
+		}
+
+		// Init the group of the dependencies of nodes depending on several nodes
+		if len(workflow.Dependencies[name]) > 1 {
+			if err := server.backend.InitGroup(workflow.NodeGroupUUID(name), workflow.GetDependencyUUIDs(name)); err != nil {
+				return nil, fmt.Errorf("Init group error: %s", err)
+			}
+		}
+
+		// Init the Pending state of the nodes which run later
+		if err := server.backend.SetStatePending(workflow.Nodes[name]); err != nil {
+			server.metrics.BackendError("set_state_pending")
+			return nil, fmt.Errorf("Set state pending error: %s", err)
+		}
+	}
+
+	for _, name := range workflow.Roots() {
+		if _, err := server.SendTaskWithContext(ctx, workflow.NodeSignature(name)); err != nil {
+			return nil, err
+		}
+	}
+
+	return result.NewWorkflowAsyncResult(workflow, server.backend), nil
+}
+
+// SendWorkflow triggers a workflow of tasks
+func (server *Server) SendWorkflow(workflow *tasks.Workflow) (*result.WorkflowAsyncResult, error) {
+	return server.SendWorkflowWithContext(context.Background(), workflow)
+}
+
+// GetWorkflow returns the definition of a workflow sent by the server
+func (server *Server) GetWorkflow(workflowUUID string) (*tasks.Workflow, error) {
+	backend, err := server.workflowBackend()
+	if err != nil {
+		return nil, err
+	}
+
+	workflow, err := backend.GetWorkflow(workflowUUID)
+	if err != nil {
+		server.metrics.BackendError("get_workflow")
+		return nil, fmt.Errorf("Get workflow %s error: %s", workflowUUID, err)
+	}
+	return workflow, nil
+}
+
+// GetWorkflowState returns the states of the nodes of a workflow sent by the server
+func (server *Server) GetWorkflowState(workflowUUID string) (*tasks.WorkflowState, error) {
+	workflow, err := server.GetWorkflow(workflowUUID)
+	if err != nil {
+		return nil, err
+	}
+	return result.NewWorkflowAsyncResult(workflow, server.backend).GetState(), nil
+}
+
+// storedWorkflow returns a copy of the workflow to keep in the result backend, with the arguments
+// of its nodes kept in the blob store if they are large and encrypted if configured. Nodes which
+// depend on no other node are sent right away, their arguments are not kept.
+func (server *Server) storedWorkflow(ctx context.Context, workflow *tasks.Workflow) (*tasks.Workflow, error) {
+	claimCheck, err := claimcheck.ForConfig(server.config)
+	if err != nil {
+		return nil, err
+	}
+	sec, err := security.ForConfig(server.config)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := *workflow
+	stored.Nodes = make(map[string]*tasks.Signature, len(workflow.Nodes))
+	for name, signature := range workflow.Nodes {
+		node := *signature
+		if len(workflow.Dependencies[name]) == 0 {
+			node.Args = nil
+		}
+		stored.Nodes[name] = &node
+	}
+
+	checked, err := claimCheck.CheckWorkflow(ctx, &stored)
+	if err != nil {
+		return nil, err
+	}
+	for name, signature := range checked.Nodes {
+		if stored.Nodes[name], err = sec.SealSignature(signature); err != nil {
+			return nil, err
+		}
+	}
+	return &stored, nil
+}
+
+// workflowBackend returns the result backend if it keeps the definitions of workflows
+func (server *Server) workflowBackend() (backendsiface.WorkflowBackend, error) {
+	if server.backend == nil {
+		return nil, errors.New("Result backend required")
+	}
+	backend, ok := server.backend.(backendsiface.WorkflowBackend)
+	if !ok {
+		return nil, errors.New("Result backend does not support workflows")
+	}
+	return backend, nil
+}
+
+// RevokeTask marks the task as revoked in the result backend. Workers skip
+// a revoked task when they receive it and cancel the context of a running one.
+// A completed task keeps its state and results.
+func (server *Server) RevokeTask(taskUUID string) error {
+	return server.revoke(&tasks.Signature{UUID: taskUUID})
+}
+
+// RevokeGroup revokes all tasks of a group
+func (server *Server) RevokeGroup(group *tasks.Group) error {
+	for _, signature := range group.Tasks {
+		if err := server.revoke(signature); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RevokeChain revokes all tasks of a chain, including the ones which
+// have not been published yet
+func (server *Server) RevokeChain(chain *tasks.Chain) error {
+	for _, signature := range chain.Tasks {
+		if err := server.revoke(signature); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RevokeChord revokes all tasks of the chord group and the chord callback
+func (server *Server) RevokeChord(chord *tasks.Chord) error {
+	if err := server.RevokeGroup(chord.Group); err != nil {
+		return err
+	}
+	return server.revoke(chord.Callback)
+}
+
+// RevokeWorkflow revokes all nodes of a workflow, including the ones which
+// have not been published yet
+func (server *Server) RevokeWorkflow(workflow *tasks.Workflow) error {
+	for _, name := range workflow.NodeNames {
+		if err := server.revoke(workflow.Nodes[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsTaskRevoked returns true if the task has been revoked. Revocation is never
+// reported with the AMQP result backend as it consumes task states when reading them
+func (server *Server) IsTaskRevoked(taskUUID string) bool {
+	if server.backend == nil || server.backend.IsAMQP() {
+		return false
+	}
+
+	taskState, err := server.backend.GetState(taskUUID)
+	if err != nil {
+		return false
+	}
+
+	return taskState.IsRevoked()
+}
+
+// revoke records revocation of a single task in the result backend
+func (server *Server) revoke(signature *tasks.Signature) error {
+	if server.backend == nil {
+		return errors.New("Result backend required")
+	}
+
+	// The AMQP backend consumes task states when reading them, workers would never see the revocation
+	if server.backend.IsAMQP() {
+		return errors.New("Revocation is not supported with the AMQP result backend")
+	}
+
+	if err := server.backend.SetStateRevoked(signature); err != nil {
+		return fmt.Errorf("Set state to 'revoked' for task %s returned error: %s", signature.UUID, err)
+	}
+
+	return nil
+}
+
+// GetDeadLetters returns up to limit tasks from the dead-letter queue, all of them when limit is
+// not positive. Failure metadata is kept in the signature headers, see tasks.DeadLetterReasonHeader
+func (server *Server) GetDeadLetters(limit int) ([]*tasks.Signature, error) {
+	broker, err := server.deadLetterBroker()
+	if err != nil {
+		return nil, err
+	}
+	return broker.GetDeadLetters(limit)
+}
+
+// GetDeadLetter returns a task from the dead-letter queue by its UUID
+func (server *Server) GetDeadLetter(taskUUID string) (*tasks.Signature, error) {
+	deadLetters, err := server.GetDeadLetters(0)
+	if err != nil {
+		return nil, err
+	}
+	for _, deadLetter := range deadLetters {
+		if deadLetter.UUID == taskUUID {
+			return deadLetter, nil
+		}
+	}
+	return nil, errs.ErrDeadLetterNotFound
+}
+
+// ReplayDeadLetter removes a task from the dead-letter queue and sends it to its original queue again
+func (server *Server) ReplayDeadLetter(taskUUID string) (*result.AsyncResult, error) {
+	broker, err := server.deadLetterBroker()
+	if err != nil {
+		return nil, err
+	}
+
+	deadLetter, err := server.GetDeadLetter(taskUUID)
+	if err != nil {
+		return nil, err
+	}
+	if deadLetter.IsUnparseable() {
+		return nil, fmt.Errorf("Dead letter %s holds a message which could not be unmarshaled and can not be replayed", taskUUID)
+	}
+
+	deadLetter, err = broker.RemoveDeadLetter(taskUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	asyncResult, err := server.SendTask(tasks.ReplayDeadLetter(deadLetter))
+	if err != nil {
+		// Keep the task in the dead-letter queue
+		if publishErr := broker.PublishDeadLetter(context.Background(), deadLetter); publishErr != nil {
+			log.ERROR.Printf("Failed to return task %s to the dead-letter queue: %s", taskUUID, publishErr)
+		}
+		return nil, err
+	}
+	return asyncResult, nil
+}
+
+// PurgeDeadLetters deletes all tasks from the dead-letter queue
+func (server *Server) PurgeDeadLetters() error {
+	broker, err := server.deadLetterBroker()
+	if err != nil {
+		return err
+	}
+	return broker.PurgeDeadLetters()
+}
+
+// deadLetterBroker returns the broker if it supports dead letters and a dead-letter queue is configured
+func (server *Server) deadLetterBroker() (brokersiface.DeadLetterBroker, error) {
+	if server.config.DeadLetterQueue == "" {
+		return nil, errs.ErrDeadLetterQueueNotConfigured
+	}
+	broker, ok := server.broker.(brokersiface.DeadLetterBroker)
+	if !ok {
+		return nil, errors.New("Broker does not support dead letters")
+	}
+	return broker, nil
+}
+
 // GetRegisteredTaskNames returns slice of registered task names
 func (server *Server) GetRegisteredTaskNames() []string {
 	taskNames := make([]string, 0)
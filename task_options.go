@@ -0,0 +1,101 @@
+package machinery
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/oarkflow/machinery/retry"
+	"github.com/oarkflow/machinery/tasks"
+
+	ratelimitersiface "github.com/oarkflow/machinery/ratelimiters/iface"
+)
+
+// TaskOptions holds defaults applied to every signature of a registered task.
+// Values set on the signature itself take precedence.
+type TaskOptions struct {
+	// SoftTimeLimit cancels the context passed to the task once it elapses
+	SoftTimeLimit time.Duration
+	// HardTimeLimit abandons the task once it elapses and fails it with tasks.ErrTaskTimeLimitExceeded
+	HardTimeLimit time.Duration
+	// RetryPolicy delays retries of the task unless the signature names its own retry policy
+	RetryPolicy retry.Policy
+	// MaxConcurrency caps the number of instances of the task a worker runs at once. Messages
+	// received while the task is saturated are published again with an ETA so that other
+	// tasks keep being consumed. When zero, the task is only limited by the worker concurrency.
+	// It is not enforced in eager mode, where tasks run synchronously within their senders.
+	MaxConcurrency int
+	// SaturationDelay delays messages received while MaxConcurrency instances are running.
+	// The delay doubles every time the same message is put off, up to MaxSaturationDelay.
+	// Default: 1 second
+	SaturationDelay time.Duration
+	// MaxSaturationDelay caps the delay of messages put off repeatedly
+	// Default: 1 minute
+	MaxSaturationDelay time.Duration
+	// RateLimit caps executions of the task across all workers sharing the rate limiter of the
+	// server, see config.Config.RateLimiter. Messages over the limit are published again with
+	// an ETA of when the limit allows them to run.
+	RateLimit ratelimitersiface.Limit
+	// RateLimitKey partitions the rate limit of the task, e.g. per customer, see RateLimitKeyFromArg
+	// and RateLimitKeyFromHeader. When nil, the limit applies to all signatures of the task.
+	RateLimitKey func(signature *tasks.Signature) string
+}
+
+const (
+	defaultSaturationDelay    = time.Second
+	defaultMaxSaturationDelay = time.Minute
+)
+
+// rateLimitKey returns the key of the token bucket of the signature
+func (options TaskOptions) rateLimitKey(signature *tasks.Signature) string {
+	key := "machinery_rate_limit:" + signature.Name
+	if options.RateLimitKey != nil {
+		if partition := options.RateLimitKey(signature); partition != "" {
+			key += ":" + partition
+		}
+	}
+	return key
+}
+
+// RateLimitKeyFromArg partitions the rate limit of a task by the value of its argument at the index
+func RateLimitKeyFromArg(index int) func(signature *tasks.Signature) string {
+	return func(signature *tasks.Signature) string {
+		if index < 0 || index >= len(signature.Args) {
+			return ""
+		}
+		return fmt.Sprint(signature.Args[index].Value)
+	}
+}
+
+// RateLimitKeyFromHeader partitions the rate limit of a task by the value of a header
+func RateLimitKeyFromHeader(header string) func(signature *tasks.Signature) string {
+	return func(signature *tasks.Signature) string {
+		value, ok := signature.Headers[header]
+		if !ok {
+			return ""
+		}
+		return fmt.Sprint(value)
+	}
+}
+
+// saturationDelay returns the delay of messages received while the task is saturated,
+// backing off exponentially with the number of times the message has been put off
+func (options TaskOptions) saturationDelay(deferrals int) time.Duration {
+	delay, maxDelay := defaultSaturationDelay, defaultMaxSaturationDelay
+	if options.SaturationDelay > 0 {
+		delay = options.SaturationDelay
+	}
+	if options.MaxSaturationDelay > 0 {
+		maxDelay = options.MaxSaturationDelay
+	}
+	return retry.Capped(retry.ExponentialJitter(delay, 2), maxDelay).NextDelay(deferrals + 1)
+}
+
+// applyTo sets the defaults on a task prepared from a signature
+func (options TaskOptions) applyTo(task *tasks.Task) {
+	if task.SoftTimeLimit == 0 {
+		task.SoftTimeLimit = options.SoftTimeLimit
+	}
+	if task.HardTimeLimit == 0 {
+		task.HardTimeLimit = options.HardTimeLimit
+	}
+}
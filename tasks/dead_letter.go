@@ -0,0 +1,101 @@
+package tasks
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Headers describing why and when a message has been dead-lettered
+const (
+	DeadLetterReasonHeader  = "dead_letter_reason"
+	DeadLetterQueueHeader   = "dead_letter_queue"
+	DeadLetterTimeHeader    = "dead_letter_time"
+	DeadLetterRetriesHeader = "dead_letter_retries"
+	// DeadLetterBodyHeader holds the base64 encoded raw message which could not be unmarshaled
+	// into a signature, see DeadLetterBody
+	DeadLetterBodyHeader = "dead_letter_body"
+	// DeadLetterContentTypeHeader and DeadLetterContentEncodingHeader hold the content type
+	// and encoding of the raw message if the broker knows them
+	DeadLetterContentTypeHeader     = "dead_letter_content_type"
+	DeadLetterContentEncodingHeader = "dead_letter_content_encoding"
+)
+
+var deadLetterHeaders = []string{
+	DeadLetterReasonHeader,
+	DeadLetterQueueHeader,
+	DeadLetterTimeHeader,
+	DeadLetterRetriesHeader,
+	DeadLetterBodyHeader,
+	DeadLetterContentTypeHeader,
+	DeadLetterContentEncodingHeader,
+}
+
+// NewDeadLetter returns a copy of the signature with failure metadata in its headers
+func NewDeadLetter(signature *Signature, reason error) *Signature {
+	deadLetter := CopySignature(signature)
+	if deadLetter.Headers == nil {
+		deadLetter.Headers = Headers{}
+	}
+	deadLetter.Headers[DeadLetterReasonHeader] = reason.Error()
+	deadLetter.Headers[DeadLetterQueueHeader] = signature.RoutingKey
+	deadLetter.Headers[DeadLetterTimeHeader] = time.Now().UTC().Format(time.RFC3339Nano)
+	deadLetter.Headers[DeadLetterRetriesHeader] = strconv.Itoa(signature.RetryAttempt)
+	deadLetter.ETA = nil
+	return deadLetter
+}
+
+// NewUnparseableDeadLetter wraps a message which could not be unmarshaled into a signature.
+// The content type and encoding are those of the message headers and may be empty.
+func NewUnparseableDeadLetter(queue string, body []byte, contentType, contentEncoding string, reason error) *Signature {
+	deadLetter := &Signature{
+		UUID: fmt.Sprintf("dead_letter_%v", uuid.New().String()),
+		Headers: Headers{
+			DeadLetterReasonHeader:  reason.Error(),
+			DeadLetterQueueHeader:   queue,
+			DeadLetterTimeHeader:    time.Now().UTC().Format(time.RFC3339Nano),
+			DeadLetterRetriesHeader: "0",
+			DeadLetterBodyHeader:    base64.StdEncoding.EncodeToString(body),
+		},
+	}
+	if contentType != "" {
+		deadLetter.Headers[DeadLetterContentTypeHeader] = contentType
+	}
+	if contentEncoding != "" {
+		deadLetter.Headers[DeadLetterContentEncodingHeader] = contentEncoding
+	}
+	return deadLetter
+}
+
+// IsUnparseable returns true if the dead letter wraps a message which could not be unmarshaled
+func (s *Signature) IsUnparseable() bool {
+	_, ok := s.Headers[DeadLetterBodyHeader]
+	return ok
+}
+
+// DeadLetterBody returns the raw message wrapped by an unparseable dead letter
+func (s *Signature) DeadLetterBody() ([]byte, error) {
+	body, ok := s.Headers[DeadLetterBodyHeader].(string)
+	if !ok {
+		return nil, fmt.Errorf("Dead letter %s does not hold a raw message", s.UUID)
+	}
+	return base64.StdEncoding.DecodeString(body)
+}
+
+// ReplayDeadLetter strips failure metadata from a dead letter so that it can be sent again.
+// The task is routed back to its original queue and gets a fresh retry budget.
+func ReplayDeadLetter(deadLetter *Signature) *Signature {
+	signature := CopySignature(deadLetter)
+	if queue, ok := signature.Headers[DeadLetterQueueHeader].(string); ok {
+		signature.RoutingKey = queue
+	}
+	for _, header := range deadLetterHeaders {
+		delete(signature.Headers, header)
+	}
+	signature.RetryCount += signature.RetryAttempt
+	signature.RetryAttempt = 0
+	return signature
+}
@@ -26,6 +26,18 @@ func NewErrRetryTaskLater(msg string, retryIn time.Duration) ErrRetryTaskLater {
 	return ErrRetryTaskLater{msg: msg, retryIn: retryIn}
 }
 
+// ErrDuplicateTask is returned when sending a task while a task with the same unique key is pending or running
+type ErrDuplicateTask struct {
+	UniqueKey string
+	// UUID of the pending or running task
+	UUID string
+}
+
+// Error implements the error interface
+func (e ErrDuplicateTask) Error() string {
+	return fmt.Sprintf("Task %s with unique key %s is already pending or running", e.UUID, e.UniqueKey)
+}
+
 // Retriable is interface that retriable errors should implement
 type Retriable interface {
 	RetryIn() time.Duration
@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"math"
 	"reflect"
 	"strconv"
 	"strings"
@@ -69,6 +70,12 @@ func (e ErrUnsupportedType) Error() string {
 
 // ReflectValue converts interface{} to reflect.Value based on string type
 func ReflectValue(valueType string, value interface{}) (reflect.Value, error) {
+	if _, ok := typesMap[valueType]; !ok {
+		if theType, ok := RegisteredType(valueType); ok {
+			return reflectRegisteredValue(theType, value)
+		}
+	}
+
 	if strings.HasPrefix(valueType, "[]") {
 		return reflectValues(valueType, value)
 	}
@@ -280,12 +287,18 @@ func getIntValue(theType string, value interface{}) (int64, error) {
 		return n.Int64()
 	}
 
-	n, ok := value.(int64)
-	if !ok {
-		return 0, typeConversionError(value, typesMap[theType].String())
+	// Values which have not been through JSON keep their own integer type, other codecs
+	// decode non-negative integers as unsigned
+	switch n := reflect.ValueOf(value); n.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return n.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n.Uint() <= math.MaxInt64 {
+			return int64(n.Uint()), nil
+		}
 	}
 
-	return n, nil
+	return 0, typeConversionError(value, typesMap[theType].String())
 }
 
 func getUintValue(theType string, value interface{}) (uint64, error) {
@@ -306,16 +319,16 @@ func getUintValue(theType string, value interface{}) (uint64, error) {
 		return uintVal, nil
 	}
 
-	var n uint64
-	switch value := value.(type) {
-	case uint64:
-		n = value
-	case uint8:
-		n = uint64(value)
-	default:
-		return 0, typeConversionError(value, typesMap[theType].String())
+	switch n := reflect.ValueOf(value); n.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return n.Uint(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n.Int() >= 0 {
+			return uint64(n.Int()), nil
+		}
 	}
-	return n, nil
+
+	return 0, typeConversionError(value, typesMap[theType].String())
 }
 
 func getFloatValue(theType string, value interface{}) (float64, error) {
@@ -330,12 +343,17 @@ func getFloatValue(theType string, value interface{}) (float64, error) {
 		return n.Float64()
 	}
 
-	f, ok := value.(float64)
-	if !ok {
-		return 0, typeConversionError(value, typesMap[theType].String())
+	// Other codecs may decode floats as float32 and whole numbers as integers
+	switch n := reflect.ValueOf(value); n.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return n.Float(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(n.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(n.Uint()), nil
 	}
 
-	return f, nil
+	return 0, typeConversionError(value, typesMap[theType].String())
 }
 
 func getStringValue(theType string, value interface{}) (string, error) {
@@ -1,21 +1,128 @@
 package tasks
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"strings"
+
+	"github.com/oarkflow/machinery/compression"
 )
 
 // TaskResult represents an actual return value of a processed task
 type TaskResult struct {
 	Type  string      `bson:"type"`
 	Value interface{} `bson:"value"`
+	// Encoding is the compression of a compressed Value, which holds the compressed JSON
+	// encoded value then
+	Encoding string `bson:"encoding,omitempty" json:",omitempty"`
+	// KeyID is the key which encrypted an encrypted Value, see the security package
+	KeyID string `bson:"key_id,omitempty" json:",omitempty"`
+	// Ref is the key of the blob keeping the value of an oversized result, which has no Value
+	// then, see the claimcheck package
+	Ref string `bson:"ref,omitempty" json:",omitempty"`
+}
+
+// IsEncoded returns true if the value of the result is compressed or encrypted
+func (r *TaskResult) IsEncoded() bool {
+	return r.Encoding != "" || r.KeyID != ""
+}
+
+// EncodedValue returns the compressed or encrypted value of the result. Backends which
+// store results as JSON return it as a base64 string.
+func (r *TaskResult) EncodedValue() ([]byte, error) {
+	switch value := r.Value.(type) {
+	case []byte:
+		return value, nil
+	case string:
+		return base64.StdEncoding.DecodeString(value)
+	}
+	return nil, typeConversionError(r.Value, "[]byte")
+}
+
+// Compress returns a copy of the result with its JSON encoded value compressed by compress,
+// which returns the content encoding of the data, or the result itself if it was not compressed
+func (r *TaskResult) Compress(compress func(data []byte) ([]byte, string, error)) (*TaskResult, error) {
+	if r.IsEncoded() || r.Ref != "" {
+		return r, nil
+	}
+
+	encoded, err := json.Marshal(r.Value)
+	if err != nil {
+		return nil, fmt.Errorf("JSON marshal error: %s", err)
+	}
+
+	compressed, encoding, err := compress(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if encoding == "" {
+		return r, nil
+	}
+	return &TaskResult{Type: r.Type, Value: compressed, Encoding: encoding}, nil
+}
+
+// Decompress replaces a compressed value of the result with the decoded value. The value
+// decodes like the one of an uncompressed result stored as JSON. A value decompressing to more
+// than maxSize bytes is rejected if maxSize is positive.
+func (r *TaskResult) Decompress(maxSize int) error {
+	if err := r.checkDecrypted(); err != nil {
+		return err
+	}
+	if r.Encoding == "" {
+		return nil
+	}
+
+	compressed, err := r.EncodedValue()
+	if err != nil {
+		return err
+	}
+
+	compressor, err := compression.ForContentEncoding(r.Encoding)
+	if err != nil {
+		return err
+	}
+	encoded, err := compressor.Decompress(compressed, maxSize)
+	if err != nil {
+		return fmt.Errorf("Decompression error: %s", err)
+	}
+
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("JSON unmarshal error: %s", err)
+	}
+
+	r.Value = value
+	r.Encoding = ""
+	return nil
+}
+
+// checkDecrypted returns an error if the value of the result is kept in the blob store or encrypted
+func (r *TaskResult) checkDecrypted() error {
+	if r.Ref != "" {
+		return fmt.Errorf("Result is kept in the blob store under %s", r.Ref)
+	}
+	if r.KeyID != "" {
+		return fmt.Errorf("Result is encrypted with key %s", r.KeyID)
+	}
+	return nil
 }
 
 // ReflectTaskResults ...
 func ReflectTaskResults(taskResults []*TaskResult) ([]reflect.Value, error) {
 	resultValues := make([]reflect.Value, len(taskResults))
 	for i, taskResult := range taskResults {
+		// Results get decompressed with the maximum size of the config when read from the backend
+		if err := taskResult.checkDecrypted(); err != nil {
+			return nil, err
+		}
+		if taskResult.Encoding != "" {
+			return nil, fmt.Errorf("Result is compressed with %s", taskResult.Encoding)
+		}
 		resultValue, err := ReflectValue(taskResult.Type, taskResult.Value)
 		if err != nil {
 			return nil, err
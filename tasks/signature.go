@@ -16,6 +16,13 @@ type Arg struct {
 	Value interface{} `bson:"value"`
 }
 
+// PublishedAtHeader holds the time the task was last published at in RFC3339Nano format
+const PublishedAtHeader = "published_at"
+
+// DeferralsHeader counts the times the message has been put off because its task was
+// saturated or rate limited. It is removed once the task runs.
+const DeferralsHeader = "deferrals"
+
 // Headers represents the headers which should be used to direct the task
 type Headers map[string]interface{}
 
@@ -70,6 +77,30 @@ type Signature struct {
 	// IgnoreWhenTaskNotRegistered auto removes the request when there is no handeler available
 	// When this is true a task with no handler will be ignored and not placed back in the queue
 	IgnoreWhenTaskNotRegistered bool
+	// SoftTimeLimit cancels the context passed to the task once it elapses
+	SoftTimeLimit time.Duration
+	// HardTimeLimit abandons the task once it elapses and fails it with ErrTaskTimeLimitExceeded
+	HardTimeLimit time.Duration
+	// RetryPolicy names a policy registered with retry.RegisterPolicy used to delay retries.
+	// When empty, the policy of the registered task or Fibonacci on RetryTimeout is used.
+	RetryPolicy string
+	// RetryAttempt counts retries of the task made so far
+	RetryAttempt int
+	// UniqueKey makes the task unique, sending another task with the same key while
+	// this one is pending or running fails with ErrDuplicateTask
+	UniqueKey string
+	// UniqueTTL releases the unique key if the task has not completed by then,
+	// counted from the ETA of a delayed task and renewed when the task is retried.
+	// When zero, the results expiration of the config is used.
+	UniqueTTL time.Duration
+	// CollapseDuplicate makes sending a duplicate of a unique task return the
+	// result of the pending or running task instead of ErrDuplicateTask
+	CollapseDuplicate bool
+	// WorkflowNode is the name of the node of a workflow the task runs as
+	WorkflowNode string
+	// WorkflowUUID is the UUID of the workflow the task is a node of, set by Workflow.NodeSignature.
+	// The worker loads the definition of the workflow from the result backend by it.
+	WorkflowUUID string
 }
 
 // NewSignature creates a new task signature
@@ -1,6 +1,11 @@
 package tasks
 
-import "time"
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
 
 const (
 	// StatePending - initial state of a task
@@ -15,6 +20,8 @@ const (
 	StateSuccess = "SUCCESS"
 	// StateFailure - when processing of the task fails
 	StateFailure = "FAILURE"
+	// StateRevoked - when the task has been revoked and must not be processed
+	StateRevoked = "REVOKED"
 )
 
 // TaskState represents a state of a task
@@ -26,6 +33,14 @@ type TaskState struct {
 	Error     string        `bson:"error"`
 	CreatedAt time.Time     `bson:"created_at"`
 	TTL       int64         `bson:"ttl,omitempty"`
+	Progress  *TaskProgress `bson:"progress,omitempty"`
+}
+
+// TaskProgress represents the progress reported by a running task
+type TaskProgress struct {
+	Percent   float64                `bson:"percent"`
+	Metadata  map[string]interface{} `bson:"metadata,omitempty"`
+	UpdatedAt time.Time              `bson:"updated_at"`
 }
 
 // GroupMeta stores useful metadata about tasks within the same group
@@ -40,6 +55,120 @@ type GroupMeta struct {
 	TTL            int64     `bson:"ttl,omitempty"`
 }
 
+// WorkflowMeta stores the definition of a workflow, the signatures of its nodes only carry
+// the UUID of the workflow
+type WorkflowMeta struct {
+	WorkflowUUID string `bson:"_id"`
+	// Workflow is the JSON encoded workflow
+	Workflow  []byte    `bson:"workflow"`
+	CreatedAt time.Time `bson:"created_at"`
+	TTL       int64     `bson:"ttl,omitempty"`
+}
+
+// NewWorkflowMeta creates WorkflowMeta instance with the encoded workflow
+func NewWorkflowMeta(workflow *Workflow) (*WorkflowMeta, error) {
+	encoded, err := json.Marshal(workflow)
+	if err != nil {
+		return nil, fmt.Errorf("JSON marshal error: %s", err)
+	}
+	return &WorkflowMeta{
+		WorkflowUUID: workflow.WorkflowUUID,
+		Workflow:     encoded,
+		CreatedAt:    time.Now().UTC(),
+	}, nil
+}
+
+// DecodeWorkflow decodes a JSON encoded workflow, numbers in the arguments of its nodes are
+// decoded as json.Number like the ones of signatures decoded from JSON messages
+func DecodeWorkflow(data []byte) (*Workflow, error) {
+	workflow := new(Workflow)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(workflow); err != nil {
+		return nil, fmt.Errorf("JSON unmarshal error: %s", err)
+	}
+	return workflow, nil
+}
+
+// WorkflowState represents the state of a workflow, which is derived from the states of its nodes
+type WorkflowState struct {
+	WorkflowUUID string
+	State        string
+	// Nodes maps the names of the nodes of the workflow to their states
+	Nodes map[string]*TaskState
+}
+
+// NewWorkflowState derives the state of the workflow from the states of its nodes. The workflow
+// failed or got revoked as soon as one of its nodes did, and it succeeded once all of them did.
+func NewWorkflowState(workflow *Workflow, taskStates []*TaskState) *WorkflowState {
+	taskStatesByUUID := make(map[string]*TaskState, len(taskStates))
+	for _, taskState := range taskStates {
+		if taskState != nil {
+			taskStatesByUUID[taskState.TaskUUID] = taskState
+		}
+	}
+
+	workflowState := &WorkflowState{
+		WorkflowUUID: workflow.WorkflowUUID,
+		Nodes:        make(map[string]*TaskState, len(workflow.Nodes)),
+	}
+
+	var pending, succeeded, failed, revoked int
+	for _, name := range workflow.NodeNames {
+		node := workflow.Nodes[name]
+		taskState, ok := taskStatesByUUID[node.UUID]
+		if !ok {
+			taskState = NewPendingTaskState(node)
+		}
+		workflowState.Nodes[name] = taskState
+
+		switch {
+		case taskState.IsFailure():
+			failed++
+		case taskState.IsRevoked():
+			revoked++
+		case taskState.IsSuccess():
+			succeeded++
+		case taskState.State == StatePending:
+			pending++
+		}
+	}
+
+	switch {
+	case failed > 0:
+		workflowState.State = StateFailure
+	case revoked > 0:
+		workflowState.State = StateRevoked
+	case succeeded == len(workflow.NodeNames):
+		workflowState.State = StateSuccess
+	case pending == len(workflow.NodeNames):
+		workflowState.State = StatePending
+	default:
+		workflowState.State = StateStarted
+	}
+	return workflowState
+}
+
+// IsCompleted returns true if the workflow succeeded, failed or got revoked
+func (workflowState *WorkflowState) IsCompleted() bool {
+	return workflowState.IsSuccess() || workflowState.IsFailure() || workflowState.IsRevoked()
+}
+
+// IsSuccess returns true if all nodes of the workflow succeeded
+func (workflowState *WorkflowState) IsSuccess() bool {
+	return workflowState.State == StateSuccess
+}
+
+// IsFailure returns true if a node of the workflow failed
+func (workflowState *WorkflowState) IsFailure() bool {
+	return workflowState.State == StateFailure
+}
+
+// IsRevoked returns true if a node of the workflow got revoked
+func (workflowState *WorkflowState) IsRevoked() bool {
+	return workflowState.State == StateRevoked
+}
+
 // NewPendingTaskState ...
 func NewPendingTaskState(signature *Signature) *TaskState {
 	return &TaskState{
@@ -92,10 +221,19 @@ func NewRetryTaskState(signature *Signature) *TaskState {
 	}
 }
 
-// IsCompleted returns true if state is SUCCESS or FAILURE,
-// i.e. the task has finished processing and either succeeded or failed.
+// NewRevokedTaskState ...
+func NewRevokedTaskState(signature *Signature) *TaskState {
+	return &TaskState{
+		TaskUUID: signature.UUID,
+		State:    StateRevoked,
+	}
+}
+
+// IsCompleted returns true if state is SUCCESS, FAILURE or REVOKED,
+// i.e. the task has finished processing and either succeeded or failed,
+// or it will never be processed.
 func (taskState *TaskState) IsCompleted() bool {
-	return taskState.IsSuccess() || taskState.IsFailure()
+	return taskState.IsSuccess() || taskState.IsFailure() || taskState.IsRevoked()
 }
 
 // IsSuccess returns true if state is SUCCESS
@@ -107,3 +245,8 @@ func (taskState *TaskState) IsSuccess() bool {
 func (taskState *TaskState) IsFailure() bool {
 	return taskState.State == StateFailure
 }
+
+// IsRevoked returns true if state is REVOKED
+func (taskState *TaskState) IsRevoked() bool {
+	return taskState.State == StateRevoked
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"reflect"
 	"runtime/debug"
+	"time"
 
 	"github.com/opentracing/opentracing-go"
 	opentracing_ext "github.com/opentracing/opentracing-go/ext"
@@ -14,16 +15,22 @@ import (
 	"github.com/oarkflow/machinery/log"
 )
 
-// ErrTaskPanicked ...
-var ErrTaskPanicked = errors.New("Invoking task caused a panic")
+var (
+	// ErrTaskPanicked ...
+	ErrTaskPanicked = errors.New("Invoking task caused a panic")
+	// ErrTaskTimeLimitExceeded ...
+	ErrTaskTimeLimitExceeded = errors.New("Task exceeded its hard time limit")
+)
 
 // Task wraps a signature and methods used to reflect task arguments and
 // return values after invoking the task
 type Task struct {
-	TaskFunc   reflect.Value
-	UseContext bool
-	Context    context.Context
-	Args       []reflect.Value
+	TaskFunc      reflect.Value
+	UseContext    bool
+	Context       context.Context
+	Args          []reflect.Value
+	SoftTimeLimit time.Duration
+	HardTimeLimit time.Duration
 }
 
 type signatureCtxType struct{}
@@ -45,14 +52,58 @@ func SignatureFromContext(ctx context.Context) *Signature {
 	return signature
 }
 
+type progressCtxType struct{}
+
+var progressCtx progressCtxType
+
+// Progress is a handle used by a running task to report its progress
+type Progress struct {
+	report func(progress *TaskProgress) error
+}
+
+// NewProgress creates Progress instance publishing reports with the given function
+func NewProgress(report func(progress *TaskProgress) error) *Progress {
+	return &Progress{report: report}
+}
+
+// Report publishes the progress percentage of the task with arbitrary metadata.
+// Reporting on a nil Progress is a no-op, so tasks can be called outside a worker.
+func (p *Progress) Report(percent float64, metadata map[string]interface{}) error {
+	if p == nil || p.report == nil {
+		return nil
+	}
+	return p.report(&TaskProgress{
+		Percent:   percent,
+		Metadata:  metadata,
+		UpdatedAt: time.Now().UTC(),
+	})
+}
+
+// ContextWithProgress returns a copy of the context carrying the progress handle
+func ContextWithProgress(ctx context.Context, progress *Progress) context.Context {
+	return context.WithValue(ctx, progressCtx, progress)
+}
+
+// ProgressFromContext gets the progress handle from the context
+func ProgressFromContext(ctx context.Context) *Progress {
+	if ctx == nil {
+		return nil
+	}
+
+	progress, _ := ctx.Value(progressCtx).(*Progress)
+	return progress
+}
+
 // NewWithSignature is the same as New but injects the signature
 func NewWithSignature(taskFunc interface{}, signature *Signature) (*Task, error) {
 	args := signature.Args
 	ctx := context.Background()
 	ctx = context.WithValue(ctx, signatureCtx, signature)
 	task := &Task{
-		TaskFunc: reflect.ValueOf(taskFunc),
-		Context:  ctx,
+		TaskFunc:      reflect.ValueOf(taskFunc),
+		Context:       ctx,
+		SoftTimeLimit: signature.SoftTimeLimit,
+		HardTimeLimit: signature.HardTimeLimit,
 	}
 
 	taskFuncType := reflect.TypeOf(taskFunc)
@@ -95,16 +146,54 @@ func New(taskFunc interface{}, args []Arg) (*Task, error) {
 
 // Call attempts to call the task with the supplied arguments.
 //
-// `err` is set in the return value in two cases:
+// `err` is set in the return value in three cases:
 //  1. The reflected function invocation panics (e.g. due to a mismatched
 //     argument list).
 //  2. The task func itself returns a non-nil error.
+//  3. The task does not return within its hard time limit.
+//
+// The task context is cancelled once the soft time limit elapses.
 func (t *Task) Call() (taskResults []*TaskResult, err error) {
-	// retrieve the span from the task's context and finish it as soon as this function returns
-	if span := opentracing.SpanFromContext(t.Context); span != nil {
-		defer span.Finish()
+	if t.SoftTimeLimit > 0 {
+		ctx, cancel := context.WithTimeout(t.Context, t.SoftTimeLimit)
+		defer cancel()
+		t.Context = ctx
+	}
+
+	if t.HardTimeLimit <= 0 {
+		return t.call()
+	}
+
+	// Abandoning the call cancels the task context as well
+	ctx, cancel := context.WithCancel(t.Context)
+	defer cancel()
+	t.Context = ctx
+
+	type callResult struct {
+		taskResults []*TaskResult
+		err         error
+	}
+
+	resultChan := make(chan callResult, 1)
+	go func() {
+		taskResults, err := t.call()
+		resultChan <- callResult{taskResults: taskResults, err: err}
+	}()
+
+	timer := time.NewTimer(t.HardTimeLimit)
+	defer timer.Stop()
+
+	select {
+	case result := <-resultChan:
+		return result.taskResults, result.err
+	case <-timer.C:
+		log.ERROR.Printf("Task exceeded its hard time limit of %s", t.HardTimeLimit)
+		return nil, ErrTaskTimeLimitExceeded
 	}
+}
 
+// call invokes the task func and converts its return values to task results
+func (t *Task) call() (taskResults []*TaskResult, err error) {
 	defer func() {
 		// Recover from panic and set err.
 		if e := recover(); e != nil {
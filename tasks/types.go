@@ -0,0 +1,111 @@
+package tasks
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	registeredTypes   = map[string]reflect.Type{}
+	registeredTypesMu sync.RWMutex
+)
+
+func init() {
+	RegisterType[time.Time]()
+	RegisterType[time.Duration]()
+}
+
+// RegisterType allows values of type T, such as structs and maps, to be used as task
+// arguments and results. The type is identified by its name as given by reflect, e.g.
+// "main.Invoice" or "map[string]int", which is also the name to use as Arg.Type.
+// Pointers to and slices of a registered type are supported as well. The values are
+// encoded as JSON by brokers and backends, except MongoDB which stores them as BSON.
+// time.Time and time.Duration are registered by default and the basic types supported
+// already are ignored. Names only include the last element of the package path, so an
+// error is returned if a different type has been registered under the same name.
+func RegisterType[T any]() error {
+	theType := reflect.TypeOf((*T)(nil)).Elem()
+	if _, ok := typesMap[theType.String()]; ok {
+		return nil
+	}
+
+	registeredTypesMu.Lock()
+	defer registeredTypesMu.Unlock()
+	if registered, ok := registeredType(theType.String()); ok && registered != theType {
+		return fmt.Errorf("Type %s of package %s is already registered from package %s", theType, typePkgPath(theType), typePkgPath(registered))
+	}
+	registeredTypes[theType.String()] = theType
+	return nil
+}
+
+// typePkgPath returns the path of the package declaring the type, or of its element type
+func typePkgPath(theType reflect.Type) string {
+	for theType.Name() == "" {
+		switch theType.Kind() {
+		case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map, reflect.Chan:
+			theType = theType.Elem()
+		default:
+			return ""
+		}
+	}
+	return theType.PkgPath()
+}
+
+// TypeName returns the name identifying type T in Arg.Type and TaskResult.Type
+func TypeName[T any]() string {
+	return reflect.TypeOf((*T)(nil)).Elem().String()
+}
+
+// RegisteredType returns the registered type with the given name, resolving pointers
+// to and slices of registered types
+func RegisteredType(valueType string) (reflect.Type, bool) {
+	registeredTypesMu.RLock()
+	defer registeredTypesMu.RUnlock()
+	return registeredType(valueType)
+}
+
+// registeredType is RegisteredType for callers holding registeredTypesMu
+func registeredType(valueType string) (reflect.Type, bool) {
+	if theType, ok := registeredTypes[valueType]; ok {
+		return theType, true
+	}
+
+	if strings.HasPrefix(valueType, "*") {
+		if elemType, ok := registeredType(strings.TrimPrefix(valueType, "*")); ok {
+			return reflect.PointerTo(elemType), true
+		}
+	}
+	if strings.HasPrefix(valueType, "[]") {
+		if elemType, ok := registeredType(strings.TrimPrefix(valueType, "[]")); ok {
+			return reflect.SliceOf(elemType), true
+		}
+	}
+
+	return nil, false
+}
+
+// reflectRegisteredValue converts interface{} to reflect.Value of a registered type.
+// Values which have been through a broker or backend are decoded from their JSON form.
+func reflectRegisteredValue(theType reflect.Type, value interface{}) (reflect.Value, error) {
+	if value != nil && reflect.TypeOf(value).AssignableTo(theType) {
+		theValue := reflect.New(theType).Elem()
+		theValue.Set(reflect.ValueOf(value))
+		return theValue, nil
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return reflect.Value{}, fmt.Errorf("JSON marshal error: %s", err)
+	}
+
+	theValue := reflect.New(theType)
+	if err := json.Unmarshal(encoded, theValue.Interface()); err != nil {
+		return reflect.Value{}, fmt.Errorf("JSON unmarshal error: %s", err)
+	}
+
+	return theValue.Elem(), nil
+}
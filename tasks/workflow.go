@@ -1,6 +1,7 @@
 package tasks
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/google/uuid"
@@ -93,3 +94,151 @@ func NewChord(group *Group, callback *Signature) (*Chord, error) {
 
 	return &Chord{Group: group, Callback: callback}, nil
 }
+
+// Workflow runs tasks in the order of the dependencies between them, which form a directed
+// acyclic graph. Its nodes are named tasks and an edge from one node to another makes the
+// latter run once the former succeeded. A node with several dependencies runs once all of
+// them succeeded. Results of the dependencies are appended to the arguments of a node, in
+// the order the edges were added, unless its signature is immutable. The server keeps the
+// workflow in the result backend when sending it, its nodes only carry its UUID.
+type Workflow struct {
+	WorkflowUUID string
+	Nodes        map[string]*Signature
+	// Dependencies maps the names of nodes to the names of the nodes they depend on
+	Dependencies map[string][]string
+	// NodeNames lists the names of the nodes in the order they were added
+	NodeNames []string
+}
+
+// NewWorkflow creates a new workflow without nodes, see AddNode and AddEdge
+func NewWorkflow() *Workflow {
+	workflowUUID := uuid.New().String()
+	return &Workflow{
+		WorkflowUUID: fmt.Sprintf("workflow_%v", workflowUUID),
+		Nodes:        make(map[string]*Signature),
+		Dependencies: make(map[string][]string),
+	}
+}
+
+// AddNode adds a task to the workflow as the node of the name
+func (workflow *Workflow) AddNode(name string, signature *Signature) error {
+	if name == "" {
+		return errors.New("Workflow node requires a name")
+	}
+	if _, ok := workflow.Nodes[name]; ok {
+		return fmt.Errorf("Workflow node %s already exists", name)
+	}
+
+	// Auto generate a task UUID if needed
+	if signature.UUID == "" {
+		signatureID := uuid.New().String()
+		signature.UUID = fmt.Sprintf("task_%v", signatureID)
+	}
+	signature.WorkflowNode = name
+
+	workflow.Nodes[name] = signature
+	workflow.NodeNames = append(workflow.NodeNames, name)
+	return nil
+}
+
+// AddEdge makes the node to run after the node from succeeded
+func (workflow *Workflow) AddEdge(from, to string) error {
+	for _, name := range []string{from, to} {
+		if _, ok := workflow.Nodes[name]; !ok {
+			return fmt.Errorf("Workflow node %s does not exist", name)
+		}
+	}
+	for _, dependency := range workflow.Dependencies[to] {
+		if dependency == from {
+			return fmt.Errorf("Workflow edge from %s to %s already exists", from, to)
+		}
+	}
+	if from == to || workflow.dependsOn(from, to) {
+		return fmt.Errorf("Workflow edge from %s to %s would create a cycle", from, to)
+	}
+
+	workflow.Dependencies[to] = append(workflow.Dependencies[to], from)
+	return nil
+}
+
+// dependsOn returns true if the node depends on the other node, directly or indirectly
+func (workflow *Workflow) dependsOn(name, other string) bool {
+	for _, dependency := range workflow.Dependencies[name] {
+		if dependency == other || workflow.dependsOn(dependency, other) {
+			return true
+		}
+	}
+	return false
+}
+
+// Roots returns the names of the nodes which depend on no other node, they run first
+func (workflow *Workflow) Roots() []string {
+	var roots []string
+	for _, name := range workflow.NodeNames {
+		if len(workflow.Dependencies[name]) == 0 {
+			roots = append(roots, name)
+		}
+	}
+	return roots
+}
+
+// Leaves returns the names of the nodes no other node depends on, they run last
+func (workflow *Workflow) Leaves() []string {
+	var leaves []string
+	for _, name := range workflow.NodeNames {
+		if len(workflow.Dependents(name)) == 0 {
+			leaves = append(leaves, name)
+		}
+	}
+	return leaves
+}
+
+// Dependents returns the names of the nodes which depend on the node
+func (workflow *Workflow) Dependents(name string) []string {
+	var dependents []string
+	for _, node := range workflow.NodeNames {
+		for _, dependency := range workflow.Dependencies[node] {
+			if dependency == name {
+				dependents = append(dependents, node)
+				break
+			}
+		}
+	}
+	return dependents
+}
+
+// NodeSignature returns a copy of the signature of the node which carries the UUID of the workflow,
+// so that the worker processing it can load the workflow and trigger the nodes depending on it
+func (workflow *Workflow) NodeSignature(name string) *Signature {
+	node, ok := workflow.Nodes[name]
+	if !ok {
+		return nil
+	}
+
+	signature := *node
+	signature.Args = append([]Arg(nil), node.Args...)
+	if node.Headers != nil {
+		signature.Headers = make(Headers, len(node.Headers))
+		for key, value := range node.Headers {
+			signature.Headers[key] = value
+		}
+	}
+	signature.WorkflowUUID = workflow.WorkflowUUID
+	return &signature
+}
+
+// NodeGroupUUID returns the UUID of the group of the dependencies of the node, it is used to
+// trigger a node depending on several nodes once all of them succeeded
+func (workflow *Workflow) NodeGroupUUID(name string) string {
+	return fmt.Sprintf("%s_%s", workflow.WorkflowUUID, name)
+}
+
+// GetDependencyUUIDs returns the task UUIDs of the nodes the node depends on
+func (workflow *Workflow) GetDependencyUUIDs(name string) []string {
+	dependencies := workflow.Dependencies[name]
+	taskUUIDs := make([]string, len(dependencies))
+	for i, dependency := range dependencies {
+		taskUUIDs[i] = workflow.Nodes[dependency].UUID
+	}
+	return taskUUIDs
+}
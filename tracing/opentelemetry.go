@@ -0,0 +1,129 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/oarkflow/machinery/tasks"
+)
+
+// instrumentationName identifies spans created by machinery
+const instrumentationName = "github.com/oarkflow/machinery"
+
+// OpenTelemetry is a Tracer propagating the trace context in the W3C traceparent header
+type OpenTelemetry struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// NewOpenTelemetry creates new OpenTelemetry instance. When nil, the provider defaults
+// to the global one and the propagator to W3C trace context and baggage.
+func NewOpenTelemetry(provider trace.TracerProvider, propagator propagation.TextMapPropagator) *OpenTelemetry {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	if propagator == nil {
+		propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+	}
+	return &OpenTelemetry{
+		tracer:     provider.Tracer(instrumentationName),
+		propagator: propagator,
+	}
+}
+
+// StartProducerSpan starts a producer span
+func (t *OpenTelemetry) StartProducerSpan(ctx context.Context, operationName string, headers tasks.Headers) (context.Context, Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() && headers != nil {
+		ctx = t.propagator.Extract(ctx, headersCarrier(headers))
+	}
+
+	ctx, span := t.tracer.Start(ctx, operationName,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("component", "machinery")),
+	)
+	return ctx, openTelemetrySpan{span}
+}
+
+// StartConsumerSpan starts a consumer span as a child of the producer span found in the headers
+func (t *OpenTelemetry) StartConsumerSpan(ctx context.Context, operationName string, headers tasks.Headers) (context.Context, Span) {
+	ctx = t.propagator.Extract(ctx, headersCarrier(headers))
+
+	ctx, span := t.tracer.Start(ctx, operationName,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attribute.String("component", "machinery")),
+	)
+	return ctx, openTelemetrySpan{span}
+}
+
+// Inject propagates the trace context of ctx in the headers
+func (t *OpenTelemetry) Inject(ctx context.Context, headers tasks.Headers) tasks.Headers {
+	if headers == nil {
+		headers = make(tasks.Headers)
+	}
+	t.propagator.Inject(ctx, headersCarrier(headers))
+	return headers
+}
+
+type openTelemetrySpan struct {
+	span trace.Span
+}
+
+// SetTag sets an attribute on the span
+func (s openTelemetrySpan) SetTag(key string, value interface{}) {
+	switch value := value.(type) {
+	case string:
+		s.span.SetAttributes(attribute.String(key, value))
+	case bool:
+		s.span.SetAttributes(attribute.Bool(key, value))
+	case int:
+		s.span.SetAttributes(attribute.Int(key, value))
+	case int64:
+		s.span.SetAttributes(attribute.Int64(key, value))
+	case float64:
+		s.span.SetAttributes(attribute.Float64(key, value))
+	case []string:
+		s.span.SetAttributes(attribute.StringSlice(key, value))
+	default:
+		s.span.SetAttributes(attribute.String(key, fmt.Sprint(value)))
+	}
+}
+
+// SetError records the error and marks the span as failed
+func (s openTelemetrySpan) SetError(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// Finish ends the span
+func (s openTelemetrySpan) Finish() {
+	s.span.End()
+}
+
+// headersCarrier adapts signature headers to propagation.TextMapCarrier
+type headersCarrier tasks.Headers
+
+// Get returns the header value if it is a string
+func (c headersCarrier) Get(key string) string {
+	value, _ := c[key].(string)
+	return value
+}
+
+// Set sets the header
+func (c headersCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+// Keys lists the header keys
+func (c headersCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,159 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/oarkflow/machinery/tasks"
+)
+
+// WorkflowTagKey tags producer spans of chains, groups and chords
+const WorkflowTagKey = "machinery.workflow"
+
+// Span is a unit of work traced by a Tracer
+type Span interface {
+	SetTag(key string, value interface{})
+	SetError(err error)
+	Finish()
+}
+
+// Tracer creates spans for publishing and processing of tasks and propagates
+// the trace context through the signature headers
+type Tracer interface {
+	// StartProducerSpan starts a span for publishing a task or a workflow. The span continues
+	// the trace of the context, or the trace propagated in the headers if the context has none
+	StartProducerSpan(ctx context.Context, operationName string, headers tasks.Headers) (context.Context, Span)
+	// StartConsumerSpan starts a span for processing a task which continues the trace propagated in the headers
+	StartConsumerSpan(ctx context.Context, operationName string, headers tasks.Headers) (context.Context, Span)
+	// Inject propagates the trace context of ctx in the headers
+	Inject(ctx context.Context, headers tasks.Headers) tasks.Headers
+}
+
+// NewMultiTracer returns a Tracer which traces with all the tracers, e.g. both OpenTracing
+// and OpenTelemetry while migrating services from one to the other
+func NewMultiTracer(tracers ...Tracer) Tracer {
+	return multiTracer(tracers)
+}
+
+type multiTracer []Tracer
+
+// StartProducerSpan starts a producer span with every tracer
+func (t multiTracer) StartProducerSpan(ctx context.Context, operationName string, headers tasks.Headers) (context.Context, Span) {
+	spans := make(multiSpan, len(t))
+	for i, tracer := range t {
+		ctx, spans[i] = tracer.StartProducerSpan(ctx, operationName, headers)
+	}
+	return ctx, spans
+}
+
+// StartConsumerSpan starts a consumer span with every tracer
+func (t multiTracer) StartConsumerSpan(ctx context.Context, operationName string, headers tasks.Headers) (context.Context, Span) {
+	spans := make(multiSpan, len(t))
+	for i, tracer := range t {
+		ctx, spans[i] = tracer.StartConsumerSpan(ctx, operationName, headers)
+	}
+	return ctx, spans
+}
+
+// Inject propagates the trace context of every tracer
+func (t multiTracer) Inject(ctx context.Context, headers tasks.Headers) tasks.Headers {
+	for _, tracer := range t {
+		headers = tracer.Inject(ctx, headers)
+	}
+	return headers
+}
+
+type multiSpan []Span
+
+// SetTag tags all the spans
+func (s multiSpan) SetTag(key string, value interface{}) {
+	for _, span := range s {
+		span.SetTag(key, value)
+	}
+}
+
+// SetError marks all the spans as failed
+func (s multiSpan) SetError(err error) {
+	for _, span := range s {
+		span.SetError(err)
+	}
+}
+
+// Finish finishes all the spans
+func (s multiSpan) Finish() {
+	for _, span := range s {
+		span.Finish()
+	}
+}
+
+// AnnotateSignature tags the span with some info about the signature
+func AnnotateSignature(span Span, signature *tasks.Signature) {
+	span.SetTag("signature.name", signature.Name)
+	span.SetTag("signature.uuid", signature.UUID)
+
+	if signature.GroupUUID != "" {
+		span.SetTag("signature.group.uuid", signature.GroupUUID)
+	}
+
+	if signature.ChordCallback != nil {
+		span.SetTag("signature.chord.callback.uuid", signature.ChordCallback.UUID)
+		span.SetTag("signature.chord.callback.name", signature.ChordCallback.Name)
+	}
+
+	if signature.WorkflowUUID != "" {
+		span.SetTag("signature.workflow.uuid", signature.WorkflowUUID)
+		span.SetTag("signature.workflow.node", signature.WorkflowNode)
+	}
+}
+
+// AnnotateChain tags the span with some info about the chain and propagates
+// the trace context of ctx to all tasks of the chain
+func AnnotateChain(ctx context.Context, tracer Tracer, span Span, chain *tasks.Chain) {
+	span.SetTag(WorkflowTagKey, "chain")
+	span.SetTag("chain.tasks.length", len(chain.Tasks))
+
+	for _, signature := range chain.Tasks {
+		signature.Headers = tracer.Inject(ctx, signature.Headers)
+	}
+}
+
+// AnnotateGroup tags the span with some info about the group and propagates
+// the trace context of ctx to all tasks of the group
+func AnnotateGroup(ctx context.Context, tracer Tracer, span Span, group *tasks.Group, sendConcurrency int) {
+	span.SetTag(WorkflowTagKey, "group")
+	span.SetTag("group.uuid", group.GroupUUID)
+	span.SetTag("group.tasks.length", len(group.Tasks))
+	span.SetTag("group.concurrency", sendConcurrency)
+
+	// encode the task uuids to json, if that fails just dump it in
+	if taskUUIDs, err := json.Marshal(group.GetUUIDs()); err == nil {
+		span.SetTag("group.tasks", string(taskUUIDs))
+	} else {
+		span.SetTag("group.tasks", group.GetUUIDs())
+	}
+
+	for _, signature := range group.Tasks {
+		signature.Headers = tracer.Inject(ctx, signature.Headers)
+	}
+}
+
+// AnnotateChord tags the span with some info about the chord and propagates
+// the trace context of ctx to the chord callback
+func AnnotateChord(ctx context.Context, tracer Tracer, span Span, chord *tasks.Chord) {
+	span.SetTag(WorkflowTagKey, "chord")
+	span.SetTag("chord.callback.uuid", chord.Callback.UUID)
+
+	chord.Callback.Headers = tracer.Inject(ctx, chord.Callback.Headers)
+}
+
+// AnnotateWorkflow tags the span with some info about the workflow and propagates
+// the trace context of ctx to all nodes of the workflow
+func AnnotateWorkflow(ctx context.Context, tracer Tracer, span Span, workflow *tasks.Workflow) {
+	span.SetTag(WorkflowTagKey, "workflow")
+	span.SetTag("workflow.uuid", workflow.WorkflowUUID)
+	span.SetTag("workflow.nodes.length", len(workflow.Nodes))
+
+	for _, signature := range workflow.Nodes {
+		signature.Headers = tracer.Inject(ctx, signature.Headers)
+	}
+}
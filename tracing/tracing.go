@@ -1,6 +1,7 @@
 package tracing
 
 import (
+	"context"
 	"encoding/json"
 
 	"github.com/oarkflow/machinery/tasks"
@@ -139,3 +140,49 @@ func AnnotateSpanWithChordInfo(span opentracing.Span, chord *tasks.Chord, sendCo
 	// tag the span for the group part of the chord
 	AnnotateSpanWithGroupInfo(span, chord.Group, sendConcurrency)
 }
+
+// OpenTracing is a Tracer using the global opentracing tracer
+type OpenTracing struct{}
+
+// StartProducerSpan starts a producer span
+func (OpenTracing) StartProducerSpan(ctx context.Context, operationName string, headers tasks.Headers) (context.Context, Span) {
+	opts := []opentracing.StartSpanOption{ProducerOption(), MachineryTag}
+	if opentracing.SpanFromContext(ctx) == nil && headers != nil {
+		if spanContext, err := opentracing.GlobalTracer().Extract(opentracing.TextMap, headers); err == nil {
+			opts = append(opts, opentracing.ChildOf(spanContext))
+		}
+	}
+
+	span, ctx := opentracing.StartSpanFromContext(ctx, operationName, opts...)
+	return ctx, openTracingSpan{span}
+}
+
+// StartConsumerSpan starts a consumer span following the producer span found in the headers
+func (OpenTracing) StartConsumerSpan(ctx context.Context, operationName string, headers tasks.Headers) (context.Context, Span) {
+	span := StartSpanFromHeaders(headers, operationName)
+	return opentracing.ContextWithSpan(ctx, span), openTracingSpan{span}
+}
+
+// Inject propagates the span of ctx in the headers
+func (OpenTracing) Inject(ctx context.Context, headers tasks.Headers) tasks.Headers {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return headers
+	}
+	return HeadersWithSpan(headers, span)
+}
+
+type openTracingSpan struct {
+	opentracing.Span
+}
+
+// SetTag sets a tag on the span
+func (s openTracingSpan) SetTag(key string, value interface{}) {
+	s.Span.SetTag(key, value)
+}
+
+// SetError marks the span as failed
+func (s openTracingSpan) SetError(err error) {
+	opentracing_ext.Error.Set(s.Span, true)
+	s.Span.LogFields(opentracing_log.Error(err))
+}
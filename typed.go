@@ -0,0 +1,101 @@
+package machinery
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/oarkflow/machinery/backends/result"
+	"github.com/oarkflow/machinery/tasks"
+)
+
+// TypedTask is a task taking a request of type Req and returning a response of type Resp.
+// Sending it and getting its response is checked at compile time.
+type TypedTask[Req, Resp any] struct {
+	server *Server
+	name   string
+}
+
+// TypedResult is the result of a TypedTask
+type TypedResult[Resp any] struct {
+	AsyncResult *result.AsyncResult
+}
+
+// Register registers a typed task with the server. Req and Resp are registered with
+// tasks.RegisterType so that structs, maps and pointers can be used as well.
+func Register[Req, Resp any](server *Server, name string, taskFunc func(context.Context, Req) (Resp, error)) (*TypedTask[Req, Resp], error) {
+	return RegisterWithOptions(server, name, taskFunc, TaskOptions{})
+}
+
+// RegisterWithOptions registers a typed task with defaults applied to all its signatures
+func RegisterWithOptions[Req, Resp any](server *Server, name string, taskFunc func(context.Context, Req) (Resp, error), options TaskOptions) (*TypedTask[Req, Resp], error) {
+	if err := tasks.RegisterType[Req](); err != nil {
+		return nil, err
+	}
+	if err := tasks.RegisterType[Resp](); err != nil {
+		return nil, err
+	}
+
+	if err := server.RegisterTaskWithOptions(name, taskFunc, options); err != nil {
+		return nil, err
+	}
+
+	return &TypedTask[Req, Resp]{server: server, name: name}, nil
+}
+
+// Name returns the name of the task
+func (task *TypedTask[Req, Resp]) Name() string {
+	return task.name
+}
+
+// Signature returns a signature of the task with the request as its argument, e.g. to set
+// options before sending it with SendSignature or to use it in a chain, group or chord
+func (task *TypedTask[Req, Resp]) Signature(req Req) *tasks.Signature {
+	return &tasks.Signature{
+		Name: task.name,
+		Args: []tasks.Arg{{Type: tasks.TypeName[Req](), Value: req}},
+	}
+}
+
+// Send sends the task with the request
+func (task *TypedTask[Req, Resp]) Send(ctx context.Context, req Req) (*TypedResult[Resp], error) {
+	return task.SendSignature(ctx, task.Signature(req))
+}
+
+// SendSignature sends a signature created by Signature
+func (task *TypedTask[Req, Resp]) SendSignature(ctx context.Context, signature *tasks.Signature) (*TypedResult[Resp], error) {
+	asyncResult, err := task.server.SendTaskWithContext(ctx, signature)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedResult[Resp]{AsyncResult: asyncResult}, nil
+}
+
+// Get waits for the response of the task until the context is done
+func (typedResult *TypedResult[Resp]) Get(ctx context.Context) (Resp, error) {
+	var resp Resp
+
+	results, err := typedResult.AsyncResult.GetWithContext(ctx)
+	if err != nil {
+		return resp, err
+	}
+	if len(results) != 1 {
+		return resp, fmt.Errorf("Task returned %d results, expected 1", len(results))
+	}
+
+	resp, ok := results[0].Interface().(Resp)
+	if !ok {
+		return resp, fmt.Errorf("Task returned %s, expected %s", results[0].Type(), tasks.TypeName[Resp]())
+	}
+
+	return resp, nil
+}
+
+// Done returns a channel closed once the task has completed, see AsyncResult.Done
+func (typedResult *TypedResult[Resp]) Done() <-chan struct{} {
+	return typedResult.AsyncResult.Done()
+}
+
+// DoneWithContext returns a channel closed once the task has completed, see AsyncResult.DoneWithContext
+func (typedResult *TypedResult[Resp]) DoneWithContext(ctx context.Context) <-chan struct{} {
+	return typedResult.AsyncResult.DoneWithContext(ctx)
+}
@@ -0,0 +1,68 @@
+package machinery
+
+import (
+	"errors"
+	"time"
+
+	"github.com/oarkflow/machinery/config"
+	"github.com/oarkflow/machinery/log"
+	"github.com/oarkflow/machinery/tasks"
+
+	lockiface "github.com/oarkflow/machinery/locks/iface"
+)
+
+// uniqueLockPrefix prefixes the locks holding unique keys of tasks
+const uniqueLockPrefix = "machinery_unique:"
+
+// ErrUniqueTasksNotSupported is returned when sending a unique task with a lock which does not implement iface.OwnedLock
+var ErrUniqueTasksNotSupported = errors.New("Lock does not support unique tasks")
+
+// lockUnique takes the unique key of the signature until the task completes or the key
+// expires. It returns the UUID of the task holding the key if it is already taken.
+func (server *Server) lockUnique(signature *tasks.Signature) (string, error) {
+	lock, ok := server.lock.(lockiface.OwnedLock)
+	if !ok {
+		return "", ErrUniqueTasksNotSupported
+	}
+
+	ttl := signature.UniqueTTL
+	if ttl <= 0 {
+		expiresIn := server.config.ResultsExpireIn
+		if expiresIn <= 0 {
+			expiresIn = config.DefaultResultsExpireIn
+		}
+		ttl = time.Duration(expiresIn) * time.Second
+	}
+
+	// A delayed task, such as a retry, holds the key from its ETA on
+	expiresAt := time.Now()
+	if signature.ETA != nil && signature.ETA.After(expiresAt) {
+		expiresAt = *signature.ETA
+	}
+
+	// The key is owned by the task UUID, so retries of the task keep holding it and extend it
+	acquired, owner, err := lock.LockOwned(uniqueLockPrefix+signature.UniqueKey, signature.UUID, expiresAt.Add(ttl).UnixNano())
+	if err != nil {
+		return "", err
+	}
+	if acquired {
+		return "", nil
+	}
+	return owner, nil
+}
+
+// unlockUnique releases the unique key of the signature once the task is done
+func (server *Server) unlockUnique(signature *tasks.Signature) {
+	if signature.UniqueKey == "" {
+		return
+	}
+
+	lock, ok := server.lock.(lockiface.OwnedLock)
+	if !ok {
+		return
+	}
+
+	if err := lock.UnlockOwned(uniqueLockPrefix+signature.UniqueKey, signature.UUID); err != nil {
+		log.ERROR.Printf("Failed to release unique key %s of task %s: %s", signature.UniqueKey, signature.UUID, err)
+	}
+}
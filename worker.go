@@ -1,23 +1,29 @@
 package machinery
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
 
-	"github.com/opentracing/opentracing-go"
-
 	"github.com/oarkflow/machinery/backends/amqp"
 	"github.com/oarkflow/machinery/brokers/errs"
+	"github.com/oarkflow/machinery/claimcheck"
+	"github.com/oarkflow/machinery/common"
 	"github.com/oarkflow/machinery/log"
 	"github.com/oarkflow/machinery/retry"
+	"github.com/oarkflow/machinery/security"
 	"github.com/oarkflow/machinery/tasks"
 	"github.com/oarkflow/machinery/tracing"
+
+	eagerbroker "github.com/oarkflow/machinery/brokers/eager"
+	brokersiface "github.com/oarkflow/machinery/brokers/iface"
 )
 
 // Worker represents a single worker process
@@ -30,6 +36,12 @@ type Worker struct {
 	preTaskHandler    func(*tasks.Signature)
 	postTaskHandler   func(*tasks.Signature)
 	preConsumeHandler func(*Worker) bool
+	// queues is set for workers consuming several queues, see NewMultiQueueWorker
+	queues *queueSelector
+	// taskSlots holds a semaphore per task name limited by TaskOptions.MaxConcurrency
+	taskSlots sync.Map
+	// middlewares wrap execution of every task
+	middlewares []Middleware
 }
 
 var (
@@ -57,7 +69,9 @@ func (worker *Worker) LaunchAsync(errorsChan chan<- error) {
 	// Log some useful information about worker configuration
 	log.INFO.Printf("Launching a worker with the following settings:")
 	log.INFO.Printf("- Broker: %s", RedactURL(cnf.Broker))
-	if worker.Queue == "" {
+	if worker.queues != nil {
+		log.INFO.Printf("- CustomQueues: %v", worker.queues.names())
+	} else if worker.Queue == "" {
 		log.INFO.Printf("- DefaultQueue: %s", cnf.DefaultQueue)
 	} else {
 		log.INFO.Printf("- CustomQueue: %s", worker.Queue)
@@ -71,6 +85,13 @@ func (worker *Worker) LaunchAsync(errorsChan chan<- error) {
 		log.INFO.Printf("  - PrefetchCount: %d", cnf.AMQP.PrefetchCount)
 	}
 
+	if worker.queues != nil {
+		if _, ok := broker.(brokersiface.MultiQueueBroker); !ok {
+			go func() { errorsChan <- errs.ErrMultiQueueNotSupported }()
+			return
+		}
+	}
+
 	var signalWG sync.WaitGroup
 	// Goroutine to start broker consumption and handle retries when broker connection dies
 	go func() {
@@ -78,6 +99,7 @@ func (worker *Worker) LaunchAsync(errorsChan chan<- error) {
 			retry, err := broker.StartConsuming(worker.ConsumerTag, worker.Concurrency, worker)
 
 			if retry {
+				worker.server.metrics.BrokerError("consume")
 				if worker.errorHandler != nil {
 					worker.errorHandler(err)
 				} else {
@@ -142,11 +164,52 @@ func (worker *Worker) Process(signature *tasks.Signature) error {
 		return nil
 	}
 
+	// Put the message off if the task already runs as many times as it is allowed to
+	release, ok := worker.acquireTaskSlot(signature)
+	if !ok {
+		delay := worker.server.GetTaskOptions(signature.Name).saturationDelay(deferrals(signature))
+		log.DEBUG.Printf("Task %s reached its concurrency limit. Deferring %s by %s", signature.Name, signature.UUID, delay)
+		return worker.deferTask(signature, delay)
+	}
+	defer release()
+
+	// Put the message off until the rate limit of the task allows it to run
+	if delay, limited := worker.rateLimit(signature); limited {
+		log.DEBUG.Printf("Task %s reached its rate limit. Deferring %s by %s", signature.Name, signature.UUID, delay)
+		return worker.deferTask(signature, delay)
+	}
+
+	queue := signature.RoutingKey
+	worker.server.metrics.TaskReceived(signature.Name, queue)
+	if wait, ok := queueWait(signature); ok {
+		worker.server.metrics.QueueWait(signature.Name, queue, wait)
+	}
+	worker.server.metrics.InFlight(worker.ConsumerTag, 1)
+	defer worker.server.metrics.InFlight(worker.ConsumerTag, -1)
+
+	// The task runs, messages published from now on are not deferrals
+	delete(signature.Headers, tasks.DeferralsHeader)
+
+	// Skip the task if it has been revoked before it was received
+	if worker.server.IsTaskRevoked(signature.UUID) {
+		log.WARNING.Printf("Task %s has been revoked. Skipping it.", signature.UUID)
+		worker.server.unlockUnique(signature)
+		worker.deleteAllArgs(signature)
+		return nil
+	}
+
 	// Update task state to RECEIVED
 	if err = worker.server.GetBackend().SetStateReceived(signature); err != nil {
+		worker.server.metrics.BackendError("set_state_received")
 		return fmt.Errorf("Set state to 'received' for task %s returned error: %s", signature.UUID, err)
 	}
 
+	// Load the arguments kept in the blob store
+	if err = worker.resolveArgs(signature); err != nil {
+		worker.taskFailed(signature, err)
+		return err
+	}
+
 	// Prepare task for processing
 	task, err := tasks.NewWithSignature(taskFunc, signature)
 	// if this failed, it means the task is malformed, probably has invalid
@@ -156,18 +219,37 @@ func (worker *Worker) Process(signature *tasks.Signature) error {
 		return err
 	}
 
-	// try to extract trace span from headers and add it to the function context
-	// so it can be used inside the function if it has context.Context as the first
-	// argument. Start a new span if it isn't found.
-	taskSpan := tracing.StartSpanFromHeaders(signature.Headers, signature.Name)
-	tracing.AnnotateSpanWithSignatureInfo(taskSpan, signature)
-	task.Context = opentracing.ContextWithSpan(task.Context, taskSpan)
+	// Apply time limits registered with the task unless the signature sets its own
+	worker.server.GetTaskOptions(signature.Name).applyTo(task)
+
+	// Cancel the task context as soon as the task gets revoked
+	ctx, cancel := context.WithCancel(task.Context)
+	defer cancel()
+	task.Context = ctx
+
+	// Let the task report its progress to the result backend
+	task.Context = tasks.ContextWithProgress(task.Context, tasks.NewProgress(func(progress *tasks.TaskProgress) error {
+		return worker.server.GetBackend().SetStateProgress(signature, progress)
+	}))
 
-	// Update task state to STARTED
+	// Update task state to STARTED, the state of a task revoked meanwhile stays REVOKED
 	if err = worker.server.GetBackend().SetStateStarted(signature); err != nil {
+		worker.server.metrics.BackendError("set_state_started")
 		return fmt.Errorf("Set state to 'started' for task %s returned error: %s", signature.UUID, err)
 	}
 
+	// Watch for revocation, the task is canceled if it is able to observe its context
+	stopWatching := worker.watchRevocation(signature.UUID, cancel)
+
+	// Skip the task if it has been revoked since it was received
+	if worker.server.IsTaskRevoked(signature.UUID) {
+		stopWatching()
+		log.WARNING.Printf("Task %s has been revoked. Skipping it.", signature.UUID)
+		worker.server.unlockUnique(signature)
+		worker.deleteAllArgs(signature)
+		return nil
+	}
+
 	// Run handler before the task is called
 	if worker.preTaskHandler != nil {
 		worker.preTaskHandler(signature)
@@ -178,8 +260,34 @@ func (worker *Worker) Process(signature *tasks.Signature) error {
 		defer worker.postTaskHandler(signature)
 	}
 
-	// Call the task
-	results, err := task.Call()
+	// try to extract trace span from headers and add it to the function context
+	// so it can be used inside the function if it has context.Context as the first
+	// argument. Start a new span if it isn't found.
+	spanCtx, taskSpan := worker.server.GetTracer().StartConsumerSpan(task.Context, signature.Name, signature.Headers)
+	tracing.AnnotateSignature(taskSpan, signature)
+	task.Context = spanCtx
+
+	// Call the task through the middlewares
+	handler := worker.wrapHandler(func(ctx context.Context, signature *tasks.Signature) ([]*tasks.TaskResult, error) {
+		task.Context = ctx
+		return task.Call()
+	})
+	startedAt := time.Now()
+	results, err := handler(task.Context, signature)
+	worker.server.metrics.TaskDuration(signature.Name, queue, time.Since(startedAt))
+	if err != nil {
+		taskSpan.SetError(err)
+	}
+	taskSpan.Finish()
+
+	// The state stays REVOKED, do not retry or trigger any callbacks
+	if stopWatching() {
+		log.WARNING.Printf("Task %s has been revoked while running.", signature.UUID)
+		worker.server.unlockUnique(signature)
+		worker.deleteAllArgs(signature)
+		return nil
+	}
+
 	if err != nil {
 		// If a tasks.ErrRetryTaskLater was returned from the task,
 		// retry the task after specified duration
@@ -189,8 +297,8 @@ func (worker *Worker) Process(signature *tasks.Signature) error {
 		}
 
 		// Otherwise, execute default retry logic based on signature.RetryCount
-		// and signature.RetryTimeout values
-		if signature.RetryCount > 0 {
+		// and the retry policy, permanent errors are never retried
+		if signature.RetryCount > 0 && retry.IsRetryable(worker.retryPolicy(signature), err) {
 			return worker.taskRetry(signature)
 		}
 
@@ -204,33 +312,144 @@ func (worker *Worker) Process(signature *tasks.Signature) error {
 func (worker *Worker) taskRetry(signature *tasks.Signature) error {
 	// Update task state to RETRY
 	if err := worker.server.GetBackend().SetStateRetry(signature); err != nil {
+		worker.server.metrics.BackendError("set_state_retry")
 		return fmt.Errorf("Set state to 'retry' for task %s returned error: %s", signature.UUID, err)
 	}
 
+	worker.server.metrics.TaskRetried(signature.Name, signature.RoutingKey)
+
 	// Decrement the retry counter, when it reaches 0, we won't retry again
 	signature.RetryCount--
+	signature.RetryAttempt++
 
-	// Increase retry timeout
-	signature.RetryTimeout = retry.FibonacciNext(signature.RetryTimeout)
+	var retryIn time.Duration
+	if policy := worker.retryPolicy(signature); policy != nil {
+		retryIn = policy.NextDelay(signature.RetryAttempt)
+		signature.RetryTimeout = int(retryIn.Seconds())
+	} else {
+		// Increase retry timeout
+		signature.RetryTimeout = retry.FibonacciNext(signature.RetryTimeout)
+		retryIn = time.Second * time.Duration(signature.RetryTimeout)
+	}
 
-	// Delay task by signature.RetryTimeout seconds
-	eta := time.Now().UTC().Add(time.Second * time.Duration(signature.RetryTimeout))
+	// Delay task by retryIn duration
+	eta := time.Now().UTC().Add(retryIn)
 	signature.ETA = &eta
 
-	log.WARNING.Printf("Task %s failed. Going to retry in %d seconds.", signature.UUID, signature.RetryTimeout)
+	log.WARNING.Printf("Task %s failed. Going to retry in %.0f seconds.", signature.UUID, retryIn.Seconds())
 
 	// Send the task back to the queue
 	_, err := worker.server.SendTask(signature)
 	return err
 }
 
+// retryPolicy returns the retry policy named by the signature or the one of the registered task.
+// It returns nil when neither is set, so that the default Fibonacci backoff is used.
+func (worker *Worker) retryPolicy(signature *tasks.Signature) retry.Policy {
+	if signature.RetryPolicy != "" {
+		if policy, ok := retry.GetPolicy(signature.RetryPolicy); ok {
+			return policy
+		}
+		log.WARNING.Printf("Retry policy %s of task %s is not registered, using the default one", signature.RetryPolicy, signature.UUID)
+	}
+	return worker.server.GetTaskOptions(signature.Name).RetryPolicy
+}
+
+// acquireTaskSlot takes one of the slots of a task limited by TaskOptions.MaxConcurrency.
+// It returns false if all the slots are taken. In eager mode a saturated task runs without
+// a slot, as it runs within the task or callback sending it, which may hold the slots itself.
+func (worker *Worker) acquireTaskSlot(signature *tasks.Signature) (func(), bool) {
+	maxConcurrency := worker.server.GetTaskOptions(signature.Name).MaxConcurrency
+	if maxConcurrency <= 0 {
+		return func() {}, true
+	}
+
+	value, _ := worker.taskSlots.LoadOrStore(signature.Name, make(chan struct{}, maxConcurrency))
+	slots := value.(chan struct{})
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, true
+	default:
+	}
+
+	if _, ok := worker.server.GetBroker().(eagerbroker.Mode); ok {
+		return func() {}, true
+	}
+	return nil, false
+}
+
+// rateLimit takes a token from the rate limiter for tasks registered with TaskOptions.RateLimit.
+// It returns true and the time until a token is available if the task is over its limit, except
+// in eager mode where it waits for the token. The task runs if the rate limiter fails.
+func (worker *Worker) rateLimit(signature *tasks.Signature) (time.Duration, bool) {
+	options := worker.server.GetTaskOptions(signature.Name)
+	if options.RateLimit.IsZero() {
+		return 0, false
+	}
+
+	_, eager := worker.server.GetBroker().(eagerbroker.Mode)
+	key := options.rateLimitKey(signature)
+
+	for {
+		allowed, wait, err := worker.server.GetRateLimiter().Allow(key, options.RateLimit)
+		if err != nil {
+			log.ERROR.Printf("Rate limiter error for task %s: %s", signature.UUID, err)
+			return 0, false
+		}
+		if allowed {
+			return 0, false
+		}
+		if !eager {
+			return wait, true
+		}
+		time.Sleep(wait)
+	}
+}
+
+// deferTask publishes the message of a saturated or rate limited task again with an ETA. It is
+// not a retry, the state of the task stays PENDING and the retry count is not affected. The
+// message keeps the time it became due at, so that its queue wait includes the deferrals.
+func (worker *Worker) deferTask(signature *tasks.Signature, delay time.Duration) error {
+	headers := make(tasks.Headers, len(signature.Headers)+2)
+	for key, value := range signature.Headers {
+		headers[key] = value
+	}
+	if dueAt, ok := dueAt(signature); ok {
+		headers[tasks.PublishedAtHeader] = dueAt.Format(time.RFC3339Nano)
+	}
+	headers[tasks.DeferralsHeader] = strconv.Itoa(deferrals(signature) + 1)
+	signature.Headers = headers
+
+	eta := time.Now().UTC().Add(delay)
+	signature.ETA = &eta
+
+	return worker.server.publish(context.Background(), signature)
+}
+
+// deferrals returns the number of times the message has been put off
+func deferrals(signature *tasks.Signature) int {
+	value, ok := signature.Headers[tasks.DeferralsHeader].(string)
+	if !ok {
+		return 0
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil {
+		return 0
+	}
+	return count
+}
+
 // taskRetryIn republishes the task to the queue with ETA of now + retryIn.Seconds()
 func (worker *Worker) retryTaskIn(signature *tasks.Signature, retryIn time.Duration) error {
 	// Update task state to RETRY
 	if err := worker.server.GetBackend().SetStateRetry(signature); err != nil {
+		worker.server.metrics.BackendError("set_state_retry")
 		return fmt.Errorf("Set state to 'retry' for task %s returned error: %s", signature.UUID, err)
 	}
 
+	worker.server.metrics.TaskRetried(signature.Name, signature.RoutingKey)
+
 	// Delay task by retryIn duration
 	eta := time.Now().UTC().Add(retryIn)
 	signature.ETA = &eta
@@ -242,14 +461,125 @@ func (worker *Worker) retryTaskIn(signature *tasks.Signature, retryIn time.Durat
 	return err
 }
 
+// resolveArgs loads the arguments of the signature kept in the blob store
+func (worker *Worker) resolveArgs(signature *tasks.Signature) error {
+	claimCheck, err := claimcheck.ForConfig(worker.server.GetConfig())
+	if err != nil {
+		return err
+	}
+	return claimCheck.ResolveSignature(context.Background(), signature)
+}
+
+// deleteArgs removes the arguments of the signatures kept in the blob store, once the tasks
+// will not run again or callbacks will not be sent
+func (worker *Worker) deleteArgs(signatures ...*tasks.Signature) {
+	claimCheck, err := claimcheck.ForConfig(worker.server.GetConfig())
+	if err != nil {
+		log.WARNING.Printf("Failed to delete arguments: %s", err)
+		return
+	}
+
+	for _, signature := range signatures {
+		if signature == nil {
+			continue
+		}
+		if err := claimCheck.DeleteArgs(context.Background(), signature); err != nil {
+			log.WARNING.Printf("Failed to delete arguments of task %s: %s", signature.UUID, err)
+		}
+	}
+}
+
+// deleteAllArgs removes the arguments of the signature and of its callbacks kept in the blob store
+func (worker *Worker) deleteAllArgs(signature *tasks.Signature) {
+	worker.deleteArgs(signature, signature.ChordCallback)
+	worker.deleteArgs(signature.OnSuccess...)
+	worker.deleteArgs(signature.OnError...)
+}
+
+// encodeTaskResults returns the task results as they are stored in the backend, with the values
+// above the compression threshold compressed, all of them encrypted and the values above the
+// claim check threshold kept in the blob store if configured
+func (worker *Worker) encodeTaskResults(signature *tasks.Signature, taskResults []*tasks.TaskResult) ([]*tasks.TaskResult, error) {
+	cnf := worker.server.GetConfig()
+	sec, err := security.ForConfig(cnf)
+	if err != nil {
+		return nil, err
+	}
+	claimCheck, err := claimcheck.ForConfig(cnf)
+	if err != nil {
+		return nil, err
+	}
+
+	if cnf.Compression != "" {
+		compress := func(data []byte) ([]byte, string, error) {
+			return common.Compress(cnf, data)
+		}
+		compressed := make([]*tasks.TaskResult, len(taskResults))
+		for i, taskResult := range taskResults {
+			result, err := taskResult.Compress(compress)
+			if err != nil {
+				return nil, err
+			}
+			compressed[i] = result
+		}
+		taskResults = compressed
+	}
+
+	if taskResults, err = sec.SealTaskResults(signature.UUID, taskResults); err != nil {
+		return nil, err
+	}
+	return claimCheck.CheckTaskResults(context.Background(), signature.UUID, taskResults)
+}
+
+// decodeTaskResults loads, decrypts and decompresses the results of a task state read from the backend
+func (worker *Worker) decodeTaskResults(taskState *tasks.TaskState) error {
+	claimCheck, err := claimcheck.ForConfig(worker.server.GetConfig())
+	if err != nil {
+		return err
+	}
+	if err := claimCheck.ResolveTaskResults(context.Background(), taskState.Results); err != nil {
+		return err
+	}
+
+	sec, err := security.ForConfig(worker.server.GetConfig())
+	if err != nil {
+		return err
+	}
+	if err := sec.OpenTaskResults(taskState.TaskUUID, taskState.Results); err != nil {
+		return err
+	}
+
+	maxSize := common.MaxDecompressedSize(worker.server.GetConfig())
+	for _, taskResult := range taskState.Results {
+		if err := taskResult.Decompress(maxSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // taskSucceeded updates the task state and triggers success callbacks or a
 // chord callback if this was the last task of a group with a chord callback
 func (worker *Worker) taskSucceeded(signature *tasks.Signature, taskResults []*tasks.TaskResult) error {
-	// Update task state to SUCCESS
-	if err := worker.server.GetBackend().SetStateSuccess(signature, taskResults); err != nil {
+	// A task with the same unique key can be sent again
+	worker.server.unlockUnique(signature)
+
+	// Update task state to SUCCESS, results get compressed and encrypted as configured
+	storedResults, err := worker.encodeTaskResults(signature, taskResults)
+	if err != nil {
+		return fmt.Errorf("Encoding results of task %s returned error: %s", signature.UUID, err)
+	}
+	if err := worker.server.GetBackend().SetStateSuccess(signature, storedResults); err != nil {
+		worker.server.metrics.BackendError("set_state_success")
 		return fmt.Errorf("Set state to 'success' for task %s returned error: %s", signature.UUID, err)
 	}
 
+	worker.server.metrics.TaskSucceeded(signature.Name, signature.RoutingKey)
+
+	// Error callbacks are not sent, the arguments of the task are not needed anymore
+	worker.deleteArgs(signature)
+	worker.deleteArgs(signature.OnError...)
+
 	// Log human readable results of the processed task
 	var debugResults = "[]"
 	results, err := tasks.ReflectTaskResults(taskResults)
@@ -263,6 +593,11 @@ func (worker *Worker) taskSucceeded(signature *tasks.Signature, taskResults []*t
 	// Trigger success callbacks
 
 	for _, successTask := range signature.OnSuccess {
+		if worker.server.IsTaskRevoked(successTask.UUID) {
+			worker.deleteArgs(successTask)
+			continue
+		}
+
 		if signature.Immutable == false {
 			// Pass results of the task to success callbacks
 			for _, taskResult := range taskResults {
@@ -276,6 +611,13 @@ func (worker *Worker) taskSucceeded(signature *tasks.Signature, taskResults []*t
 		worker.server.SendTask(successTask)
 	}
 
+	// Trigger the nodes of the workflow depending on the task
+	if signature.WorkflowUUID != "" {
+		if err := worker.triggerWorkflowNodes(signature, taskResults); err != nil {
+			return err
+		}
+	}
+
 	// If the task was not part of a group, just return
 	if signature.GroupUUID == "" {
 		return nil
@@ -286,12 +628,21 @@ func (worker *Worker) taskSucceeded(signature *tasks.Signature, taskResults []*t
 		return nil
 	}
 
+	// Every task of the group carries a copy of the chord callback, which is sent by one of them
+	chordSent := false
+	defer func() {
+		if !chordSent {
+			worker.deleteArgs(signature.ChordCallback)
+		}
+	}()
+
 	// Check if all task in the group has completed
 	groupCompleted, err := worker.server.GetBackend().GroupCompleted(
 		signature.GroupUUID,
 		signature.GroupTaskCount,
 	)
 	if err != nil {
+		worker.server.metrics.BackendError("group_completed")
 		return fmt.Errorf("Completed check for group %s returned error: %s", signature.GroupUUID, err)
 	}
 
@@ -308,6 +659,7 @@ func (worker *Worker) taskSucceeded(signature *tasks.Signature, taskResults []*t
 	// Trigger chord callback
 	shouldTrigger, err := worker.server.GetBackend().TriggerChord(signature.GroupUUID)
 	if err != nil {
+		worker.server.metrics.BackendError("trigger_chord")
 		return fmt.Errorf("Triggering chord for group %s returned error: %s", signature.GroupUUID, err)
 	}
 
@@ -316,12 +668,18 @@ func (worker *Worker) taskSucceeded(signature *tasks.Signature, taskResults []*t
 		return nil
 	}
 
+	// Chord callback has been revoked
+	if worker.server.IsTaskRevoked(signature.ChordCallback.UUID) {
+		return nil
+	}
+
 	// Get task states
 	taskStates, err := worker.server.GetBackend().GroupTaskStates(
 		signature.GroupUUID,
 		signature.GroupTaskCount,
 	)
 	if err != nil {
+		worker.server.metrics.BackendError("group_task_states")
 		log.ERROR.Printf(
 			"Failed to get tasks states for group:[%s]. Task count:[%d]. The chord may not be triggered. Error:[%s]",
 			signature.GroupUUID,
@@ -338,6 +696,10 @@ func (worker *Worker) taskSucceeded(signature *tasks.Signature, taskResults []*t
 		}
 
 		if signature.ChordCallback.Immutable == false {
+			if err := worker.decodeTaskResults(taskState); err != nil {
+				return fmt.Errorf("Decoding results of task %s returned error: %s", taskState.TaskUUID, err)
+			}
+
 			// Pass results of the task to the chord callback
 			for _, taskResult := range taskState.Results {
 				signature.ChordCallback.Args = append(signature.ChordCallback.Args, tasks.Arg{
@@ -354,16 +716,175 @@ func (worker *Worker) taskSucceeded(signature *tasks.Signature, taskResults []*t
 		return err
 	}
 
+	chordSent = true
+	return nil
+}
+
+// triggerWorkflowNodes sends the nodes of the workflow which depend on the succeeded node. A node
+// depending on several nodes is sent by the worker processing the last of them to succeed.
+func (worker *Worker) triggerWorkflowNodes(signature *tasks.Signature, taskResults []*tasks.TaskResult) error {
+	workflow, err := worker.server.GetWorkflow(signature.WorkflowUUID)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range workflow.Dependents(signature.WorkflowNode) {
+		node, err := worker.workflowNode(workflow, name)
+		if err != nil {
+			return err
+		}
+		dependencies := workflow.Dependencies[name]
+
+		if len(dependencies) == 1 {
+			// Node has been revoked
+			if worker.server.IsTaskRevoked(node.UUID) {
+				continue
+			}
+
+			if node.Immutable == false {
+				// Pass results of the task to the node
+				for _, taskResult := range taskResults {
+					node.Args = append(node.Args, tasks.Arg{
+						Type:  taskResult.Type,
+						Value: taskResult.Value,
+					})
+				}
+			}
+
+			if _, err := worker.server.SendTask(node); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := worker.triggerWorkflowJoin(workflow, node, dependencies); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// workflowNode returns the signature of the node with the arguments kept encrypted in the
+// workflow definition decrypted, so that results can be appended to them
+func (worker *Worker) workflowNode(workflow *tasks.Workflow, name string) (*tasks.Signature, error) {
+	sec, err := security.ForConfig(worker.server.GetConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	node := workflow.NodeSignature(name)
+	if err := sec.OpenSignature(node); err != nil {
+		return nil, fmt.Errorf("Decrypting workflow node %s returned error: %s", name, err)
+	}
+	return node, nil
+}
+
+// triggerWorkflowJoin sends the node depending on several nodes once all of them succeeded, with
+// their results in the order of the dependencies
+func (worker *Worker) triggerWorkflowJoin(workflow *tasks.Workflow, node *tasks.Signature, dependencies []string) error {
+	groupUUID := workflow.NodeGroupUUID(node.WorkflowNode)
+
+	// Check if all dependencies of the node have completed
+	groupCompleted, err := worker.server.GetBackend().GroupCompleted(groupUUID, len(dependencies))
+	if err != nil {
+		worker.server.metrics.BackendError("group_completed")
+		return fmt.Errorf("Completed check for group %s returned error: %s", groupUUID, err)
+	}
+
+	// If the dependencies have not yet completed, just return
+	if !groupCompleted {
+		return nil
+	}
+
+	// Defer purging of group meta queue if we are using AMQP backend
+	if worker.hasAMQPBackend() {
+		defer worker.server.GetBackend().PurgeGroupMeta(groupUUID)
+	}
+
+	// Make sure the node is triggered once only
+	shouldTrigger, err := worker.server.GetBackend().TriggerChord(groupUUID)
+	if err != nil {
+		worker.server.metrics.BackendError("trigger_chord")
+		return fmt.Errorf("Triggering workflow node for group %s returned error: %s", groupUUID, err)
+	}
+
+	// Node has already been triggered
+	if !shouldTrigger {
+		return nil
+	}
+
+	// Node has been revoked
+	if worker.server.IsTaskRevoked(node.UUID) {
+		return nil
+	}
+
+	// Get task states
+	taskStates, err := worker.server.GetBackend().GroupTaskStates(groupUUID, len(dependencies))
+	if err != nil {
+		worker.server.metrics.BackendError("group_task_states")
+		log.ERROR.Printf(
+			"Failed to get tasks states for group:[%s]. Task count:[%d]. The workflow node may not be triggered. Error:[%s]",
+			groupUUID,
+			len(dependencies),
+			err,
+		)
+		return nil
+	}
+
+	taskStatesByUUID := make(map[string]*tasks.TaskState, len(taskStates))
+	for _, taskState := range taskStates {
+		taskStatesByUUID[taskState.TaskUUID] = taskState
+	}
+
+	// Append the return values of the dependencies to the node if it's not immutable
+	for _, taskUUID := range workflow.GetDependencyUUIDs(node.WorkflowNode) {
+		taskState, ok := taskStatesByUUID[taskUUID]
+		if !ok || !taskState.IsSuccess() {
+			return nil
+		}
+
+		if node.Immutable == false {
+			if err := worker.decodeTaskResults(taskState); err != nil {
+				return fmt.Errorf("Decoding results of task %s returned error: %s", taskState.TaskUUID, err)
+			}
+
+			// Pass results of the task to the node
+			for _, taskResult := range taskState.Results {
+				node.Args = append(node.Args, tasks.Arg{
+					Type:  taskResult.Type,
+					Value: taskResult.Value,
+				})
+			}
+		}
+	}
+
+	// Send the node
+	_, err = worker.server.SendTask(node)
+	return err
+}
+
 // taskFailed updates the task state and triggers error callbacks
 func (worker *Worker) taskFailed(signature *tasks.Signature, taskErr error) error {
+	// A task with the same unique key can be sent again
+	worker.server.unlockUnique(signature)
+
 	// Update task state to FAILURE
 	if err := worker.server.GetBackend().SetStateFailure(signature, taskErr.Error()); err != nil {
+		worker.server.metrics.BackendError("set_state_failure")
 		return fmt.Errorf("Set state to 'failure' for task %s returned error: %s", signature.UUID, err)
 	}
 
+	worker.server.metrics.TaskFailed(signature.Name, signature.RoutingKey)
+
+	// Native SQS redrive policies take care of messages which are not deleted. The arguments of
+	// the task and of its success callbacks, which are not sent, are kept for messages which stay
+	// in the queue or go to the dead-letter queue.
+	if !signature.StopTaskDeletionOnError && !worker.deadLetter(signature, taskErr) {
+		worker.deleteArgs(signature, signature.ChordCallback)
+		worker.deleteArgs(signature.OnSuccess...)
+	}
+
 	if worker.errorHandler != nil {
 		worker.errorHandler(taskErr)
 	} else {
@@ -372,6 +893,11 @@ func (worker *Worker) taskFailed(signature *tasks.Signature, taskErr error) erro
 
 	// Trigger error callbacks
 	for _, errorTask := range signature.OnError {
+		if worker.server.IsTaskRevoked(errorTask.UUID) {
+			worker.deleteArgs(errorTask)
+			continue
+		}
+
 		// Pass error as a first argument to error callbacks
 		args := append([]tasks.Arg{{
 			Type:  "string",
@@ -388,6 +914,80 @@ func (worker *Worker) taskFailed(signature *tasks.Signature, taskErr error) erro
 	return nil
 }
 
+// queueWait returns the time the task spent in the queue since it was published
+// or became due, if it was published with a timestamp header
+func queueWait(signature *tasks.Signature) (time.Duration, bool) {
+	dueAt, ok := dueAt(signature)
+	if !ok {
+		return 0, false
+	}
+	return time.Since(dueAt), true
+}
+
+// dueAt returns the time the task was published at, or its ETA if later. The ETA
+// of a deferred message is ignored, its timestamp is the time it first became due at
+func dueAt(signature *tasks.Signature) (time.Time, bool) {
+	value, ok := signature.Headers[tasks.PublishedAtHeader].(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	publishedAt, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if deferrals(signature) > 0 {
+		return publishedAt, true
+	}
+	if signature.ETA != nil && signature.ETA.After(publishedAt) {
+		publishedAt = *signature.ETA
+	}
+	return publishedAt, true
+}
+
+// deadLetter publishes a task which failed for good to the dead-letter queue, if configured.
+// It returns true if the task has been published.
+func (worker *Worker) deadLetter(signature *tasks.Signature, taskErr error) bool {
+	if worker.server.GetConfig().DeadLetterQueue == "" {
+		return false
+	}
+
+	broker, ok := worker.server.GetBroker().(brokersiface.DeadLetterBroker)
+	if !ok {
+		log.WARNING.Printf("Broker does not support dead letters, dropping task %s", signature.UUID)
+		return false
+	}
+
+	claimCheck, err := claimcheck.ForConfig(worker.server.GetConfig())
+	if err != nil {
+		log.ERROR.Printf("Failed to publish task %s to the dead-letter queue: %s", signature.UUID, err)
+		return false
+	}
+	// Keep oversized arguments out of the dead-letter queue as well
+	deadLetter, err := claimCheck.CheckSignature(context.Background(), tasks.NewDeadLetter(signature, taskErr))
+	if err != nil {
+		log.ERROR.Printf("Failed to publish task %s to the dead-letter queue: %s", signature.UUID, err)
+		return false
+	}
+
+	if err := broker.PublishDeadLetter(context.Background(), deadLetter); err != nil {
+		log.ERROR.Printf("Failed to publish task %s to the dead-letter queue: %s", signature.UUID, err)
+		return false
+	}
+	return true
+}
+
+// watchRevocation watches the task while it is running and cancels the task context
+// once the task gets revoked. The returned func stops watching and reports whether
+// the task has been revoked
+func (worker *Worker) watchRevocation(taskUUID string, cancel context.CancelFunc) func() bool {
+	// Revocation can not be checked with the AMQP backend
+	if worker.hasAMQPBackend() {
+		return func() bool { return false }
+	}
+
+	return worker.server.revocations.watch(taskUUID, cancel)
+}
+
 // Returns true if the worker uses AMQP backend
 func (worker *Worker) hasAMQPBackend() bool {
 	_, ok := worker.server.GetBackend().(*amqp.Backend)